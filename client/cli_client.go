@@ -1,25 +1,80 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"example.com/contacts/server"
 )
 
+// Asks the user for a passphrase - confirm is set when encrypting, so the passphrase should be typed twice
+type PassphrasePrompt func(confirm bool) ([]byte, error)
+
 type CliClient struct {
-	client Client
+	client     Client
+	passphrase PassphrasePrompt
+	out        io.Writer
+	format     *server.Codec
 }
 
 func NewCliClient(client Client) *CliClient {
 	return &CliClient{
 		client: client,
+		passphrase: func(bool) ([]byte, error) {
+			return nil, errors.New("passphrase prompt not available")
+		},
+		out: os.Stdout,
 	}
 }
 
+// Sets where contacts printed with --format are written, stdout by default
+func (c *CliClient) WithOutput(out io.Writer) *CliClient {
+	c.out = out
+	return c
+}
+
+func (c *CliClient) WithPassphrasePrompt(prompt PassphrasePrompt) *CliClient {
+	c.passphrase = prompt
+	return c
+}
+
 func (c *CliClient) HandleCommand(args []string) {
+	args, format, err := extractOption(args, "--format")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	args, book, err := extractOption(args, "--book")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if book != "" {
+		defer func(client Client) { c.client = client }(c.client)
+		c.client = c.client.InBook(book)
+	}
+
+	c.format = nil
+	if format != "" {
+		if c.format = server.LookupCodec(format); c.format == nil {
+			log.Print("Unknown format ", format, " - use json, yaml, csv or vcard")
+			return
+		}
+	}
+
 	if len(args) < 1 {
-		log.Print("Usage: ./client <add|delete|update|findByEmail|findByLastNamePart> [...]")
+		log.Print("Usage: ./client [--format <json|yaml|csv|vcard>] [--book <name>] <add|delete|update|set|findByEmail|findByLastNamePart|duplicates|merge|import|export|backup|restore|books|keys|audit> [...]")
 		return
 	}
 
@@ -106,6 +161,36 @@ func (c *CliClient) HandleCommand(args []string) {
 		return
 	}
 
+	if args[0] == "set" {
+		if len(args) < 3 {
+			log.Print("Usage: ./client set <id> <field>=<value> [...] (phones are comma separated, empty value clears a field)")
+			return
+		}
+
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		patch, err := parseSetArgs(args[2:])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		resp, err := c.client.Patch(id, server.MergePatchContentType, patch)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if resp == nil {
+			log.Print("Failed to update contact - not found by id")
+			return
+		}
+		log.Print("Successfully updated contact: ", *resp)
+		return
+	}
+
 	if args[0] == "findByEmail" {
 		if len(args) < 1 {
 			log.Print("Usage: ./client findByEmail <email>")
@@ -124,7 +209,7 @@ func (c *CliClient) HandleCommand(args []string) {
 			return
 		}
 
-		log.Print("Found contacts: ", resp)
+		c.printContacts(resp)
 		return
 	}
 
@@ -144,9 +229,717 @@ func (c *CliClient) HandleCommand(args []string) {
 			log.Print("Contact not found")
 			return
 		}
-		log.Print("Found contacts: ", resp)
+		c.printContacts(resp)
+		return
+	}
+
+	if args[0] == "duplicates" {
+		threshold := server.DefaultDuplicateThreshold
+		if len(args) > 1 {
+			var err error
+			threshold, err = strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+
+		resp, err := c.client.FindDuplicates(threshold)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if len(resp) == 0 {
+			log.Print("No duplicates found")
+			return
+		}
+		for _, cluster := range resp {
+			log.Printf("Possible duplicates (score %.2f): %v", cluster.Score, cluster.Contacts)
+		}
+		return
+	}
+
+	if args[0] == "merge" {
+		if len(args) < 3 {
+			log.Print("Usage: ./client merge <id> <id> [...] [field=<newest|longest|union|choose:<id>> ...]")
+			return
+		}
+
+		mergeReq, err := parseMergeArgs(args[1:])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		resp, err := c.client.Merge(mergeReq)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if resp == nil {
+			log.Print("Failed to merge contacts - not found by id")
+			return
+		}
+		log.Print("Successfully merged contacts into: ", *resp)
+		return
+	}
+
+	if args[0] == "import" {
+		if len(args) < 2 {
+			log.Print("Usage: ./client import <file.vcf|file.csv|file.ldif> [csvLayout] | import <file.ndjson> [afterId]")
+			return
+		}
+		c.importFile(args[1], args[2:])
+		return
+	}
+
+	if args[0] == "export" {
+		args, encrypt := extractFlag(args, "--encrypt")
+		if len(args) < 2 {
+			log.Print("Usage: ./client export <file.vcf> [id] [version] | export <file.csv> [csvLayout] | export <file.ldif> [baseDn] | export <file.ndjson> [afterId]; add --encrypt to encrypt with a passphrase")
+			return
+		}
+		c.exportFile(args[1], args[2:], encrypt)
+		return
+	}
+
+	if args[0] == "backup" {
+		args, encrypt := extractFlag(args, "--encrypt")
+		if len(args) < 2 {
+			log.Print("Usage: ./client backup <file> [--encrypt]")
+			return
+		}
+
+		backup, err := c.client.Backup()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := server.WriteBackup(&buf, backup); err != nil {
+			log.Print(err)
+			return
+		}
+		if err := c.writeFile(args[1], buf.Bytes(), encrypt); err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Successfully backed up %d contacts to %s", backup.Count, args[1])
+		return
+	}
+
+	if args[0] == "restore" {
+		if len(args) < 2 {
+			log.Print("Usage: ./client restore <file>")
+			return
+		}
+
+		file, err := c.openFile(args[1])
+		if err != nil {
+			log.Print("Refusing to restore backup: ", err)
+			return
+		}
+		defer file.Close()
+
+		// Verified before anything is sent to the server
+		backup, err := server.ReadBackup(file)
+		if err != nil {
+			log.Print("Refusing to restore invalid backup: ", err)
+			return
+		}
+
+		restored, err := c.client.Restore(backup)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Successfully restored %d contacts", restored)
+		return
+	}
+
+	if args[0] == "books" {
+		c.handleBooks(args[1:])
+		return
+	}
+
+	if args[0] == "keys" {
+		c.handleKeys(args[1:])
+		return
+	}
+
+	if args[0] == "audit" {
+		c.handleAudit(args[1:])
 		return
 	}
 
 	log.Print("Unknown command")
 }
+
+func (c *CliClient) handleBooks(args []string) {
+	usage := "Usage: ./client books create <name> [--personal] | books list | books delete <name> | " +
+		"books share <book> <method:subject> <read|write> [<contact id>] | books unshare <book> <method:subject> [<contact id>] | " +
+		"books shares <book> | books shared"
+	if len(args) < 1 {
+		log.Print(usage)
+		return
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		book, err := c.client.CreateBook(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Print("Successfully created book ", book.Name)
+
+	case args[0] == "create" && len(args) == 3 && args[2] == "--personal":
+		book, err := c.client.CreatePersonalBook(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Print("Successfully created personal book ", book.Name)
+
+	case args[0] == "list" && len(args) == 1:
+		books, err := c.client.ListBooks()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, book := range books {
+			owner := ""
+			if book.Owner != "" {
+				owner = "\towned by " + book.Owner
+			}
+			fmt.Fprintf(c.out, "%s\t%d contacts\tcreated %s%s\n", book.Name, book.Count, book.CreatedAt.Format(time.RFC3339), owner)
+		}
+
+	case args[0] == "delete" && len(args) == 2:
+		deleted, err := c.client.DeleteBook(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if !deleted {
+			log.Print("Book not found")
+			return
+		}
+		log.Print("Successfully deleted book ", args[1])
+
+	case args[0] == "share" && (len(args) == 4 || len(args) == 5):
+		access := server.Access(args[3])
+		if err := server.ValidateAccess(access); err != nil {
+			log.Print(err)
+			return
+		}
+		var err error
+		if len(args) == 5 {
+			id, convErr := strconv.Atoi(args[4])
+			if convErr != nil {
+				log.Print("Invalid contact id: ", args[4])
+				return
+			}
+			err = c.client.ShareContact(args[1], id, args[2], access)
+		} else {
+			err = c.client.ShareBook(args[1], args[2], access)
+		}
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Successfully shared with %s for %s", args[2], access)
+
+	case args[0] == "unshare" && (len(args) == 3 || len(args) == 4):
+		var unshared bool
+		var err error
+		if len(args) == 4 {
+			id, convErr := strconv.Atoi(args[3])
+			if convErr != nil {
+				log.Print("Invalid contact id: ", args[3])
+				return
+			}
+			unshared, err = c.client.UnshareContact(args[1], id, args[2])
+		} else {
+			unshared, err = c.client.UnshareBook(args[1], args[2])
+		}
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if !unshared {
+			log.Print("Share not found")
+			return
+		}
+		log.Print("Successfully stopped sharing with ", args[2])
+
+	case args[0] == "shares" && len(args) == 2:
+		shares, err := c.client.ListShares(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, share := range shares {
+			target := "book"
+			if share.ContactId != 0 {
+				target = "contact " + strconv.Itoa(share.ContactId)
+			}
+			fmt.Fprintf(c.out, "%s\t%s\t%s\n", share.Subject, target, share.Access)
+		}
+
+	case args[0] == "shared" && len(args) == 1:
+		shared, err := c.client.SharedWithMe()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, item := range shared {
+			target := "book"
+			if item.ContactId != 0 {
+				target = "contact " + strconv.Itoa(item.ContactId)
+			}
+			owner := ""
+			if item.Owner != "" {
+				owner = "\towned by " + item.Owner
+			}
+			fmt.Fprintf(c.out, "%s\t%s\t%s%s\n", item.Book, target, item.Access, owner)
+		}
+
+	default:
+		log.Print(usage)
+	}
+}
+
+func (c *CliClient) handleKeys(args []string) {
+	usage := "Usage: ./client keys create <label> | keys list | keys revoke <id>"
+	if len(args) < 1 {
+		log.Print(usage)
+		return
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		created, err := c.client.CreateApiKey(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Created API key %s (%s), store it now - it is not shown again", created.Id, created.Label)
+		// The key alone goes to the output, so it can be captured by scripts
+		fmt.Fprintln(c.out, created.Key)
+
+	case args[0] == "list" && len(args) == 1:
+		keys, err := c.client.ListApiKeys()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, key := range keys {
+			lastUsed := "never"
+			if key.LastUsedAt != nil {
+				lastUsed = key.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(c.out, "%s\t%s\tcreated %s\tlast used %s\n", key.Id, key.Label, key.CreatedAt.Format(time.RFC3339), lastUsed)
+		}
+
+	case args[0] == "revoke" && len(args) == 2:
+		revoked, err := c.client.RevokeApiKey(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if !revoked {
+			log.Print("API key not found")
+			return
+		}
+		log.Print("Successfully revoked API key ", args[1])
+
+	default:
+		log.Print(usage)
+	}
+}
+
+func (c *CliClient) handleAudit(args []string) {
+	usage := "Usage: ./client audit [--from <RFC 3339 time>] [--to <RFC 3339 time>] [--op <operation>] [--actor <subject>] [--contact <id>] [--limit <n>]"
+	filter := server.AuditFilter{Limit: server.DefaultAuditQueryLimit}
+	values := map[string]string{}
+	for _, option := range []string{"--from", "--to", "--op", "--actor", "--contact", "--limit"} {
+		var err error
+		if args, values[option], err = extractOption(args, option); err != nil {
+			log.Print(err)
+			return
+		}
+	}
+	if len(args) > 0 {
+		log.Print(usage)
+		return
+	}
+
+	filter.Op, filter.Actor = values["--op"], values["--actor"]
+	for option, value := range map[string]*time.Time{"--from": &filter.From, "--to": &filter.To} {
+		if values[option] != "" {
+			var err error
+			if *value, err = time.Parse(time.RFC3339, values[option]); err != nil {
+				log.Printf("Invalid %s time %q, expected e.g. 2024-01-31T12:00:00Z", option, values[option])
+				return
+			}
+		}
+	}
+	for option, value := range map[string]*int{"--contact": &filter.ContactId, "--limit": &filter.Limit} {
+		if values[option] != "" {
+			var err error
+			if *value, err = strconv.Atoi(values[option]); err != nil {
+				log.Printf("Invalid %s %q", option, values[option])
+				return
+			}
+		}
+	}
+
+	events, err := c.client.Audit(filter)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, event := range events {
+		actor := "-"
+		if event.Actor != nil {
+			actor = event.Actor.Key()
+		}
+		contact := ""
+		if event.ContactId != 0 {
+			contact = "\tcontact " + strconv.Itoa(event.ContactId)
+		}
+		fmt.Fprintf(c.out, "%s\t%s\t%s\t%s\t%d %s%s\n", event.Time.Format(time.RFC3339), event.Op, event.Book, actor,
+			event.Status, event.Outcome, contact)
+	}
+}
+
+// Prints contacts in the format selected with --format, or logs them when no format was selected
+func (c *CliClient) printContacts(contacts []server.Contact) {
+	if c.format == nil {
+		log.Print("Found contacts: ", contacts)
+		return
+	}
+
+	var err error
+	if c.format.EncodeValue != nil {
+		err = c.format.EncodeValue(c.out, contacts)
+	} else {
+		err = c.format.EncodeContacts(c.out, contacts, nil)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// Removes option with a value, given as "--name value" or "--name=value", from args
+func extractOption(args []string, name string) ([]string, string, error) {
+	var result []string
+	value := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == name:
+			if i+1 >= len(args) {
+				return nil, "", errors.New("missing value of " + name)
+			}
+			i++
+			value = args[i]
+		case strings.HasPrefix(args[i], name+"="):
+			value = strings.TrimPrefix(args[i], name+"=")
+		default:
+			result = append(result, args[i])
+		}
+	}
+	return result, value, nil
+}
+
+// Removes flag from args, returning whether it was present
+func extractFlag(args []string, flag string) ([]string, bool) {
+	var result []string
+	found := false
+	for _, arg := range args {
+		if arg == flag {
+			found = true
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result, found
+}
+
+// Opens file for reading, transparently decrypting it if it is an encrypted archive
+func (c *CliClient) openFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	header, _ := reader.Peek(16)
+	if !server.IsEncryptedArchive(header) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := c.passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := server.DecryptArchive(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+// Writes data to file, encrypting it with a passphrase if requested
+func (c *CliClient) writeFile(path string, data []byte, encrypt bool) error {
+	if encrypt {
+		passphrase, err := c.passphrase(true)
+		if err != nil {
+			return err
+		}
+		data, err = server.EncryptArchive(data, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Imports contacts from file, format is chosen by the file extension
+func (c *CliClient) importFile(path string, args []string) {
+	file, err := c.openFile(path)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		layout := ""
+		if len(args) > 0 {
+			layout = args[0]
+		}
+
+		report, err := c.client.ImportCsv(file, layout)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, rowErr := range report.Errors {
+			log.Printf("Row %d: %s", rowErr.Row, rowErr.Error)
+		}
+		log.Printf("Successfully imported %d contacts, %d rows failed", report.Imported, report.Failed)
+	case ".ldif":
+		report, err := c.client.ImportLdif(file)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		for _, recordErr := range report.Errors {
+			log.Printf("Record %d (%s): %s", recordErr.Record, recordErr.Dn, recordErr.Error)
+		}
+		log.Printf("Successfully applied LDIF - %d added, %d modified, %d deleted, %d records failed",
+			report.Added, report.Modified, report.Deleted, report.Failed)
+	case ".ndjson":
+		afterId, err := optionalIdArg(args)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		final, err := c.client.ImportNdjson(file, afterId, func(event server.NdjsonImportProgress) {
+			if event.Error != "" {
+				log.Printf("Line %d: %s", event.Line, event.Error)
+			} else if !event.Done {
+				log.Printf("Sent %d lines", event.Processed)
+			}
+		})
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Successfully imported %d contacts, %d skipped, %d lines failed, last id %d",
+			final.Imported, final.Skipped, final.Failed, final.LastId)
+	default:
+		resp, err := c.client.ImportVCards(file)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		log.Printf("Successfully imported %d contacts", len(resp))
+	}
+}
+
+// Exports contacts to file, format is chosen by the file extension
+func (c *CliClient) exportFile(path string, args []string, encrypt bool) {
+	var err error
+	// Whole export is encrypted at once, so it is collected in memory first
+	var buf bytes.Buffer
+	var file io.Writer = &buf
+	if !encrypt {
+		// Written beside the target and renamed once complete, so failed exports leave no file
+		out, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer func() {
+			out.Close()
+			os.Remove(out.Name())
+		}()
+		file = out
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		layout := server.CsvLayoutDefault
+		if len(args) > 0 {
+			layout = args[0]
+		}
+		err = c.client.ExportCsv(file, layout)
+	case ".ldif":
+		baseDn := server.DefaultLdifBaseDn
+		if len(args) > 0 {
+			baseDn = args[0]
+		}
+		err = c.client.ExportLdif(file, baseDn)
+	case ".ndjson":
+		var afterId int
+		afterId, err = optionalIdArg(args)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		encoder := json.NewEncoder(file)
+		exported := 0
+		err = c.client.ExportNdjson(afterId, func(contact server.Contact, total int) error {
+			exported++
+			if exported%100 == 0 {
+				log.Printf("Exported %d of %d contacts", exported, total)
+			}
+			return encoder.Encode(contact)
+		})
+	default:
+		id := 0
+		if len(args) > 0 {
+			id, err = strconv.Atoi(args[0])
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		version := server.VCardVersion3
+		if len(args) > 1 {
+			version = args[1]
+		}
+
+		if id == 0 {
+			err = c.client.ExportVCards(file, version)
+		} else {
+			var found bool
+			found, err = c.client.ExportVCard(file, id, version)
+			if err == nil && !found {
+				log.Print("Failed to export contact - not found by id")
+				return
+			}
+		}
+	}
+
+	if err == nil && encrypt {
+		err = c.writeFile(path, buf.Bytes(), true)
+	} else if err == nil {
+		out := file.(*os.File)
+		if err = out.Close(); err == nil {
+			err = os.Rename(out.Name(), path)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Print("Successfully exported contacts to ", path)
+}
+
+func optionalIdArg(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(args[0])
+}
+
+func parseMergeArgs(args []string) (server.MergeRequest, error) {
+	var mergeReq server.MergeRequest
+
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) < 2 {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return mergeReq, err
+			}
+			mergeReq.Ids = append(mergeReq.Ids, id)
+			continue
+		}
+
+		field, resolution := parts[0], strings.SplitN(parts[1], ":", 2)
+		fieldResolution := server.FieldResolution{Strategy: server.MergeStrategy(resolution[0])}
+		if len(resolution) > 1 {
+			sourceId, err := strconv.Atoi(resolution[1])
+			if err != nil {
+				return mergeReq, err
+			}
+			fieldResolution.SourceId = sourceId
+		}
+
+		if mergeReq.Fields == nil {
+			mergeReq.Fields = make(map[string]server.FieldResolution)
+		}
+		mergeReq.Fields[field] = fieldResolution
+	}
+
+	return mergeReq, mergeReq.Validate()
+}
+
+// Builds merge patch from field=value arguments
+func parseSetArgs(args []string) (map[string]interface{}, error) {
+	patch := make(map[string]interface{})
+
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) < 2 {
+			return nil, errors.New("expected field=value, got " + arg)
+		}
+
+		field, value := parts[0], parts[1]
+		switch field {
+		case "name", "lastName", "email", "address", "notes":
+			if value == "" {
+				patch[field] = nil
+			} else {
+				patch[field] = value
+			}
+		case "phones":
+			if value == "" {
+				patch[field] = nil
+			} else {
+				patch[field] = strings.Split(value, ",")
+			}
+		default:
+			return nil, errors.New("unknown field " + field)
+		}
+	}
+
+	return patch, nil
+}
@@ -1,10 +1,18 @@
 package client_test
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"example.com/contacts/client"
 	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	testifyMock "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAddContact(t *testing.T) {
@@ -71,6 +79,137 @@ func TestFindByEmail(t *testing.T) {
 	mock.AssertExpectations(t)
 }
 
+func TestFindByEmailWithFormat(t *testing.T) {
+	mock := &client.ClientMock{}
+	var out bytes.Buffer
+	cli := client.NewCliClient(mock).WithOutput(&out)
+
+	contact := server.Contact{
+		Id:       1,
+		Name:     "name",
+		LastName: "lastName",
+		Email:    "email@email.com",
+	}
+
+	mock.On("FindByEmail", contact.Email).Return([]server.Contact{contact}, nil)
+
+	cli.HandleCommand([]string{"--format", "yaml", "findByEmail", contact.Email})
+	assert.Equal(t, "- id: 1\n  name: name\n  lastName: lastName\n  email: email@email.com\n", out.String())
+
+	out.Reset()
+	cli.HandleCommand([]string{"findByEmail", contact.Email, "--format=csv"})
+	assert.Equal(t, "id,name,lastName,email,phones,address,notes\n1,name,lastName,email@email.com,,,\n", out.String())
+
+	out.Reset()
+	cli.HandleCommand([]string{"findByEmail", contact.Email, "--format=xml"})
+	assert.Empty(t, out.String())
+	mock.AssertNumberOfCalls(t, "FindByEmail", 2)
+}
+
+func TestBookOption(t *testing.T) {
+	mock := &client.ClientMock{}
+	bookMock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	mock.On("InBook", "team-a").Return(bookMock)
+	bookMock.On("Delete", server.Contact{Id: 1}).Return(true, nil)
+	mock.On("Delete", server.Contact{Id: 2}).Return(true, nil)
+
+	cli.HandleCommand([]string{"--book", "team-a", "delete", "1"})
+	// The book applies to a single command
+	cli.HandleCommand([]string{"delete", "2"})
+	mock.AssertExpectations(t)
+	bookMock.AssertExpectations(t)
+}
+
+func TestBooks(t *testing.T) {
+	mock := &client.ClientMock{}
+	var out bytes.Buffer
+	cli := client.NewCliClient(mock).WithOutput(&out)
+
+	mock.On("CreateBook", "team-a").Return(server.Book{Name: "team-a"}, nil)
+	mock.On("ListBooks").Return([]server.Book{{Name: "default", Count: 4}, {Name: "team-a"}}, nil)
+	mock.On("DeleteBook", "team-a").Return(true, nil)
+
+	cli.HandleCommand([]string{"books", "create", "team-a"})
+	cli.HandleCommand([]string{"books", "list"})
+	assert.Contains(t, out.String(), "default\t4 contacts\t")
+	assert.Contains(t, out.String(), "team-a\t0 contacts\t")
+	cli.HandleCommand([]string{"books", "delete", "team-a"})
+	mock.AssertExpectations(t)
+}
+
+func TestSharing(t *testing.T) {
+	mock := &client.ClientMock{}
+	var out bytes.Buffer
+	cli := client.NewCliClient(mock).WithOutput(&out)
+
+	mock.On("CreatePersonalBook", "mine").Return(server.Book{Name: "mine", Owner: "jwt:ann"}, nil)
+	mock.On("ShareBook", "mine", "jwt:bob", server.AccessRead).Return(nil)
+	mock.On("ShareContact", "mine", 2, "jwt:cid", server.AccessWrite).Return(nil)
+	mock.On("UnshareBook", "mine", "jwt:bob").Return(true, nil)
+	mock.On("ListShares", "mine").Return([]server.Share{{Subject: "jwt:cid", ContactId: 2, Access: server.AccessWrite}}, nil)
+	mock.On("SharedWithMe").Return([]server.SharedItem{{Book: "theirs", Owner: "jwt:dan", Access: server.AccessRead}}, nil)
+
+	cli.HandleCommand([]string{"books", "create", "mine", "--personal"})
+	cli.HandleCommand([]string{"books", "share", "mine", "jwt:bob", "read"})
+	cli.HandleCommand([]string{"books", "share", "mine", "jwt:cid", "write", "2"})
+	cli.HandleCommand([]string{"books", "share", "mine", "jwt:cid", "admin"})
+	cli.HandleCommand([]string{"books", "unshare", "mine", "jwt:bob"})
+
+	cli.HandleCommand([]string{"books", "shares", "mine"})
+	assert.Equal(t, "jwt:cid\tcontact 2\twrite\n", out.String())
+	out.Reset()
+	cli.HandleCommand([]string{"books", "shared"})
+	assert.Equal(t, "theirs\tbook\tread\towned by jwt:dan\n", out.String())
+	mock.AssertExpectations(t)
+	mock.AssertNumberOfCalls(t, "ShareBook", 1)
+}
+
+func TestAudit(t *testing.T) {
+	mock := &client.ClientMock{}
+	var out bytes.Buffer
+	cli := client.NewCliClient(mock).WithOutput(&out)
+
+	from := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	filter := server.AuditFilter{From: from, Actor: "jane", ContactId: 4, Limit: server.DefaultAuditQueryLimit}
+	mock.On("Audit", filter).Return([]server.AuditEvent{{
+		Time: from, Op: "deleteById", Book: "default", Actor: &server.Identity{Subject: "jane", Method: "jwt"},
+		Status: 204, Outcome: server.AuditSuccess, ContactId: 4,
+	}}, nil)
+
+	cli.HandleCommand([]string{"audit", "--from", "2024-01-31T12:00:00Z", "--actor=jane", "--contact", "4"})
+	assert.Equal(t, "2024-01-31T12:00:00Z\tdeleteById\tdefault\tjwt:jane\t204 success\tcontact 4\n", out.String())
+
+	cli.HandleCommand([]string{"audit", "--from", "yesterday"})
+	mock.AssertExpectations(t)
+	mock.AssertNumberOfCalls(t, "Audit", 1)
+}
+
+func TestApiKeys(t *testing.T) {
+	mock := &client.ClientMock{}
+	var out bytes.Buffer
+	cli := client.NewCliClient(mock).WithOutput(&out)
+
+	created := server.CreatedApiKey{ApiKey: server.ApiKey{Id: "ab12", Label: "ci"}, Key: "ck_ab12_secret"}
+	mock.On("CreateApiKey", "ci").Return(created, nil)
+	mock.On("ListApiKeys").Return([]server.ApiKey{created.ApiKey}, nil)
+	mock.On("RevokeApiKey", "ab12").Return(true, nil)
+
+	cli.HandleCommand([]string{"keys", "create", "ci"})
+	assert.Equal(t, "ck_ab12_secret\n", out.String())
+
+	out.Reset()
+	cli.HandleCommand([]string{"keys", "list"})
+	assert.Contains(t, out.String(), "ab12\tci\t")
+	assert.Contains(t, out.String(), "last used never")
+
+	cli.HandleCommand([]string{"keys", "revoke", "ab12"})
+	cli.HandleCommand([]string{"keys", "revoke"})
+	mock.AssertExpectations(t)
+	mock.AssertNumberOfCalls(t, "RevokeApiKey", 1)
+}
+
 func TestFindByLastNamePart(t *testing.T) {
 	mock := &client.ClientMock{}
 	cli := client.NewCliClient(mock)
@@ -87,3 +226,257 @@ func TestFindByLastNamePart(t *testing.T) {
 	cli.HandleCommand([]string{"findByLastNamePart", contact.LastName})
 	mock.AssertExpectations(t)
 }
+
+func TestFindDuplicates(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	cluster := server.DuplicateCluster{
+		Contacts: []server.Contact{
+			{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com"},
+			{Id: 2, Name: "Jon", LastName: "Lennon", Email: "John.Lennon@thebeatles.com"},
+		},
+		Score: 0.9,
+	}
+
+	mock.On("FindDuplicates", 0.7).Return([]server.DuplicateCluster{cluster}, nil)
+
+	cli.HandleCommand([]string{"duplicates", "0.7"})
+	mock.AssertExpectations(t)
+}
+
+func TestMergeContacts(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	mergeReq := server.MergeRequest{
+		Ids: []int{1, 2},
+		Fields: map[string]server.FieldResolution{
+			"name":  {Strategy: server.MergeKeepLongest},
+			"email": {Strategy: server.MergeChoose, SourceId: 2},
+		},
+	}
+	survivor := &server.Contact{Id: 1, Name: "name", LastName: "lastName", Email: "email@email.com"}
+
+	mock.On("Merge", mergeReq).Return(survivor, nil)
+
+	cli.HandleCommand([]string{"merge", "1", "2", "name=longest", "email=choose:2"})
+	mock.AssertExpectations(t)
+}
+
+func TestSetContactFields(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	patch := map[string]interface{}{
+		"email":  "john@lennon.com",
+		"phones": []string{"+44 1", "+44 2"},
+		"notes":  nil,
+	}
+	patched := &server.Contact{Id: 1, Name: "John", LastName: "Lennon", Email: "john@lennon.com", Phones: []string{"+44 1", "+44 2"}}
+
+	mock.On("Patch", 1, server.MergePatchContentType, patch).Return(patched, nil)
+
+	cli.HandleCommand([]string{"set", "1", "email=john@lennon.com", "phones=+44 1,+44 2", "notes="})
+	cli.HandleCommand([]string{"set", "1", "nickname=Johnny"})
+	mock.AssertExpectations(t)
+}
+
+func TestImportContacts(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.vcf")
+	require.NoError(t, os.WriteFile(path, []byte("BEGIN:VCARD\r\nEND:VCARD\r\n"), 0600))
+
+	mock.On("ImportVCards", testifyMock.Anything).Return([]server.Contact{{Id: 1}}, nil)
+
+	cli.HandleCommand([]string{"import", path})
+	mock.AssertExpectations(t)
+}
+
+func TestExportContact(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contact.vcf")
+
+	mock.On("ExportVCard", testifyMock.Anything, 1, server.VCardVersion4).Run(func(args testifyMock.Arguments) {
+		io.WriteString(args.Get(0).(io.Writer), "BEGIN:VCARD\r\nEND:VCARD\r\n")
+	}).Return(true, nil)
+
+	cli.HandleCommand([]string{"export", path, "1", server.VCardVersion4})
+	mock.AssertExpectations(t)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "BEGIN:VCARD\r\nEND:VCARD\r\n", string(data))
+}
+
+func TestExportFailureLeavesNoFile(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+	dir := t.TempDir()
+
+	mock.On("ExportVCard", testifyMock.Anything, 9, server.VCardVersion3).Return(false, nil)
+	mock.On("ExportCsv", testifyMock.Anything, server.CsvLayoutDefault).Return(client.ErrForbidden)
+
+	cli.HandleCommand([]string{"export", filepath.Join(dir, "contact.vcf"), "9"})
+	cli.HandleCommand([]string{"export", filepath.Join(dir, "contacts.csv")})
+	mock.AssertExpectations(t)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestImportCsv(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.csv")
+	require.NoError(t, os.WriteFile(path, []byte("name,lastName,email\n"), 0600))
+
+	report := server.CsvImportReport{Imported: 1, Failed: 1, Errors: []server.CsvRowError{{Row: 3, Error: "empty contact name"}}}
+	mock.On("ImportCsv", testifyMock.Anything, server.CsvLayoutGoogle).Return(report, nil)
+
+	cli.HandleCommand([]string{"import", path, server.CsvLayoutGoogle})
+	mock.AssertExpectations(t)
+}
+
+func TestExportCsv(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.csv")
+
+	mock.On("ExportCsv", testifyMock.Anything, server.CsvLayoutDefault).Return(nil)
+
+	cli.HandleCommand([]string{"export", path})
+	mock.AssertExpectations(t)
+}
+
+func TestImportLdif(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.ldif")
+	require.NoError(t, os.WriteFile(path, []byte("version: 1\n"), 0600))
+
+	mock.On("ImportLdif", testifyMock.Anything).Return(server.LdifImportReport{Added: 1}, nil)
+
+	cli.HandleCommand([]string{"import", path})
+	mock.AssertExpectations(t)
+}
+
+func TestExportLdif(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.ldif")
+
+	mock.On("ExportLdif", testifyMock.Anything, "ou=people,dc=example,dc=org").Return(nil)
+
+	cli.HandleCommand([]string{"export", path, "ou=people,dc=example,dc=org"})
+	mock.AssertExpectations(t)
+}
+
+func TestImportNdjson(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.ndjson")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0600))
+
+	final := server.NdjsonImportProgress{Processed: 1, Imported: 1, LastId: 12, Done: true}
+	mock.On("ImportNdjson", testifyMock.Anything, 10, testifyMock.Anything).Return(final, nil)
+
+	cli.HandleCommand([]string{"import", path, "10"})
+	mock.AssertExpectations(t)
+}
+
+func TestExportNdjson(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "contacts.ndjson")
+	contact := server.Contact{Id: 1, Name: "name", LastName: "lastName", Email: "email@email.com"}
+
+	mock.On("ExportNdjson", 0, testifyMock.Anything).Return(nil).Run(func(args testifyMock.Arguments) {
+		fn := args.Get(1).(func(server.Contact, int) error)
+		require.NoError(t, fn(contact, 1))
+	})
+
+	cli.HandleCommand([]string{"export", path})
+	mock.AssertExpectations(t)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"name":"name","lastName":"lastName","email":"email@email.com"}`, string(data))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	backup, err := server.NewBackup(db, nil)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "backup.json")
+
+	mock.On("Backup").Return(backup, nil)
+	mock.On("Restore", testifyMock.MatchedBy(func(restored *server.Backup) bool {
+		return restored.Checksum == backup.Checksum
+	})).Return(backup.Count, nil)
+
+	cli.HandleCommand([]string{"backup", path})
+	cli.HandleCommand([]string{"restore", path})
+	mock.AssertExpectations(t)
+}
+
+func TestRestoreTamperedBackup(t *testing.T) {
+	mock := &client.ClientMock{}
+	cli := client.NewCliClient(mock)
+
+	path := filepath.Join(t.TempDir(), "backup.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"format": "contacts-backup", "schemaVersion": 1, "checksum": "00"}`), 0600))
+
+	cli.HandleCommand([]string{"restore", path})
+	mock.AssertNotCalled(t, "Restore", testifyMock.Anything)
+}
+
+func TestEncryptedBackupAndRestore(t *testing.T) {
+	mock := &client.ClientMock{}
+	passphrase := []byte("secret")
+	cli := client.NewCliClient(mock).WithPassphrasePrompt(func(bool) ([]byte, error) {
+		return passphrase, nil
+	})
+
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	backup, err := server.NewBackup(db, nil)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "backup.enc")
+
+	mock.On("Backup").Return(backup, nil)
+	mock.On("Restore", testifyMock.Anything).Return(backup.Count, nil).Once()
+
+	cli.HandleCommand([]string{"backup", path, "--encrypt"})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, server.IsEncryptedArchive(data))
+
+	cli.HandleCommand([]string{"restore", path})
+
+	// Wrong passphrase and tampered archive must not reach the server
+	passphrase = []byte("wrong")
+	cli.HandleCommand([]string{"restore", path})
+	passphrase = []byte("secret")
+	data[len(data)-1] ^= 1
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	cli.HandleCommand([]string{"restore", path})
+
+	mock.AssertExpectations(t)
+	mock.AssertNumberOfCalls(t, "Restore", 1)
+}
@@ -1,15 +1,83 @@
 package client
 
-import "example.com/contacts/server"
+import (
+	"io"
+
+	"example.com/contacts/server"
+)
 
 type Client interface {
+	// Client of the same server working with contacts of another address book
+	InBook(book string) Client
+
 	InsertWithNewId(contact server.Contact) (server.Contact, error)
 
 	Update(contact server.Contact) (bool, error)
 	Delete(contact server.Contact) (bool, error)
+	// Merges contacts - in case any of them is not found, nil is returned
+	Merge(req server.MergeRequest) (*server.Contact, error)
+	// Applies merge patch or JSON Patch of given content type - in case of no matching contact by id, nil is returned
+	Patch(id int, contentType string, patch interface{}) (*server.Contact, error)
 
 	FindById(id int) (*server.Contact, error)
 	FindByLastNameContains(part string) ([]server.Contact, error)
 	FindByEmail(email string) ([]server.Contact, error)
 	FindAll() ([]server.Contact, error)
+
+	FindDuplicates(threshold float64) ([]server.DuplicateCluster, error)
+
+	// Imports all contacts from a vCard stream, returning them with assigned ids
+	ImportVCards(r io.Reader) ([]server.Contact, error)
+	// Writes all contacts as vCards of given version
+	ExportVCards(w io.Writer, version string) error
+	// Writes a single contact as vCard - in case of no matching contact by id, false will be returned
+	ExportVCard(w io.Writer, id int, version string) (bool, error)
+
+	// Imports contacts from CSV of given layout, empty layout means auto-detection
+	ImportCsv(r io.Reader, layout string) (server.CsvImportReport, error)
+	// Writes all contacts as CSV of given layout
+	ExportCsv(w io.Writer, layout string) error
+
+	// Applies LDIF content and change records
+	ImportLdif(r io.Reader) (server.LdifImportReport, error)
+	// Writes all contacts as LDIF entries under given base dn
+	ExportLdif(w io.Writer, baseDn string) error
+
+	// Streams contacts with id greater than afterId, ordered by id. Total is the number of all contacts
+	ExportNdjson(afterId int, fn func(contact server.Contact, total int) error) error
+	// Streams contacts to import, reporting lines sent and failed lines through progress
+	ImportNdjson(r io.Reader, afterId int, progress func(server.NdjsonImportProgress)) (server.NdjsonImportProgress, error)
+
+	// Fetches and verifies a point-in-time backup of all contacts
+	Backup() (*server.Backup, error)
+	// Replaces all contacts with the backup, returning number of restored contacts
+	Restore(backup *server.Backup) (int, error)
+
+	CreateBook(name string) (server.Book, error)
+	ListBooks() ([]server.Book, error)
+	// Deletes address book with all its contacts - in case of no matching book, false will be returned
+	DeleteBook(name string) (bool, error)
+	// Creates address book only the caller and those it shares it with may access
+	CreatePersonalBook(name string) (server.Book, error)
+
+	// Grants subject, like "jwt:jane", access to the whole book
+	ShareBook(book string, subject string, access server.Access) error
+	// Revokes access to the whole book - in case subject had none, false will be returned
+	UnshareBook(book string, subject string) (bool, error)
+	// Grants subject access to a single contact of the book
+	ShareContact(book string, id int, subject string, access server.Access) error
+	// Revokes access to a single contact - in case subject had none, false will be returned
+	UnshareContact(book string, id int, subject string) (bool, error)
+	ListShares(book string) ([]server.Share, error)
+	// Books and contacts others shared with the caller
+	SharedWithMe() ([]server.SharedItem, error)
+
+	// Audit events matching filter, oldest first
+	Audit(filter server.AuditFilter) ([]server.AuditEvent, error)
+
+	// Creates API key of given label, the result holds the only copy of the key
+	CreateApiKey(label string) (server.CreatedApiKey, error)
+	ListApiKeys() ([]server.ApiKey, error)
+	// Revokes API key - in case of no matching key by id, false will be returned
+	RevokeApiKey(id string) (bool, error)
 }
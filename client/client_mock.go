@@ -1,6 +1,8 @@
 package client
 
 import (
+	"io"
+
 	"example.com/contacts/server"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,6 +11,11 @@ type ClientMock struct {
 	mock.Mock
 }
 
+func (c *ClientMock) InBook(book string) Client {
+	args := c.Called(book)
+	return args.Get(0).(Client)
+}
+
 func (c *ClientMock) InsertWithNewId(contact server.Contact) (server.Contact, error) {
 	args := c.Called(contact)
 	return args.Get(0).(server.Contact), args.Error(1)
@@ -24,6 +31,16 @@ func (c *ClientMock) Delete(contact server.Contact) (bool, error) {
 	return args.Bool(0), args.Error(1)
 }
 
+func (c *ClientMock) Merge(req server.MergeRequest) (*server.Contact, error) {
+	args := c.Called(req)
+	return args.Get(0).(*server.Contact), args.Error(1)
+}
+
+func (c *ClientMock) Patch(id int, contentType string, patch interface{}) (*server.Contact, error) {
+	args := c.Called(id, contentType, patch)
+	return args.Get(0).(*server.Contact), args.Error(1)
+}
+
 func (c *ClientMock) FindById(id int) (*server.Contact, error) {
 	args := c.Called(id)
 	return args.Get(0).(*server.Contact), args.Error(1)
@@ -44,4 +61,134 @@ func (c *ClientMock) FindAll() ([]server.Contact, error) {
 	return args.Get(0).([]server.Contact), args.Error(1)
 }
 
+func (c *ClientMock) FindDuplicates(threshold float64) ([]server.DuplicateCluster, error) {
+	args := c.Called(threshold)
+	return args.Get(0).([]server.DuplicateCluster), args.Error(1)
+}
+
+func (c *ClientMock) ImportVCards(r io.Reader) ([]server.Contact, error) {
+	args := c.Called(r)
+	return args.Get(0).([]server.Contact), args.Error(1)
+}
+
+func (c *ClientMock) ExportVCards(w io.Writer, version string) error {
+	args := c.Called(w, version)
+	return args.Error(0)
+}
+
+func (c *ClientMock) ExportVCard(w io.Writer, id int, version string) (bool, error) {
+	args := c.Called(w, id, version)
+	return args.Bool(0), args.Error(1)
+}
+
+func (c *ClientMock) ImportCsv(r io.Reader, layout string) (server.CsvImportReport, error) {
+	args := c.Called(r, layout)
+	return args.Get(0).(server.CsvImportReport), args.Error(1)
+}
+
+func (c *ClientMock) ExportCsv(w io.Writer, layout string) error {
+	args := c.Called(w, layout)
+	return args.Error(0)
+}
+
+func (c *ClientMock) ImportLdif(r io.Reader) (server.LdifImportReport, error) {
+	args := c.Called(r)
+	return args.Get(0).(server.LdifImportReport), args.Error(1)
+}
+
+func (c *ClientMock) ExportLdif(w io.Writer, baseDn string) error {
+	args := c.Called(w, baseDn)
+	return args.Error(0)
+}
+
+func (c *ClientMock) ExportNdjson(afterId int, fn func(contact server.Contact, total int) error) error {
+	args := c.Called(afterId, fn)
+	return args.Error(0)
+}
+
+func (c *ClientMock) ImportNdjson(r io.Reader, afterId int, progress func(server.NdjsonImportProgress)) (server.NdjsonImportProgress, error) {
+	args := c.Called(r, afterId, progress)
+	return args.Get(0).(server.NdjsonImportProgress), args.Error(1)
+}
+
+func (c *ClientMock) Backup() (*server.Backup, error) {
+	args := c.Called()
+	return args.Get(0).(*server.Backup), args.Error(1)
+}
+
+func (c *ClientMock) Restore(backup *server.Backup) (int, error) {
+	args := c.Called(backup)
+	return args.Int(0), args.Error(1)
+}
+
+func (c *ClientMock) CreateBook(name string) (server.Book, error) {
+	args := c.Called(name)
+	return args.Get(0).(server.Book), args.Error(1)
+}
+
+func (c *ClientMock) ListBooks() ([]server.Book, error) {
+	args := c.Called()
+	return args.Get(0).([]server.Book), args.Error(1)
+}
+
+func (c *ClientMock) DeleteBook(name string) (bool, error) {
+	args := c.Called(name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (c *ClientMock) CreatePersonalBook(name string) (server.Book, error) {
+	args := c.Called(name)
+	return args.Get(0).(server.Book), args.Error(1)
+}
+
+func (c *ClientMock) ShareBook(book string, subject string, access server.Access) error {
+	args := c.Called(book, subject, access)
+	return args.Error(0)
+}
+
+func (c *ClientMock) UnshareBook(book string, subject string) (bool, error) {
+	args := c.Called(book, subject)
+	return args.Bool(0), args.Error(1)
+}
+
+func (c *ClientMock) ShareContact(book string, id int, subject string, access server.Access) error {
+	args := c.Called(book, id, subject, access)
+	return args.Error(0)
+}
+
+func (c *ClientMock) UnshareContact(book string, id int, subject string) (bool, error) {
+	args := c.Called(book, id, subject)
+	return args.Bool(0), args.Error(1)
+}
+
+func (c *ClientMock) ListShares(book string) ([]server.Share, error) {
+	args := c.Called(book)
+	return args.Get(0).([]server.Share), args.Error(1)
+}
+
+func (c *ClientMock) SharedWithMe() ([]server.SharedItem, error) {
+	args := c.Called()
+	return args.Get(0).([]server.SharedItem), args.Error(1)
+}
+
+func (c *ClientMock) Audit(filter server.AuditFilter) ([]server.AuditEvent, error) {
+	args := c.Called(filter)
+	return args.Get(0).([]server.AuditEvent), args.Error(1)
+}
+
+func (c *ClientMock) CreateApiKey(label string) (server.CreatedApiKey, error) {
+	args := c.Called(label)
+	return args.Get(0).(server.CreatedApiKey), args.Error(1)
+}
+
+func (c *ClientMock) ListApiKeys() ([]server.ApiKey, error) {
+	args := c.Called()
+	return args.Get(0).([]server.ApiKey), args.Error(1)
+}
+
+func (c *ClientMock) RevokeApiKey(id string) (bool, error) {
+	args := c.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
 var _ Client = (*ClientMock)(nil)
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultEndpoint = "http://localhost:8080"
+
+// Settings of the command line client, read from a YAML file and overridden by environment
+type Config struct {
+	Endpoint string `yaml:"endpoint"`
+	ApiKey   string `yaml:"apiKey"`
+	// JWT issued by the SSO provider, sent as bearer token
+	Token string `yaml:"token"`
+}
+
+// Path of the config file - CONTACTS_CONFIG, or contacts/config.yaml in the user config directory
+func DefaultConfigPath() string {
+	if path := os.Getenv("CONTACTS_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "contacts", "config.yaml")
+}
+
+// Reads config file, which may be missing, then applies CONTACTS_ENDPOINT, CONTACTS_API_KEY and
+// CONTACTS_TOKEN
+func LoadConfig(path string) (Config, error) {
+	config := Config{Endpoint: DefaultEndpoint}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return Config{}, err
+		}
+		if err == nil {
+			if err := yaml.UnmarshalStrict(data, &config); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	if endpoint := os.Getenv("CONTACTS_ENDPOINT"); endpoint != "" {
+		config.Endpoint = endpoint
+	}
+	if apiKey := os.Getenv("CONTACTS_API_KEY"); apiKey != "" {
+		config.ApiKey = apiKey
+	}
+	if token := os.Getenv("CONTACTS_TOKEN"); token != "" {
+		config.Token = token
+	}
+	return config, nil
+}
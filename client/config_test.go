@@ -0,0 +1,36 @@
+package client_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/contacts/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("CONTACTS_ENDPOINT", "")
+	t.Setenv("CONTACTS_API_KEY", "")
+	t.Setenv("CONTACTS_TOKEN", "")
+
+	config, err := client.LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, client.Config{Endpoint: client.DefaultEndpoint}, config)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("endpoint: https://contacts.example.com\napiKey: ck_file\n"), 0600))
+	config, err = client.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, client.Config{Endpoint: "https://contacts.example.com", ApiKey: "ck_file"}, config)
+
+	t.Setenv("CONTACTS_API_KEY", "ck_env")
+	config, err = client.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "ck_env", config.ApiKey)
+
+	require.NoError(t, os.WriteFile(path, []byte("apikey: typo\n"), 0600))
+	_, err = client.LoadConfig(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"example.com/contacts/server"
+)
+
+// Error classes of failed requests, match them with errors.Is
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrInternal     = errors.New("internal server error")
+)
+
+// Problem details returned by the server for a failed request
+type ProblemError struct {
+	Problem server.Problem
+}
+
+func (e *ProblemError) Error() string {
+	return strconv.Itoa(e.Problem.Status) + " " + e.Problem.Error()
+}
+
+func (e *ProblemError) Unwrap() error {
+	switch {
+	case e.Problem.Status == http.StatusBadRequest:
+		return ErrBadRequest
+	case e.Problem.Status == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.Problem.Status == http.StatusForbidden:
+		return ErrForbidden
+	case e.Problem.Status == http.StatusNotFound:
+		return ErrNotFound
+	case e.Problem.Status == http.StatusConflict:
+		return ErrConflict
+	case e.Problem.Status == http.StatusUnprocessableEntity:
+		return ErrValidation
+	case e.Problem.Status >= 500:
+		return ErrInternal
+	}
+	return nil
+}
+
+// Fails for any response outside of the 2xx class
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Builds error from a failed response, decoding problem details when the server sent them
+func responseError(resp *http.Response) error {
+	problem := server.Problem{
+		Title:  http.StatusText(resp.StatusCode),
+		Status: resp.StatusCode,
+		Detail: "unexpected status code " + strconv.Itoa(resp.StatusCode),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == server.ProblemContentType {
+		var decoded server.Problem
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err == nil {
+			problem = decoded
+			problem.Status = resp.StatusCode
+		}
+	}
+	return &ProblemError{Problem: problem}
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"example.com/contacts/server"
 )
@@ -16,44 +17,130 @@ import (
 type HttpClient struct {
 	client  *http.Client
 	baseUrl string
+	version *server.ApiVersion
+	// Empty for the default book
+	book string
 }
 
 func NewContactsClient(client *http.Client, baseUrl string) *HttpClient {
-	return &HttpClient{client: client, baseUrl: baseUrl}
+	return &HttpClient{client: client, baseUrl: baseUrl, version: server.ApiV1}
 }
 
-func readContact(body io.ReadCloser) (*server.Contact, error) {
-	var contact *server.Contact
+// Selects version of the API used for contacts, v1 by default
+func (c *HttpClient) WithVersion(version *server.ApiVersion) *HttpClient {
+	c.version = version
+	return c
+}
+
+// Sends API key on every request
+func (c *HttpClient) WithApiKey(key string) *HttpClient {
+	return c.withHeader(server.ApiKeyHeader, key)
+}
+
+// Sends JWT as bearer token on every request
+func (c *HttpClient) WithBearerToken(token string) *HttpClient {
+	return c.withHeader("Authorization", "Bearer "+token)
+}
+
+// The underlying http.Client is copied, so it may be shared
+func (c *HttpClient) withHeader(name string, value string) *HttpClient {
+	client := *c.client
+	client.Transport = &headerTransport{name: name, value: value, next: client.Transport}
+	c.client = &client
+	return c
+}
+
+type headerTransport struct {
+	name  string
+	value string
+	next  http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// Round trippers must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(t.name, t.value)
+	return next.RoundTrip(req)
+}
+
+// Client of contacts in another address book, sharing version and credentials
+func (c *HttpClient) InBook(book string) Client {
+	inBook := *c
+	inBook.book = book
+	return &inBook
+}
+
+func (c *HttpClient) contactsUrl(path string) string {
+	if c.book != "" {
+		return c.baseUrl + c.version.Prefix + "/books/" + url.PathEscape(c.book) + path
+	}
+	return c.baseUrl + c.version.Prefix + path
+}
+
+// Admin endpoints act on the default book unless given one as query parameter
+func (c *HttpClient) adminUrl(path string) string {
+	if c.book != "" {
+		return c.baseUrl + path + "?book=" + url.QueryEscape(c.book)
+	}
+	return c.baseUrl + path
+}
+
+func (c *HttpClient) readContact(body io.ReadCloser) (*server.Contact, error) {
+	var data json.RawMessage
 	defer body.Close()
-	if err := json.NewDecoder(body).Decode(&contact); err != nil {
+	if err := json.NewDecoder(body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	contact, err := c.version.DecodeContact(data)
+	if err != nil {
 		return nil, err
 	}
-	return contact, nil
+	return &contact, nil
 }
 
-func readContactArray(body io.ReadCloser) ([]server.Contact, error) {
+func (c *HttpClient) decodeContacts(items []json.RawMessage) ([]server.Contact, error) {
 	var contacts []server.Contact
+	for _, item := range items {
+		contact, err := c.version.DecodeContact(item)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, nil
+}
+
+func (c *HttpClient) readContactArray(body io.ReadCloser) ([]server.Contact, error) {
+	var items []json.RawMessage
 	defer body.Close()
-	if err := json.NewDecoder(body).Decode(&contacts); err != nil {
+	if err := json.NewDecoder(body).Decode(&items); err != nil {
 		return nil, err
 	}
-	return contacts, nil
+	return c.decodeContacts(items)
 }
 
 func (c *HttpClient) InsertWithNewId(contact server.Contact) (server.Contact, error) {
-	body, err := json.Marshal(contact)
+	body, err := json.Marshal(c.version.EncodeContact(contact))
 	if err != nil {
 		return contact, err
 	}
-	resp, err := c.client.Post(c.baseUrl+"/contacts", "application/json", strings.NewReader(string(body)))
+	resp, err := c.client.Post(c.contactsUrl("/contacts"), "application/json", strings.NewReader(string(body)))
 	if err != nil {
 		return contact, err
 	}
 
-	if resp.StatusCode > 500 {
-		return contact, errors.New("unexpected status code " + strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode != http.StatusCreated {
+		defer resp.Body.Close()
+		return contact, responseError(resp)
 	}
-	newContact, err := readContact(resp.Body)
+	newContact, err := c.readContact(resp.Body)
 	if err != nil {
 		return contact, err
 	}
@@ -64,11 +151,11 @@ func (c *HttpClient) InsertWithNewId(contact server.Contact) (server.Contact, er
 }
 
 func (c *HttpClient) Update(contact server.Contact) (bool, error) {
-	body, err := json.Marshal(contact)
+	body, err := json.Marshal(c.version.EncodeContact(contact))
 	if err != nil {
 		return false, err
 	}
-	req, err := http.NewRequest("PUT", c.baseUrl+"/contacts/"+strconv.Itoa(contact.Id), bytes.NewBuffer(body))
+	req, err := http.NewRequest("PUT", c.contactsUrl("/contacts/")+strconv.Itoa(contact.Id), bytes.NewBuffer(body))
 	if err != nil {
 		return false, err
 	}
@@ -77,15 +164,19 @@ func (c *HttpClient) Update(contact server.Contact) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	if resp.StatusCode > 500 {
-		return false, errors.New("unexpected status code " + strconv.Itoa(resp.StatusCode))
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
 	}
 
-	return resp.StatusCode != 404, nil
+	return true, nil
 }
 
 func (c *HttpClient) Delete(contact server.Contact) (bool, error) {
-	req, err := http.NewRequest("DELETE", c.baseUrl+"/contacts/"+strconv.Itoa(contact.Id), nil)
+	req, err := http.NewRequest("DELETE", c.contactsUrl("/contacts/")+strconv.Itoa(contact.Id), nil)
 	if err != nil {
 		return false, err
 	}
@@ -93,50 +184,606 @@ func (c *HttpClient) Delete(contact server.Contact) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	if resp.StatusCode > 500 {
-		return false, errors.New("unexpected status code " + strconv.Itoa(resp.StatusCode))
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
 	}
 
-	return resp.StatusCode != 404, nil
+	return true, nil
 }
 
-func (c *HttpClient) FindById(id int) (*server.Contact, error) {
-	resp, err := c.client.Get(c.baseUrl + "/contacts/" + strconv.Itoa(id))
+func (c *HttpClient) Merge(mergeReq server.MergeRequest) (*server.Contact, error) {
+	body, err := json.Marshal(mergeReq)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode > 500 {
-		return nil, errors.New("unexpected status code " + strconv.Itoa(resp.StatusCode))
+	resp, err := c.client.Post(c.contactsUrl("/contacts/merge"), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
 	}
-	if resp.StatusCode == 404 {
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
 		return nil, nil
 	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return c.readContact(resp.Body)
+}
+
+func (c *HttpClient) Patch(id int, contentType string, patch interface{}) (*server.Contact, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("PATCH", c.contactsUrl("/contacts/")+strconv.Itoa(id), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
 
-	return readContact(resp.Body)
+	return c.readContact(resp.Body)
+}
+
+func (c *HttpClient) FindById(id int) (*server.Contact, error) {
+	resp, err := c.client.Get(c.contactsUrl("/contacts/") + strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return c.readContact(resp.Body)
 }
 
 func (c *HttpClient) FindByLastNameContains(lastNamePart string) ([]server.Contact, error) {
-	resp, err := c.client.Get(c.baseUrl + "/contacts/search/lastNamePart/" + url.QueryEscape(lastNamePart))
+	resp, err := c.client.Get(c.contactsUrl("/contacts/search/lastNamePart/") + url.QueryEscape(lastNamePart))
 	if err != nil {
 		return nil, err
 	}
-	return readContactArray(resp.Body)
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return c.readContactArray(resp.Body)
 }
 
 func (c *HttpClient) FindByEmail(email string) ([]server.Contact, error) {
-	resp, err := c.client.Get(c.baseUrl + "/contacts/search/email/" + url.QueryEscape(email))
+	resp, err := c.client.Get(c.contactsUrl("/contacts/search/email/") + url.QueryEscape(email))
 	if err != nil {
 		return nil, err
 	}
-	return readContactArray(resp.Body)
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return c.readContactArray(resp.Body)
 }
 
 func (c *HttpClient) FindAll() ([]server.Contact, error) {
-	resp, err := c.client.Get(c.baseUrl + "/contacts")
+	resp, err := c.client.Get(c.contactsUrl("/contacts"))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return c.readContactArray(resp.Body)
+}
+
+func (c *HttpClient) FindDuplicates(threshold float64) ([]server.DuplicateCluster, error) {
+	query := url.Values{}
+	query.Set("threshold", strconv.FormatFloat(threshold, 'f', -1, 64))
+	resp, err := c.client.Get(c.contactsUrl("/contacts/duplicates?") + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var bodies []struct {
+		Contacts []json.RawMessage      `json:"contacts"`
+		Pairs    []server.DuplicatePair `json:"pairs"`
+		Score    float64                `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bodies); err != nil {
+		return nil, err
+	}
+
+	var clusters []server.DuplicateCluster
+	for _, body := range bodies {
+		contacts, err := c.decodeContacts(body.Contacts)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, server.DuplicateCluster{Contacts: contacts, Pairs: body.Pairs, Score: body.Score})
+	}
+	return clusters, nil
+}
+
+func (c *HttpClient) ImportVCards(r io.Reader) ([]server.Contact, error) {
+	resp, err := c.client.Post(c.contactsUrl("/contacts/import"), server.VCardContentType, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return c.readContactArray(resp.Body)
+}
+
+func (c *HttpClient) exportVCard(w io.Writer, path string, version string) (bool, error) {
+	resp, err := c.client.Get(c.contactsUrl(path) + "?version=" + url.QueryEscape(version))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err == nil, err
+}
+
+func (c *HttpClient) ExportVCards(w io.Writer, version string) error {
+	_, err := c.exportVCard(w, "/contacts/export.vcf", version)
+	return err
+}
+
+func (c *HttpClient) ExportVCard(w io.Writer, id int, version string) (bool, error) {
+	return c.exportVCard(w, "/contacts/"+strconv.Itoa(id)+".vcf", version)
+}
+
+func (c *HttpClient) ImportCsv(r io.Reader, layout string) (server.CsvImportReport, error) {
+	var report server.CsvImportReport
+
+	endpoint := c.contactsUrl("/contacts/import.csv")
+	if layout != "" {
+		endpoint += "?layout=" + url.QueryEscape(layout)
+	}
+	resp, err := c.client.Post(endpoint, server.CsvContentType, r)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return report, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	return report, err
+}
+
+func (c *HttpClient) ExportCsv(w io.Writer, layout string) error {
+	resp, err := c.client.Get(c.contactsUrl("/contacts/export.csv?layout=") + url.QueryEscape(layout))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *HttpClient) ImportLdif(r io.Reader) (server.LdifImportReport, error) {
+	var report server.LdifImportReport
+
+	resp, err := c.client.Post(c.contactsUrl("/contacts/import.ldif"), server.LdifContentType, r)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return report, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	return report, err
+}
+
+func (c *HttpClient) ExportLdif(w io.Writer, baseDn string) error {
+	resp, err := c.client.Get(c.contactsUrl("/contacts/export.ldif?baseDn=") + url.QueryEscape(baseDn))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *HttpClient) ExportNdjson(afterId int, fn func(contact server.Contact, total int) error) error {
+	resp, err := c.client.Get(c.contactsUrl("/contacts/export.ndjson?after=") + strconv.Itoa(afterId))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	total, _ := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var contact server.Contact
+		if err := decoder.Decode(&contact); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(contact, total); err != nil {
+			return err
+		}
+	}
+}
+
+// Counts lines passing through, reporting progress every ndjsonProgressInterval lines
+type lineCountingReader struct {
+	reader   io.Reader
+	lines    int
+	progress func(server.NdjsonImportProgress)
+}
+
+const ndjsonProgressInterval = 100
+
+func (l *lineCountingReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	for _, b := range p[:n] {
+		if b != '\n' {
+			continue
+		}
+		l.lines++
+		if l.lines%ndjsonProgressInterval == 0 {
+			l.progress(server.NdjsonImportProgress{Processed: l.lines})
+		}
+	}
+	return n, err
+}
+
+func (c *HttpClient) ImportNdjson(r io.Reader, afterId int, progress func(server.NdjsonImportProgress)) (server.NdjsonImportProgress, error) {
+	var final server.NdjsonImportProgress
+	if progress == nil {
+		progress = func(server.NdjsonImportProgress) {}
+	}
+
+	body := &lineCountingReader{reader: r, progress: progress}
+	resp, err := c.client.Post(c.contactsUrl("/contacts/import.ndjson?after=")+strconv.Itoa(afterId), server.NdjsonContentType, body)
+	if err != nil {
+		return final, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return final, err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event server.NdjsonImportProgress
+		if err := decoder.Decode(&event); err == io.EOF {
+			return final, errors.New("import response ended without summary")
+		} else if err != nil {
+			return final, err
+		}
+
+		progress(event)
+		if event.Done {
+			return event, nil
+		}
+	}
+}
+
+func (c *HttpClient) Backup() (*server.Backup, error) {
+	resp, err := c.client.Get(c.adminUrl("/admin/backup"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	return server.ReadBackup(resp.Body)
+}
+
+func (c *HttpClient) Restore(backup *server.Backup) (int, error) {
+	var body bytes.Buffer
+	if err := server.WriteBackup(&body, backup); err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Post(c.adminUrl("/admin/restore"), "application/json", &body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Restored int `json:"restored"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result.Restored, err
+}
+
+func (c *HttpClient) CreateBook(name string) (server.Book, error) {
+	return c.createBook(map[string]interface{}{"name": name})
+}
+
+func (c *HttpClient) CreatePersonalBook(name string) (server.Book, error) {
+	return c.createBook(map[string]interface{}{"name": name, "personal": true})
+}
+
+func (c *HttpClient) createBook(request map[string]interface{}) (server.Book, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return server.Book{}, err
+	}
+	resp, err := c.client.Post(c.baseUrl+"/books", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return server.Book{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return server.Book{}, err
+	}
+
+	var book server.Book
+	err = json.NewDecoder(resp.Body).Decode(&book)
+	return book, err
+}
+
+func (c *HttpClient) ListBooks() ([]server.Book, error) {
+	resp, err := c.client.Get(c.baseUrl + "/books")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var books []server.Book
+	err = json.NewDecoder(resp.Body).Decode(&books)
+	return books, err
+}
+
+func (c *HttpClient) DeleteBook(name string) (bool, error) {
+	req, err := http.NewRequest("DELETE", c.baseUrl+"/books/"+url.PathEscape(name), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *HttpClient) sharesUrl(book string, id int, subject string) string {
+	path := c.baseUrl + "/books/" + url.PathEscape(book)
+	if id != 0 {
+		path += "/contacts/" + strconv.Itoa(id)
+	}
+	return path + "/shares/" + url.PathEscape(subject)
+}
+
+func (c *HttpClient) share(shareUrl string, access server.Access) error {
+	body, err := json.Marshal(map[string]server.Access{"access": access})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", shareUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (c *HttpClient) unshare(shareUrl string) (bool, error) {
+	req, err := http.NewRequest("DELETE", shareUrl, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *HttpClient) ShareBook(book string, subject string, access server.Access) error {
+	return c.share(c.sharesUrl(book, 0, subject), access)
+}
+
+func (c *HttpClient) UnshareBook(book string, subject string) (bool, error) {
+	return c.unshare(c.sharesUrl(book, 0, subject))
+}
+
+func (c *HttpClient) ShareContact(book string, id int, subject string, access server.Access) error {
+	return c.share(c.sharesUrl(book, id, subject), access)
+}
+
+func (c *HttpClient) UnshareContact(book string, id int, subject string) (bool, error) {
+	return c.unshare(c.sharesUrl(book, id, subject))
+}
+
+func (c *HttpClient) ListShares(book string) ([]server.Share, error) {
+	resp, err := c.client.Get(c.baseUrl + "/books/" + url.PathEscape(book) + "/shares")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var shares []server.Share
+	err = json.NewDecoder(resp.Body).Decode(&shares)
+	return shares, err
+}
+
+func (c *HttpClient) SharedWithMe() ([]server.SharedItem, error) {
+	resp, err := c.client.Get(c.baseUrl + "/shared")
 	if err != nil {
 		return nil, err
 	}
-	return readContactArray(resp.Body)
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var shared []server.SharedItem
+	err = json.NewDecoder(resp.Body).Decode(&shared)
+	return shared, err
+}
+
+func (c *HttpClient) Audit(filter server.AuditFilter) ([]server.AuditEvent, error) {
+	query := url.Values{}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if filter.Op != "" {
+		query.Set("op", filter.Op)
+	}
+	if filter.Actor != "" {
+		query.Set("actor", filter.Actor)
+	}
+	if filter.ContactId != 0 {
+		query.Set("contactId", strconv.Itoa(filter.ContactId))
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	resp, err := c.client.Get(c.baseUrl + "/admin/audit?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var events []server.AuditEvent
+	err = json.NewDecoder(resp.Body).Decode(&events)
+	return events, err
+}
+
+func (c *HttpClient) CreateApiKey(label string) (server.CreatedApiKey, error) {
+	body, err := json.Marshal(map[string]string{"label": label})
+	if err != nil {
+		return server.CreatedApiKey{}, err
+	}
+	resp, err := c.client.Post(c.baseUrl+"/admin/keys", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return server.CreatedApiKey{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return server.CreatedApiKey{}, err
+	}
+
+	var created server.CreatedApiKey
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	return created, err
+}
+
+func (c *HttpClient) ListApiKeys() ([]server.ApiKey, error) {
+	resp, err := c.client.Get(c.baseUrl + "/admin/keys")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var keys []server.ApiKey
+	err = json.NewDecoder(resp.Body).Decode(&keys)
+	return keys, err
+}
+
+func (c *HttpClient) RevokeApiKey(id string) (bool, error) {
+	req, err := http.NewRequest("DELETE", c.baseUrl+"/admin/keys/"+url.PathEscape(id), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 var _ Client = (*HttpClient)(nil)
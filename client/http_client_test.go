@@ -0,0 +1,212 @@
+package client_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"example.com/contacts/client"
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpClientProblemErrors(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", server.ProblemContentType)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"type":"/problems/conflict","title":"Conflict","status":409,"detail":"merged contacts changed during merge"}`))
+	}))
+	defer testServer.Close()
+
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL)
+	_, err := httpClient.Merge(server.MergeRequest{Ids: []int{1, 2}, SurvivorId: 1})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, client.ErrConflict))
+
+	var problemErr *client.ProblemError
+	require.True(t, errors.As(err, &problemErr))
+	assert.Equal(t, "merged contacts changed during merge", problemErr.Problem.Detail)
+}
+
+func TestHttpClientPlainErrors(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gateway down", http.StatusBadGateway)
+	}))
+	defer testServer.Close()
+
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL)
+	_, err := httpClient.FindDuplicates(server.DefaultDuplicateThreshold)
+	assert.True(t, errors.Is(err, client.ErrInternal))
+}
+
+func TestHttpClientRestContract(t *testing.T) {
+	for _, version := range server.ApiVersions {
+		t.Run(version.Name, func(t *testing.T) {
+			testRestContract(t, version)
+		})
+	}
+}
+
+func testRestContract(t *testing.T, version *server.ApiVersion) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	testServer := httptest.NewServer(restServer.Handler())
+	defer testServer.Close()
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL).WithVersion(version)
+
+	contact, err := httpClient.InsertWithNewId(server.Contact{Name: "Pete", LastName: "Best", Email: "pete@beatles.com"})
+	require.NoError(t, err)
+	assert.Equal(t, server.Contact{Id: 1, Name: "Pete", LastName: "Best", Email: "pete@beatles.com"}, contact)
+
+	_, err = httpClient.InsertWithNewId(server.Contact{Name: "Peter", LastName: "Best", Email: "Pete@Beatles.com"})
+	require.NoError(t, err)
+	clusters, err := httpClient.FindDuplicates(0.5)
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "Best", clusters[0].Contacts[0].LastName)
+
+	contact.Name = "Peter"
+	updated, err := httpClient.Update(contact)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	_, err = httpClient.Update(server.Contact{Id: 1, Name: "Peter"})
+	assert.True(t, errors.Is(err, client.ErrValidation))
+
+	deleted, err := httpClient.Delete(contact)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = httpClient.Delete(contact)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	found, err := httpClient.FindById(contact.Id)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestHttpClientApiKey(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	store, err := server.NewApiKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	require.NoError(t, err)
+	// Without a policy only keys created as admin may manage keys
+	admin, err := store.Create("admin", server.RoleAdmin)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(restServer.WithApiKeys(store).Handler())
+	defer testServer.Close()
+
+	_, err = client.NewContactsClient(testServer.Client(), testServer.URL).FindAll()
+	assert.True(t, errors.Is(err, client.ErrUnauthorized))
+
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL).WithApiKey(admin.Key)
+	created, err := httpClient.CreateApiKey("laptop")
+	require.NoError(t, err)
+	assert.Equal(t, "laptop", created.Label)
+
+	keys, err := httpClient.ListApiKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	laptopClient := client.NewContactsClient(testServer.Client(), testServer.URL).WithApiKey(created.Key)
+	_, err = laptopClient.FindAll()
+	require.NoError(t, err)
+	_, err = laptopClient.ListApiKeys()
+	assert.True(t, errors.Is(err, client.ErrForbidden))
+
+	revoked, err := httpClient.RevokeApiKey(created.Id)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = httpClient.RevokeApiKey(created.Id)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestHttpClientBooks(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	testServer := httptest.NewServer(restServer.Handler())
+	defer testServer.Close()
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL)
+
+	_, err = httpClient.CreateBook("team-a")
+	require.NoError(t, err)
+
+	teamClient := httpClient.InBook("team-a")
+	contact, err := teamClient.InsertWithNewId(server.Contact{Name: "Pete", LastName: "Best", Email: "pete@beatles.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, contact.Id)
+
+	teamContacts, err := teamClient.FindAll()
+	require.NoError(t, err)
+	assert.Equal(t, []server.Contact{contact}, teamContacts)
+	defaultContacts, err := httpClient.FindAll()
+	require.NoError(t, err)
+	assert.Len(t, defaultContacts, 4)
+
+	books, err := httpClient.ListBooks()
+	require.NoError(t, err)
+	assert.Len(t, books, 2)
+
+	require.NoError(t, httpClient.ShareBook("team-a", "jwt:jane", server.AccessWrite))
+	require.NoError(t, httpClient.ShareContact("team-a", 1, "jwt:joe", server.AccessRead))
+	assert.True(t, errors.Is(httpClient.ShareContact("team-a", 9, "jwt:joe", server.AccessRead), client.ErrNotFound))
+	shares, err := httpClient.ListShares("team-a")
+	require.NoError(t, err)
+	assert.Equal(t, []server.Share{
+		{Subject: "jwt:jane", Access: server.AccessWrite},
+		{Subject: "jwt:joe", ContactId: 1, Access: server.AccessRead},
+	}, shares)
+	unshared, err := httpClient.UnshareContact("team-a", 1, "jwt:joe")
+	require.NoError(t, err)
+	assert.True(t, unshared)
+	unshared, err = httpClient.UnshareBook("team-a", "jwt:joe")
+	require.NoError(t, err)
+	assert.False(t, unshared)
+
+	deleted, err := httpClient.DeleteBook("team-a")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = teamClient.FindAll()
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+}
+
+func TestHttpClientBookBackupAndRestore(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	testServer := httptest.NewServer(restServer.Handler())
+	defer testServer.Close()
+	httpClient := client.NewContactsClient(testServer.Client(), testServer.URL)
+
+	_, err = httpClient.CreateBook("team-a")
+	require.NoError(t, err)
+	teamClient := httpClient.InBook("team-a")
+	_, err = teamClient.InsertWithNewId(server.Contact{Name: "Pete", LastName: "Best", Email: "pete@beatles.com"})
+	require.NoError(t, err)
+
+	backup, err := teamClient.Backup()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backup.Count)
+
+	_, err = teamClient.InsertWithNewId(server.Contact{Name: "Stuart", LastName: "Sutcliffe", Email: "stuart@beatles.com"})
+	require.NoError(t, err)
+	restored, err := teamClient.Restore(backup)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored)
+
+	teamContacts, err := teamClient.FindAll()
+	require.NoError(t, err)
+	require.Len(t, teamContacts, 1)
+	assert.Equal(t, "Pete", teamContacts[0].Name)
+	defaultContacts, err := httpClient.FindAll()
+	require.NoError(t, err)
+	assert.Len(t, defaultContacts, 4)
+}
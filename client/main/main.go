@@ -1,15 +1,63 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 
 	"example.com/contacts/client"
+	"golang.org/x/term"
 )
 
+// Reads passphrase from CONTACTS_PASSPHRASE, or prompts for it without echo on the terminal
+func promptPassphrase(confirm bool) ([]byte, error) {
+	if passphrase := os.Getenv("CONTACTS_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("passphrase required - set CONTACTS_PASSPHRASE or run in a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
 func main() {
-	endpoint := "http://localhost:8080"
-	restClient := client.NewContactsClient(&http.Client{}, endpoint)
-	cli := client.NewCliClient(restClient)
+	config, err := client.LoadConfig(client.DefaultConfigPath())
+	if err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+
+	restClient := client.NewContactsClient(&http.Client{}, config.Endpoint)
+	if config.ApiKey != "" {
+		restClient.WithApiKey(config.ApiKey)
+	}
+	if config.Token != "" {
+		restClient.WithBearerToken(config.Token)
+	}
+	cli := client.NewCliClient(restClient).WithPassphrasePrompt(promptPassphrase)
 	cli.HandleCommand(os.Args[1:])
 }
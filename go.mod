@@ -2,12 +2,18 @@ module example.com/contacts
 
 go 1.17
 
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/stretchr/testify v1.7.1
+	golang.org/x/crypto v0.1.0
+	golang.org/x/term v0.1.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
-	github.com/stretchr/testify v1.7.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	golang.org/x/sys v0.1.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
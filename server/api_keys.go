@@ -0,0 +1,290 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ApiKeyHeader = "X-API-Key"
+	// Method of identities authenticated by API keys
+	ApiKeyMethod = "apikey"
+	apiKeyPrefix = "ck_"
+
+	// Last use is persisted at most this often per key, not on every request
+	apiKeyLastUsedFlushInterval = time.Minute
+)
+
+var ErrInvalidApiKey = errors.New("invalid API key")
+
+type ApiKey struct {
+	Id    string `json:"id"`
+	Label string `json:"label"`
+	// Granted to callers of the key whatever the policy says of its subject, e.g. admin to the key
+	// bootstrapping the server
+	Roles      []string   `json:"roles,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Returned only once on creation - the server keeps just a hash of the key
+type CreatedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+type storedApiKey struct {
+	ApiKey
+	// Hex SHA-256 of the whole key. Keys are random, so a slow hash is not needed
+	Hash string `json:"hash"`
+
+	flushedAt time.Time
+}
+
+type apiKeyFile struct {
+	Keys []*storedApiKey `json:"keys"`
+}
+
+// API keys persisted as hashes in a JSON file
+type ApiKeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]*storedApiKey
+}
+
+// Loads keys from file, which is created on the first new key if it does not exist
+func NewApiKeyStore(path string) (*ApiKeyStore, error) {
+	store := &ApiKeyStore{path: path, keys: make(map[string]*storedApiKey)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file apiKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for _, key := range file.Keys {
+		key.flushedAt = time.Now()
+		store.keys[key.Id] = key
+	}
+	return store, nil
+}
+
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Writes all keys, callers hold the lock
+func (s *ApiKeyStore) save() error {
+	file := apiKeyFile{Keys: []*storedApiKey{}}
+	for _, key := range s.keys {
+		file.Keys = append(file.Keys, key)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool {
+		return file.Keys[i].CreatedAt.Before(file.Keys[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replaced atomically, so a crash never leaves a truncated key file
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Generates a new key granting given roles. The returned key is the only copy of its secret
+func (s *ApiKeyStore) Create(label string, roles ...string) (CreatedApiKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return CreatedApiKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return CreatedApiKey{}, err
+	}
+
+	created := CreatedApiKey{
+		ApiKey: ApiKey{Id: hex.EncodeToString(id), Label: label, Roles: roles, CreatedAt: time.Now().UTC()},
+	}
+	created.Key = apiKeyPrefix + created.Id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[created.Id] = &storedApiKey{ApiKey: created.ApiKey, Hash: hashApiKey(created.Key), flushedAt: time.Now()}
+	if err := s.save(); err != nil {
+		delete(s.keys, created.Id)
+		return CreatedApiKey{}, err
+	}
+	return created, nil
+}
+
+// Deletes key, returning false when there is no key of the id
+func (s *ApiKeyStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.keys, id)
+	if err := s.save(); err != nil {
+		s.keys[id] = key
+		return false, err
+	}
+	return true, nil
+}
+
+// All keys ordered by creation, without hashes
+func (s *ApiKeyStore) List() []ApiKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []ApiKey{}
+	for _, key := range s.keys {
+		apiKey := key.ApiKey
+		if key.LastUsedAt != nil {
+			lastUsed := *key.LastUsedAt
+			apiKey.LastUsedAt = &lastUsed
+		}
+		keys = append(keys, apiKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Checks key and records its use
+func (s *ApiKeyStore) Verify(key string) (ApiKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.keys[parts[0]]
+	if !ok || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashApiKey(key))) != 1 {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+
+	now := time.Now().UTC()
+	stored.LastUsedAt = &now
+	if now.Sub(stored.flushedAt) >= apiKeyLastUsedFlushInterval {
+		stored.flushedAt = now
+		// Failing to record last use must not lock callers out
+		_ = s.save()
+	}
+	return stored.ApiKey, nil
+}
+
+// Authenticates requests by the X-API-Key header
+func (s *ApiKeyStore) Authenticate(req *http.Request) (*Identity, error) {
+	key := req.Header.Get(ApiKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+
+	apiKey, err := s.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: apiKey.Id, Name: apiKey.Label, Method: ApiKeyMethod, Roles: apiKey.Roles}, nil
+}
+
+func (s *ApiKeyStore) Challenge() string {
+	return `ApiKey realm="contacts"`
+}
+
+var _ Authenticator = (*ApiKeyStore)(nil)
+
+type createApiKeyRequest struct {
+	Label string `json:"label"`
+}
+
+func (r *RestServer) apiKeyStore() (*ApiKeyStore, error) {
+	if r.apiKeys == nil {
+		return nil, NotFound("API keys are not enabled")
+	}
+	return r.apiKeys, nil
+}
+
+func (r *RestServer) listApiKeys(w http.ResponseWriter, req *http.Request) error {
+	store, err := r.apiKeyStore()
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "listApiKeys", nil)
+	return writeJson(store.List(), w)
+}
+
+func (r *RestServer) createApiKey(w http.ResponseWriter, req *http.Request) error {
+	store, err := r.apiKeyStore()
+	if err != nil {
+		return err
+	}
+
+	var createReq createApiKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&createReq); err != nil {
+		return BadRequest("invalid request body: %s", err)
+	}
+	if strings.TrimSpace(createReq.Label) == "" {
+		return Invalid(&ValidationError{Field: "label", Message: "empty API key label"})
+	}
+
+	created, err := store.Create(createReq.Label)
+	if err != nil {
+		return err
+	}
+	r.auditLog(req, "createApiKey", created.ApiKey)
+
+	w.Header().Set("Location", "/admin/keys/"+created.Id)
+	return writeResponse(w, req, http.StatusCreated, created, nil)
+}
+
+func (r *RestServer) revokeApiKey(w http.ResponseWriter, req *http.Request) error {
+	store, err := r.apiKeyStore()
+	if err != nil {
+		return err
+	}
+
+	id := mux.Vars(req)["id"]
+	r.auditLog(req, "revokeApiKey", id)
+
+	revoked, err := store.Revoke(id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return NotFound("API key %s not found", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
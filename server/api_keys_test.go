@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := server.NewApiKeyStore(path)
+	require.NoError(t, err)
+
+	created, err := store.Create("ci", server.RoleEditor)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Key, "ck_"+created.Id+"_"))
+	assert.Equal(t, "ci", created.Label)
+	assert.Equal(t, []string{server.RoleEditor}, created.Roles)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), created.Key)
+
+	// Keys survive restart
+	store, err = server.NewApiKeyStore(path)
+	require.NoError(t, err)
+	apiKey, err := store.Verify(created.Key)
+	require.NoError(t, err)
+	assert.Equal(t, created.Id, apiKey.Id)
+	assert.Equal(t, []string{server.RoleEditor}, apiKey.Roles)
+	require.NotNil(t, store.List()[0].LastUsedAt)
+
+	_, err = store.Verify(created.Key + "x")
+	assert.ErrorIs(t, err, server.ErrInvalidApiKey)
+	_, err = store.Verify("secret")
+	assert.ErrorIs(t, err, server.ErrInvalidApiKey)
+
+	revoked, err := store.Revoke(created.Id)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = store.Revoke(created.Id)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	_, err = store.Verify(created.Key)
+	assert.ErrorIs(t, err, server.ErrInvalidApiKey)
+	assert.Empty(t, store.List())
+}
+
+func TestRestApiKeyAuthentication(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	store, err := server.NewApiKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	require.NoError(t, err)
+	admin, err := store.Create("admin")
+	require.NoError(t, err)
+	policy := server.DefaultPolicy()
+	policy.Subjects["apikey:"+admin.Id] = []string{server.RoleAdmin}
+	testServer := httptest.NewServer(restServer.WithApiKeys(store).WithPolicy(policy).Handler())
+	t.Cleanup(testServer.Close)
+
+	withKey := func(method string, path string, key string, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set(server.ApiKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := withKey("GET", "/v1/contacts", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	resp = withKey("GET", "/v1/contacts", "ck_0000_wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = withKey("GET", "/v1/contacts", admin.Key, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = withKey("GET", "/openapi.json", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = withKey("POST", "/admin/keys", admin.Key, `{"label":"laptop"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var created server.CreatedApiKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, "/admin/keys/"+created.Id, resp.Header.Get("Location"))
+
+	resp = withKey("POST", "/admin/keys", admin.Key, `{"label":" "}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// New keys are viewers unless the policy says otherwise
+	resp = withKey("GET", "/admin/keys", created.Key, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = withKey("GET", "/v1/contacts", created.Key, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = withKey("GET", "/admin/keys", admin.Key, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var keys []server.ApiKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+	require.Len(t, keys, 2)
+	assert.Equal(t, []string{"admin", "laptop"}, []string{keys[0].Label, keys[1].Label})
+
+	resp = withKey("DELETE", "/admin/keys/"+created.Id, admin.Key, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = withKey("DELETE", "/admin/keys/"+created.Id, admin.Key, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = withKey("GET", "/v1/contacts", created.Key, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Contact as exposed by API v1 - the original flat shape
+type ContactV1 struct {
+	Id int `json:"id"`
+
+	Name     string `json:"name"`
+	LastName string `json:"lastName"`
+	Email    string `json:"email"`
+
+	Phones  []string `json:"phones,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Notes   string   `json:"notes,omitempty"`
+}
+
+type PersonName struct {
+	Given  string `json:"given"`
+	Family string `json:"family"`
+}
+
+// Contact as exposed by API v2 - structured name, every field always present
+type ContactV2 struct {
+	Id int `json:"id"`
+
+	Name  PersonName `json:"name"`
+	Email string     `json:"email"`
+
+	Phones  []string `json:"phones"`
+	Address string   `json:"address"`
+	Notes   string   `json:"notes"`
+}
+
+// Translates between internal contacts and their representation in one version of the API
+type ApiVersion struct {
+	Name string
+	// Path prefix of the version's routes
+	Prefix string
+
+	// Converts contact to the version's response body
+	EncodeContact func(contact Contact) interface{}
+	// Parses the version's request body
+	DecodeContact func(data []byte) (Contact, error)
+
+	// Schemas of the OpenAPI document replaced by the version's own
+	schemas map[string]string
+}
+
+var ApiV1 = &ApiVersion{
+	Name:   "v1",
+	Prefix: "/v1",
+	EncodeContact: func(contact Contact) interface{} {
+		return ContactV1(contact)
+	},
+	DecodeContact: func(data []byte) (Contact, error) {
+		var contact ContactV1
+		err := json.Unmarshal(data, &contact)
+		return Contact(contact), err
+	},
+}
+
+var ApiV2 = &ApiVersion{
+	Name:   "v2",
+	Prefix: "/v2",
+	EncodeContact: func(contact Contact) interface{} {
+		phones := contact.Phones
+		if phones == nil {
+			phones = []string{}
+		}
+		return ContactV2{
+			Id:      contact.Id,
+			Name:    PersonName{Given: contact.Name, Family: contact.LastName},
+			Email:   contact.Email,
+			Phones:  phones,
+			Address: contact.Address,
+			Notes:   contact.Notes,
+		}
+	},
+	DecodeContact: func(data []byte) (Contact, error) {
+		var contact ContactV2
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&contact); err != nil {
+			return Contact{}, err
+		}
+		if len(contact.Phones) == 0 {
+			contact.Phones = nil
+		}
+		return Contact{
+			Id:       contact.Id,
+			Name:     contact.Name.Given,
+			LastName: contact.Name.Family,
+			Email:    contact.Email,
+			Phones:   contact.Phones,
+			Address:  contact.Address,
+			Notes:    contact.Notes,
+		}, nil
+	},
+	schemas: map[string]string{"Contact": "ContactV2", "DuplicateCluster": "DuplicateClusterV2"},
+}
+
+var ApiVersions = []*ApiVersion{ApiV1, ApiV2}
+
+func (v *ApiVersion) encodeContacts(contacts []Contact) []interface{} {
+	encoded := make([]interface{}, 0, len(contacts))
+	for _, contact := range contacts {
+		encoded = append(encoded, v.EncodeContact(contact))
+	}
+	return encoded
+}
+
+// Duplicate cluster with contacts in the version's representation
+type duplicateClusterBody struct {
+	Contacts []interface{}   `json:"contacts"`
+	Pairs    []DuplicatePair `json:"pairs"`
+	Score    float64         `json:"score"`
+}
+
+func (v *ApiVersion) encodeClusters(clusters []DuplicateCluster) []duplicateClusterBody {
+	encoded := make([]duplicateClusterBody, 0, len(clusters))
+	for _, cluster := range clusters {
+		encoded = append(encoded, duplicateClusterBody{
+			Contacts: v.encodeContacts(cluster.Contacts),
+			Pairs:    cluster.Pairs,
+			Score:    cluster.Score,
+		})
+	}
+	return encoded
+}
+
+type contextKey int
+
+const (
+	apiVersionKey contextKey = iota
+	negotiatedCodecKey
+	identityKey
+	bookKey
+	auditEventKey
+)
+
+// Version of the API the request was routed to - unversioned routes are v1
+func apiVersionOf(req *http.Request) *ApiVersion {
+	if version, ok := req.Context().Value(apiVersionKey).(*ApiVersion); ok {
+		return version
+	}
+	return ApiV1
+}
+
+func withApiVersion(version *ApiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), apiVersionKey, version)))
+		})
+	}
+}
+
+// Marks unversioned routes as deprecated in favor of the same route under /v1
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+ApiV1.Prefix+req.URL.Path+`>; rel="successor-version"`)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiVersionsRoundTrip(t *testing.T) {
+	contact := server.Contact{Id: 7, Name: "George", LastName: "Harrison", Email: "george@beatles.com", Phones: []string{"+44 7"}}
+
+	for _, version := range server.ApiVersions {
+		data, err := json.Marshal(version.EncodeContact(contact))
+		require.NoError(t, err)
+		decoded, err := version.DecodeContact(data)
+		require.NoError(t, err, version.Name)
+		assert.Equal(t, contact, decoded, version.Name)
+	}
+
+	data, err := json.Marshal(server.ApiV2.EncodeContact(server.Contact{Id: 1, Name: "Ringo", LastName: "Starr", Email: "ringo@beatles.com"}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"name":{"given":"Ringo","family":"Starr"},"email":"ringo@beatles.com","phones":[],"address":"","notes":""}`, string(data))
+
+	_, err = server.ApiV2.DecodeContact([]byte(`{"name":"Ringo","lastName":"Starr","email":"ringo@beatles.com"}`))
+	assert.Error(t, err)
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp := doRequest(t, "POST", testServer.URL+"/v2/contacts", `{"name":{"given":"Pete","family":"Best"},"email":"pete@beatles.com"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/v2/contacts/5", resp.Header.Get("Location"))
+
+	resp = doRequest(t, "GET", testServer.URL+"/v1/contacts/5", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+	var v1 server.ContactV1
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&v1))
+	assert.Equal(t, server.ContactV1{Id: 5, Name: "Pete", LastName: "Best", Email: "pete@beatles.com"}, v1)
+
+	req, err := http.NewRequest("PATCH", testServer.URL+"/v2/contacts/5", strings.NewReader(`{"name":{"given":"Peter"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", server.MergePatchContentType)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var v2 server.ContactV2
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&v2))
+	assert.Equal(t, server.PersonName{Given: "Peter", Family: "Best"}, v2.Name)
+
+	resp = doRequest(t, "GET", testServer.URL+"/contacts/5", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+	assert.Equal(t, `</v1/contacts/5>; rel="successor-version"`, resp.Header.Get("Link"))
+}
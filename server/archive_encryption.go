@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted archive layout, version 1:
+//
+//	magic (8) | version (1) | scrypt log2(N) (1) | scrypt r (1) | scrypt p (1) | salt (16) | nonce (12) | ciphertext
+//
+// The whole header is authenticated as additional data of AES-256-GCM, so tampering with
+// either header or ciphertext is detected on decryption
+const (
+	encryptedArchiveMagic   = "CTBKENC\x00"
+	encryptedArchiveVersion = 1
+
+	scryptLogN    = 15
+	scryptR       = 8
+	scryptP       = 1
+	archiveKeyLen = 32
+	archiveSalt   = 16
+
+	// Headers are read before anything is authenticated, so costlier parameters are refused rather
+	// than letting a crafted archive claim gigabytes of memory for key derivation
+	scryptMaxLogN = 17
+
+	encryptedArchiveHeaderLen = len(encryptedArchiveMagic) + 4 + archiveSalt
+)
+
+var ErrArchiveAuthentication = errors.New("wrong passphrase or tampered archive")
+
+// Returns true when data starts with the header of an encrypted archive
+func IsEncryptedArchive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedArchiveMagic))
+}
+
+func archiveGCM(passphrase []byte, salt []byte, logN, r, p byte) (cipher.AEAD, error) {
+	if logN < 10 || logN > scryptMaxLogN || r != scryptR || p != scryptP {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, int(r), int(p), archiveKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts data with a key derived from the passphrase
+func EncryptArchive(plaintext []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	header := make([]byte, 0, encryptedArchiveHeaderLen)
+	header = append(header, encryptedArchiveMagic...)
+	header = append(header, encryptedArchiveVersion, scryptLogN, scryptR, scryptP)
+	salt := make([]byte, archiveSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	gcm, err := archiveGCM(passphrase, salt, scryptLogN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	result := append(header, nonce...)
+	return gcm.Seal(result, nonce, plaintext, header), nil
+}
+
+// Decrypts data created by EncryptArchive, failing with ErrArchiveAuthentication if it was modified
+func DecryptArchive(data []byte, passphrase []byte) ([]byte, error) {
+	if !IsEncryptedArchive(data) {
+		return nil, errors.New("not an encrypted archive")
+	}
+	if len(data) < encryptedArchiveHeaderLen {
+		return nil, ErrArchiveAuthentication
+	}
+
+	header := data[:encryptedArchiveHeaderLen]
+	params := header[len(encryptedArchiveMagic):]
+	if params[0] != encryptedArchiveVersion {
+		return nil, fmt.Errorf("unsupported encrypted archive version %d", params[0])
+	}
+	salt := header[len(encryptedArchiveMagic)+4:]
+
+	gcm, err := archiveGCM(passphrase, salt, params[1], params[2], params[3])
+	if err != nil {
+		return nil, err
+	}
+
+	rest := data[encryptedArchiveHeaderLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrArchiveAuthentication
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, ErrArchiveAuthentication
+	}
+	return plaintext, nil
+}
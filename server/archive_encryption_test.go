@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptArchiveRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"format": "contacts-backup"}`)
+
+	encrypted, err := server.EncryptArchive(plaintext, []byte("secret"))
+	require.NoError(t, err)
+	assert.True(t, server.IsEncryptedArchive(encrypted))
+	assert.False(t, server.IsEncryptedArchive(plaintext))
+	assert.NotContains(t, string(encrypted), "contacts-backup")
+
+	decrypted, err := server.DecryptArchive(encrypted, []byte("secret"))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	_, err = server.DecryptArchive(encrypted, []byte("wrong"))
+	assert.Equal(t, server.ErrArchiveAuthentication, err)
+}
+
+func TestDecryptTamperedArchive(t *testing.T) {
+	encrypted, err := server.EncryptArchive([]byte("contacts"), []byte("secret"))
+	require.NoError(t, err)
+
+	// Salt byte in the header, then last byte of the ciphertext
+	for _, i := range []int{14, len(encrypted) - 1} {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] ^= 1
+
+		_, err = server.DecryptArchive(tampered, []byte("secret"))
+		assert.Equal(t, server.ErrArchiveAuthentication, err, "byte %d", i)
+	}
+
+	_, err = server.DecryptArchive(encrypted[:20], []byte("secret"))
+	assert.Error(t, err)
+}
+
+func TestDecryptArchiveKeyDerivationBounds(t *testing.T) {
+	encrypted, err := server.EncryptArchive([]byte("contacts"), []byte("secret"))
+	require.NoError(t, err)
+
+	// log2(N), r and p follow magic and version, costlier values are refused before deriving a key
+	for i, value := range map[int]byte{9: 30, 10: 64, 11: 16} {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] = value
+
+		_, err = server.DecryptArchive(tampered, []byte("secret"))
+		assert.EqualError(t, err, "invalid key derivation parameters", "byte %d", i)
+	}
+}
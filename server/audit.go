@@ -0,0 +1,586 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Records between signed checkpoints, unless set with WithCheckpoints
+const DefaultAuditCheckpointInterval = 100
+
+var ErrAuditTampered = errors.New("audit log tampered")
+
+// Line of the audit file. Each record holds the SHA-256 of the previous line, so editing or removing
+// a record breaks the chain at the record following it
+type auditRecord struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Hex hash of the previous line, empty for the first record
+	Prev       string           `json:"prev"`
+	Event      json.RawMessage  `json:"event,omitempty"`
+	Checkpoint *auditCheckpoint `json:"checkpoint,omitempty"`
+	Pruning    *auditPruning    `json:"pruning,omitempty"`
+}
+
+// Attests the chain up to the record, so it cannot be rewritten without the key
+type auditCheckpoint struct {
+	// Hex HMAC-SHA256 of "<seq>:<prev>"
+	Signature string `json:"signature"`
+}
+
+// Attests that retention deleted rotated segments, so verification tells them from records removed
+// otherwise
+type auditPruning struct {
+	// File names of the deleted segments
+	Segments []string `json:"segments"`
+	// Sequence number of the last record deleted
+	Through uint64 `json:"through"`
+	// Hex HMAC-SHA256 of "<seq>:<prev>:<through>", empty without key
+	Signature string `json:"signature,omitempty"`
+}
+
+// When the audit file is rotated into a compressed segment and how long segments are kept. Zero
+// values disable the respective limit
+type AuditRotation struct {
+	// Bytes of the audit file
+	MaxSize int64
+	// Time since the audit file was opened
+	MaxAge time.Duration
+	// Segments older than retention are deleted on rotation
+	Retention   time.Duration
+	MaxSegments int
+}
+
+// Append-only, hash-chained audit file. Safe for concurrent use
+type AuditLog struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+	seq    uint64
+	last   string
+
+	key             []byte
+	checkpointEvery uint64
+	rotation        AuditRotation
+	// Rotation is not retried before, after it failed
+	rotateRetry time.Time
+
+	// Rotated segments are compressed and retention applied in the background, one at a time
+	compressMu  sync.Mutex
+	compressing sync.WaitGroup
+}
+
+// Opens the audit file for appending, continuing the chain of records already in it or in its
+// rotated segments
+func OpenAuditLog(path string) (*AuditLog, error) {
+	seq, last, err := lastAuditRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	audit := &AuditLog{path: path, seq: seq, last: last}
+	if err := audit.open(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file, a.size, a.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Rotates the audit file according to rotation
+func (a *AuditLog) WithRotation(rotation AuditRotation) *AuditLog {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rotation = rotation
+	return a
+}
+
+// Signs a checkpoint with key after every given number of records
+func (a *AuditLog) WithCheckpoints(key []byte, every int) *AuditLog {
+	a.key = key
+	a.checkpointEvery = uint64(every)
+	return a
+}
+
+// Waits for rotated segments being compressed and closes the audit file
+func (a *AuditLog) Close() error {
+	a.compressing.Wait()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Appends event as the next record of the chain, followed by a checkpoint when one is due
+func (a *AuditLog) Append(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rotationDue() {
+		// Records keep going to the current file, so a failed rotation never stops the audit trail
+		if err := a.rotate(); err != nil {
+			log.Printf("Audit log: rotating %s: %s", a.path, err)
+			a.rotateRetry = time.Now().Add(time.Minute)
+		}
+	}
+	if err := a.write(auditRecord{Event: data}); err != nil {
+		return err
+	}
+	if a.key != nil && a.checkpointEvery > 0 && a.seq%a.checkpointEvery == 0 {
+		return a.write(auditRecord{Checkpoint: &auditCheckpoint{Signature: signAuditCheckpoint(a.key, a.seq+1, a.last)}})
+	}
+	return nil
+}
+
+func (a *AuditLog) write(record auditRecord) error {
+	record.Seq = a.seq + 1
+	record.Time = time.Now().UTC()
+	record.Prev = a.last
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.size += int64(len(line)) + 1
+	a.seq = record.Seq
+	a.last = hashAuditLine(line)
+	return nil
+}
+
+func hashAuditLine(line []byte) string {
+	hash := sha256.Sum256(line)
+	return hex.EncodeToString(hash[:])
+}
+
+func signAuditCheckpoint(key []byte, seq uint64, prev string) string {
+	return signAudit(key, strconv.FormatUint(seq, 10)+":"+prev)
+}
+
+func signAuditPruning(key []byte, seq uint64, prev string, through uint64) string {
+	return signAudit(key, strconv.FormatUint(seq, 10)+":"+prev+":"+strconv.FormatUint(through, 10))
+}
+
+func signAudit(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *AuditLog) rotationDue() bool {
+	if a.size == 0 || time.Now().Before(a.rotateRetry) {
+		return false
+	}
+	return (a.rotation.MaxSize > 0 && a.size >= a.rotation.MaxSize) ||
+		(a.rotation.MaxAge > 0 && time.Since(a.opened) >= a.rotation.MaxAge)
+}
+
+// Layout of rotation times in segment names, sorting chronologically
+const auditSegmentTime = "20060102T150405.000000000Z"
+
+// Renames the audit file into a segment named by the time of rotation and starts a new file, which
+// is all done while holding the lock. On failure the current file stays in place
+func (a *AuditLog) rotate() error {
+	segment := a.path + "." + time.Now().UTC().Format(auditSegmentTime)
+	if err := os.Rename(a.path, segment); err != nil {
+		return err
+	}
+	rotated := a.file
+	if err := a.open(); err != nil {
+		if renameErr := os.Rename(segment, a.path); renameErr != nil {
+			return fmt.Errorf("%w, records continue in %s: %s", err, segment, renameErr)
+		}
+		return err
+	}
+	if err := rotated.Close(); err != nil {
+		log.Printf("Audit log: closing %s: %s", segment, err)
+	}
+
+	a.compressing.Add(1)
+	go a.compress(segment)
+	return nil
+}
+
+// Compresses a rotated segment and deletes segments beyond retention. Segments failing to compress
+// are kept as they are
+func (a *AuditLog) compress(segment string) {
+	defer a.compressing.Done()
+	a.compressMu.Lock()
+	defer a.compressMu.Unlock()
+
+	// Retention may have deleted the segment in the meantime
+	if err := compressFile(segment, segment+".gz"); errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("Audit log: compressing %s: %s", segment, err)
+	} else if err := os.Remove(segment); err != nil {
+		log.Printf("Audit log: %s", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.applyRetention(); err != nil {
+		log.Printf("Audit log: applying retention: %s", err)
+	}
+}
+
+// Writes the compressed file under a temporary name first, so readers never see it incomplete
+func compressFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(target+".tmp", target)
+	}
+	if err != nil {
+		os.Remove(target + ".tmp")
+	}
+	return err
+}
+
+// Deletes segments beyond retention, oldest first, and records the deletion in the chain
+func (a *AuditLog) applyRetention() error {
+	segments, err := auditSegments(a.path)
+	if err != nil {
+		return err
+	}
+	pruning := &auditPruning{}
+	for i, segment := range segments {
+		expired := a.rotation.MaxSegments > 0 && len(segments)-i > a.rotation.MaxSegments
+		if a.rotation.Retention > 0 {
+			rotated, err := auditSegmentRotated(a.path, segment)
+			expired = expired || (err == nil && time.Since(rotated) > a.rotation.Retention)
+		}
+		if !expired {
+			break
+		}
+		var through uint64
+		if err = readAuditFile(segment, func(line []byte) error {
+			var record auditRecord
+			if err := json.Unmarshal(line, &record); err == nil {
+				through = record.Seq
+			}
+			return nil
+		}); err != nil {
+			break
+		}
+		if err = os.Remove(segment); err != nil {
+			break
+		}
+		pruning.Segments = append(pruning.Segments, filepath.Base(segment))
+		pruning.Through = through
+	}
+
+	// Segments deleted before a failure are recorded nonetheless
+	if len(pruning.Segments) > 0 {
+		if a.key != nil {
+			pruning.Signature = signAuditPruning(a.key, a.seq+1, a.last, pruning.Through)
+		}
+		if writeErr := a.write(auditRecord{Pruning: pruning}); err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// Rotated segments of the audit file, oldest first. Segments not compressed yet are included,
+// unless their compressed version already exists
+func auditSegments(path string) ([]string, error) {
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	compressed := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasSuffix(file, ".gz") {
+			compressed[strings.TrimSuffix(file, ".gz")] = true
+		}
+	}
+	var segments []string
+	for _, file := range files {
+		if _, err := auditSegmentRotated(path, file); err == nil && !compressed[file] {
+			segments = append(segments, file)
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// Time a segment of the audit file was rotated, taken from its name
+func auditSegmentRotated(path string, segment string) (time.Time, error) {
+	return time.Parse(auditSegmentTime, strings.TrimSuffix(strings.TrimPrefix(segment, path+"."), ".gz"))
+}
+
+// Calls fn with every line of the rotated segments and the audit file, oldest first. Segments
+// deleted in the meantime are skipped, as are segments rotated before since, which only hold older
+// records
+func readAuditLog(path string, since time.Time, fn func(file string, line []byte) error) error {
+	segments, err := auditSegments(path)
+	if err != nil {
+		return err
+	}
+	for len(segments) > 0 {
+		if rotated, err := auditSegmentRotated(path, segments[0]); err != nil || !rotated.Before(since) {
+			break
+		}
+		segments = segments[1:]
+	}
+	for _, file := range append(segments, path) {
+		if err := readAuditFile(file, func(line []byte) error { return fn(file, line) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAuditFile(path string, fn func(line []byte) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !strings.HasSuffix(path, ".gz") {
+		// Segments are replaced by their compressed version in the meantime
+		path += ".gz"
+		file, err = os.Open(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	return readAuditLines(reader, fn)
+}
+
+// Sequence number and hash of the last record of the audit file or, when it is empty, of its last
+// segment. Zero values when there is none
+func lastAuditRecord(path string) (uint64, string, error) {
+	segments, err := auditSegments(path)
+	if err != nil {
+		return 0, "", err
+	}
+	files := append(segments, path)
+
+	var last []byte
+	for i := len(files) - 1; i >= 0 && last == nil; i-- {
+		err := readAuditFile(files[i], func(line []byte) error {
+			last = line
+			return nil
+		})
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	if last == nil {
+		return 0, "", nil
+	}
+	var record auditRecord
+	if err := json.Unmarshal(last, &record); err != nil {
+		return 0, "", fmt.Errorf("audit log %s: last record unreadable, run verification: %w", path, err)
+	}
+	return record.Seq, hashAuditLine(last), nil
+}
+
+// Calls fn with every non-empty line of r, without its line break
+func readAuditLines(r io.Reader, fn func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSuffix(line, []byte("\n")); len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// First record of the audit file failing verification
+type AuditTamperError struct {
+	// Audit file or rotated segment holding the record
+	File   string
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *AuditTamperError) Error() string {
+	return fmt.Sprintf("%s tampered at line %d (seq %d): %s", filepath.Base(e.File), e.Line, e.Seq, e.Reason)
+}
+
+func (e *AuditTamperError) Unwrap() error {
+	return ErrAuditTampered
+}
+
+type AuditVerification struct {
+	// Sequence number of the first record, greater than 1 when segments were deleted by retention
+	FirstSeq    uint64
+	Records     int
+	Checkpoints int
+	// Sequence number of the last checkpoint with a valid signature. Records after it could have
+	// been removed from the end of the file undetected
+	LastCheckpoint uint64
+	// Sequence number of the last record deleted by retention, as attested by the chain
+	PrunedThrough uint64
+}
+
+// Whether a checkpoint signature was verified. A consistent chain without one proves nothing, as
+// anyone able to write the file can rewrite it and compute the hashes again
+func (v AuditVerification) Signed() bool {
+	return v.LastCheckpoint > 0
+}
+
+// Walks the audit file and its rotated segments checking sequence numbers, the hash chain and, when
+// key is given, signatures of checkpoints and pruning records. Returns an AuditTamperError of the
+// first record failing. The chain may start above the first record only when a later record attests
+// that retention deleted all records before
+func VerifyAuditLog(path string, key []byte) (AuditVerification, error) {
+	var verification AuditVerification
+	if _, err := os.Stat(path); err != nil {
+		return verification, err
+	}
+
+	var seq uint64
+	var last string
+	currentFile := ""
+	lineNumber := 0
+	var first *AuditTamperError
+	err := readAuditLog(path, time.Time{}, func(file string, line []byte) error {
+		if file != currentFile {
+			currentFile, lineNumber = file, 0
+		}
+		lineNumber++
+		var record auditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return &AuditTamperError{File: file, Line: lineNumber, Seq: seq + 1, Reason: "malformed record"}
+		}
+		if verification.Records == 0 {
+			verification.FirstSeq = 1
+			// Only rotated segments may have had older ones deleted, which the chain must attest
+			if file != path && record.Seq > 1 {
+				verification.FirstSeq = record.Seq
+				seq, last = record.Seq-1, record.Prev
+				first = &AuditTamperError{File: file, Line: lineNumber, Seq: record.Seq}
+			}
+		}
+
+		tampered := func(reason string, args ...interface{}) error {
+			return &AuditTamperError{File: file, Line: lineNumber, Seq: record.Seq, Reason: fmt.Sprintf(reason, args...)}
+		}
+		switch {
+		case record.Seq > seq+1:
+			return tampered("records %d to %d missing", seq+1, record.Seq-1)
+		case record.Seq != seq+1:
+			return tampered("expected seq %d", seq+1)
+		case record.Prev != last:
+			return tampered("hash chain broken, this or the previous record was altered")
+		}
+
+		if record.Checkpoint != nil {
+			verification.Checkpoints++
+			if key != nil {
+				expected := signAuditCheckpoint(key, record.Seq, record.Prev)
+				if !hmac.Equal([]byte(expected), []byte(record.Checkpoint.Signature)) {
+					return tampered("invalid checkpoint signature")
+				}
+				verification.LastCheckpoint = record.Seq
+			}
+		}
+		if record.Pruning != nil {
+			if key != nil {
+				expected := signAuditPruning(key, record.Seq, record.Prev, record.Pruning.Through)
+				if !hmac.Equal([]byte(expected), []byte(record.Pruning.Signature)) {
+					return tampered("invalid pruning signature")
+				}
+			}
+			verification.PrunedThrough = record.Pruning.Through
+		}
+		verification.Records++
+		seq = record.Seq
+		last = hashAuditLine(line)
+		return nil
+	})
+	if err == nil && first != nil && verification.PrunedThrough != first.Seq-1 {
+		first.Reason = fmt.Sprintf("records %d to %d missing", verification.PrunedThrough+1, first.Seq-1)
+		if verification.PrunedThrough >= first.Seq {
+			first.Reason = fmt.Sprintf("records %d to %d deleted by retention still present", first.Seq, verification.PrunedThrough)
+		}
+		err = first
+	}
+	return verification, err
+}
+
+// Reads secret signing audit checkpoints, at least 32 bytes
+func LoadAuditKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < 32 {
+		return nil, fmt.Errorf("audit key %s: must be at least 32 bytes", path)
+	}
+	return key, nil
+}
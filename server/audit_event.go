@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Outcomes of audited requests
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	// Rejected by authentication or authorization
+	AuditDenied = "denied"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// What happened in one request, written to the audit log once the response is complete
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	Book string    `json:"book"`
+	// Caller of the operation, when authentication is enabled
+	Actor     *Identity `json:"actor,omitempty"`
+	ClientIp  string    `json:"clientIp,omitempty"`
+	RequestId string    `json:"requestId"`
+	Status    int       `json:"status"`
+	Outcome   string    `json:"outcome"`
+	// Contact the operation concerned, if a single one
+	ContactId int         `json:"contactId,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	// Fields changed by updates, without their values
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// Kinds of FieldChange
+const (
+	FieldAdded   = "added"
+	FieldRemoved = "removed"
+	FieldChanged = "changed"
+)
+
+type FieldChange struct {
+	Field  string `json:"field"`
+	Change string `json:"change"`
+}
+
+// Fields of after differing from before. Values are left out, as audit logs must not hold personal
+// data
+func diffContacts(before Contact, after Contact) []FieldChange {
+	var changes []FieldChange
+	diff := func(field string, old string, new string) {
+		switch {
+		case old == new:
+		case old == "":
+			changes = append(changes, FieldChange{Field: field, Change: FieldAdded})
+		case new == "":
+			changes = append(changes, FieldChange{Field: field, Change: FieldRemoved})
+		default:
+			changes = append(changes, FieldChange{Field: field, Change: FieldChanged})
+		}
+	}
+	diff("name", before.Name, after.Name)
+	diff("lastName", before.LastName, after.LastName)
+	diff("email", before.Email, after.Email)
+	for i := 0; i < len(before.Phones) || i < len(after.Phones); i++ {
+		var old, new string
+		if i < len(before.Phones) {
+			old = before.Phones[i]
+		}
+		if i < len(after.Phones) {
+			new = after.Phones[i]
+		}
+		diff("phones/"+strconv.Itoa(i), old, new)
+	}
+	diff("address", before.Address, after.Address)
+	diff("notes", before.Notes, after.Notes)
+	return changes
+}
+
+func auditEventOf(req *http.Request) *AuditEvent {
+	event, _ := req.Context().Value(auditEventKey).(*AuditEvent)
+	return event
+}
+
+// Records operation and data of the request, the event is written when the request completes
+func (r *RestServer) auditLog(req *http.Request, op string, data interface{}) {
+	if event := auditEventOf(req); event != nil {
+		event.Op = op
+		event.Data = data
+	}
+}
+
+// Records the contact the request concerned, when the path does not identify it
+func auditContact(req *http.Request, id int) {
+	if event := auditEventOf(req); event != nil {
+		event.ContactId = id
+	}
+}
+
+// Records fields the request changed
+func auditChanges(req *http.Request, before Contact, after Contact) {
+	if event := auditEventOf(req); event != nil {
+		event.Changes = diffContacts(before, after)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Keeps streaming exports streaming
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Request id of the caller, when it is a sane one, or a new random one
+func requestId(req *http.Request) string {
+	if id := req.Header.Get(RequestIdHeader); id != "" && len(id) <= 128 {
+		sane := true
+		for _, c := range id {
+			sane = sane && c > ' ' && c < 0x7f
+		}
+		if sane {
+			return id
+		}
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Writes an audit event of every request after it completed, with its outcome. It runs before
+// authentication, which records the actor, so requests rejected for missing or invalid credentials
+// are audited too. Requests performing no operation, like fetching the docs, are only audited when
+// failing
+func (r *RestServer) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		event := &AuditEvent{Time: time.Now().UTC(), RequestId: requestId(req)}
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			event.ClientIp = host
+		}
+		w.Header().Set(RequestIdHeader, event.RequestId)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), auditEventKey, event)))
+
+		event.Status = recorder.status
+		if event.Status == 0 {
+			event.Status = http.StatusOK
+		}
+		switch {
+		case event.Status == http.StatusUnauthorized || event.Status == http.StatusForbidden:
+			event.Outcome = AuditDenied
+		case event.Status >= 400:
+			event.Outcome = AuditFailure
+		default:
+			event.Outcome = AuditSuccess
+		}
+
+		if event.Op == "" {
+			if event.Outcome == AuditSuccess {
+				return
+			}
+			event.Op = req.Method
+			if route := mux.CurrentRoute(req); route != nil {
+				template, _ := route.GetPathTemplate()
+				event.Op += " " + template
+			}
+		}
+		if event.Book = mux.Vars(req)["book"]; event.Book == "" {
+			event.Book = DefaultBook
+		}
+		// Written synchronously, as the audit log must hold every event. Rotation only renames the
+		// file here, compressing is done in the background
+		if err := r.audit.Append(event); err != nil {
+			log.Printf("Audit log: %s", err)
+		}
+		if r.auditSinks != nil {
+			r.auditSinks.Send(event)
+		}
+	})
+}
+
+const (
+	// Audit events returned by /admin/audit, unless limited otherwise
+	DefaultAuditQueryLimit = 100
+	// Most audit events returned at once, as they are held in memory
+	MaxAuditQueryLimit = 1000
+)
+
+// Selects audit events, zero values match any
+type AuditFilter struct {
+	From time.Time
+	To   time.Time
+	Op   string
+	// Subject of the actor, or "<method>:<subject>"
+	Actor     string
+	ContactId int
+	// Most recent matching events returned, DefaultAuditQueryLimit when zero and at most
+	// MaxAuditQueryLimit
+	Limit int
+}
+
+func (f AuditFilter) matches(event *AuditEvent) bool {
+	switch {
+	case !f.From.IsZero() && event.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !event.Time.Before(f.To):
+		return false
+	case f.Op != "" && event.Op != f.Op:
+		return false
+	case f.ContactId != 0 && event.ContactId != f.ContactId:
+		return false
+	case f.Actor != "" && (event.Actor == nil || (event.Actor.Subject != f.Actor && event.Actor.Key() != f.Actor)):
+		return false
+	}
+	return true
+}
+
+// Events of the audit file and its rotated segments matching filter, oldest first. Unreadable
+// records are skipped, VerifyAuditLog reports them
+func ReadAuditEvents(path string, filter AuditFilter) ([]AuditEvent, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuditQueryLimit
+	} else if limit > MaxAuditQueryLimit {
+		limit = MaxAuditQueryLimit
+	}
+
+	// Ring of the most recent matches, next is the oldest once it is full
+	ring := make([]AuditEvent, 0, limit)
+	next := 0
+	err := readAuditLog(path, filter.From, func(file string, line []byte) error {
+		var record struct {
+			Event *AuditEvent `json:"event"`
+		}
+		if err := json.Unmarshal(line, &record); err != nil || record.Event == nil {
+			return nil
+		}
+		if !filter.matches(record.Event) {
+			return nil
+		}
+		if len(ring) < limit {
+			ring = append(ring, *record.Event)
+		} else {
+			ring[next] = *record.Event
+			next = (next + 1) % limit
+		}
+		return nil
+	})
+	return append(ring[next:], ring[:next]...), err
+}
+
+// Events written to the audit log matching filter, oldest first
+func (a *AuditLog) Events(filter AuditFilter) ([]AuditEvent, error) {
+	return ReadAuditEvents(a.path, filter)
+}
+
+func auditFilterParams(req *http.Request) (AuditFilter, error) {
+	query := req.URL.Query()
+	filter := AuditFilter{Op: query.Get("op"), Actor: query.Get("actor"), Limit: DefaultAuditQueryLimit}
+
+	for param, value := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if str := query.Get(param); str != "" {
+			var err error
+			if *value, err = time.Parse(time.RFC3339, str); err != nil {
+				return filter, BadRequest("invalid %s %q, expected RFC 3339 time", param, str)
+			}
+		}
+	}
+	for param, value := range map[string]*int{"contactId": &filter.ContactId, "limit": &filter.Limit} {
+		if str := query.Get(param); str != "" {
+			var err error
+			if *value, err = strconv.Atoi(str); err != nil || *value < 0 {
+				return filter, BadRequest("invalid %s %q", param, str)
+			}
+		}
+	}
+	if filter.Limit < 1 || filter.Limit > MaxAuditQueryLimit {
+		return filter, BadRequest("limit must be between 1 and %d", MaxAuditQueryLimit)
+	}
+	return filter, nil
+}
+
+func (r *RestServer) queryAudit(w http.ResponseWriter, req *http.Request) error {
+	filter, err := auditFilterParams(req)
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "queryAudit", nil)
+
+	events, err := r.audit.Events(filter)
+	if err != nil {
+		return err
+	}
+	return writeJson(events, w)
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Events buffered per sink of AuditFanOut, unless set otherwise
+const DefaultAuditBufferSize = 1024
+
+// Destination of audit events besides the audit log, which is not a sink: it is written before the
+// response completes and never drops events, while sinks are fed from buffers
+type AuditSink interface {
+	Send(event *AuditEvent) error
+	Close() error
+}
+
+// Writes events as JSON lines, e.g. to stdout for collection by the container runtime
+type JsonSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewJsonSink(writer io.Writer) *JsonSink {
+	return &JsonSink{writer: writer}
+}
+
+func NewStdoutSink() *JsonSink {
+	return NewJsonSink(os.Stdout)
+}
+
+func (s *JsonSink) Send(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (s *JsonSink) Close() error {
+	return nil
+}
+
+// Appends events as JSON lines to a plain file, e.g. one shipped by a log collector. Unlike the
+// audit log it is neither hash-chained nor rotated
+type FileSink struct {
+	*JsonSink
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{JsonSink: NewJsonSink(file), file: file}, nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Syslog priority of audit events, facility authpriv and severity info
+const syslogPriority = 10*8 + 6
+
+// Sends events as RFC 5424 syslog messages to a collector over UDP or TCP, reconnecting after
+// failures. TCP messages are framed by octet counting as of RFC 6587
+type SyslogSink struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+func NewSyslogSink(network string, address string) (*SyslogSink, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("syslog network must be udp or tcp, not %q", network)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	sink := &SyslogSink{network: network, address: address, hostname: hostname}
+	if err := sink.connect(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *SyslogSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *SyslogSink) Send(event *AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("<%d>1 %s %s contacts - audit - %s", syslogPriority, event.Time.Format(time.RFC3339Nano), s.hostname, data)
+	if s.network == "tcp" {
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Collectors restart, so failed connections are dialed again once
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				return err
+			}
+		}
+		if _, err = io.WriteString(s.conn, message); err == nil || attempt > 0 {
+			return err
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Posts every event as JSON to a URL, responses other than 2xx are errors
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// Posts with client, or a client timing out after 10s when nil
+func NewWebhookSink(url string, client *http.Client) *WebhookSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookSink{url: url, client: client}
+}
+
+func (s *WebhookSink) Send(event *AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+type bufferedSink struct {
+	sink    AuditSink
+	events  chan *AuditEvent
+	dropped uint64
+	done    chan struct{}
+}
+
+func (b *bufferedSink) run() {
+	defer close(b.done)
+	for event := range b.events {
+		if err := b.sink.Send(event); err != nil {
+			log.Printf("Audit sink %T: %s", b.sink, err)
+		}
+	}
+}
+
+// Delivers events to several sinks, each from its own buffer by its own goroutine. A sink falling
+// behind only fills its buffer; once full, its events are dropped and counted rather than blocking
+// the requests writing them
+type AuditFanOut struct {
+	sinks []*bufferedSink
+	once  sync.Once
+}
+
+func NewAuditFanOut(bufferSize int, sinks ...AuditSink) *AuditFanOut {
+	fanOut := &AuditFanOut{}
+	for _, sink := range sinks {
+		buffered := &bufferedSink{sink: sink, events: make(chan *AuditEvent, bufferSize), done: make(chan struct{})}
+		fanOut.sinks = append(fanOut.sinks, buffered)
+		go buffered.run()
+	}
+	return fanOut
+}
+
+// Queues event for every sink, never blocking
+func (f *AuditFanOut) Send(event *AuditEvent) error {
+	for _, sink := range f.sinks {
+		select {
+		case sink.events <- event:
+		default:
+			if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+				// Logged at powers of two, so a stuck sink does not flood the log
+				log.Printf("Audit sink %T falling behind, %d events dropped", sink.sink, dropped)
+			}
+		}
+	}
+	return nil
+}
+
+// Events dropped by each sink, in order of the sinks
+func (f *AuditFanOut) Dropped() []uint64 {
+	dropped := make([]uint64, len(f.sinks))
+	for i, sink := range f.sinks {
+		dropped[i] = atomic.LoadUint64(&sink.dropped)
+	}
+	return dropped
+}
+
+// Delivers buffered events and closes the sinks. Events must not be sent afterwards
+func (f *AuditFanOut) Close() error {
+	var firstErr error
+	f.once.Do(func() {
+		for _, sink := range f.sinks {
+			close(sink.events)
+		}
+		for _, sink := range f.sinks {
+			<-sink.done
+			if err := sink.sink.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	})
+	return firstErr
+}
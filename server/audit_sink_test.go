@@ -0,0 +1,201 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Records events, blocking until released when it has a gate
+type recordingSink struct {
+	mu     sync.Mutex
+	events []server.AuditEvent
+	gate   chan struct{}
+}
+
+func (s *recordingSink) Send(event *server.AuditEvent) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) ops() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ops []string
+	for _, event := range s.events {
+		ops = append(ops, event.Op)
+	}
+	return ops
+}
+
+func TestAuditFanOut(t *testing.T) {
+	fast := &recordingSink{}
+	slow := &recordingSink{gate: make(chan struct{})}
+	fanOut := server.NewAuditFanOut(2, fast, slow)
+
+	done := make(chan struct{})
+	go func() {
+		for _, op := range []string{"a", "b", "c", "d", "e"} {
+			fanOut.Send(&server.AuditEvent{Op: op})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow sink blocked sending events")
+	}
+
+	// The slow sink holds at most one event and buffers two more, the rest is dropped. Events are
+	// delivered in order, or dropped
+	close(slow.gate)
+	require.NoError(t, fanOut.Close())
+	dropped := fanOut.Dropped()
+	assert.GreaterOrEqual(t, dropped[1], uint64(2))
+	for i, sink := range []*recordingSink{fast, slow} {
+		ops := sink.ops()
+		assert.Equal(t, 5, len(ops)+int(dropped[i]))
+		assert.IsIncreasing(t, ops)
+	}
+}
+
+func TestJsonSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := server.NewJsonSink(&out)
+	require.NoError(t, sink.Send(&server.AuditEvent{Op: "create", Status: 201}))
+	var event server.AuditEvent
+	require.NoError(t, json.Unmarshal(out.Bytes(), &event))
+	assert.Equal(t, "create", event.Op)
+	assert.True(t, strings.HasSuffix(out.String(), "}\n"))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"op\":\"earlier\"}\n"), 0600))
+
+	sink, err := server.NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(&server.AuditEvent{Op: "create", Status: 201}))
+	require.NoError(t, sink.Send(&server.AuditEvent{Op: "delete", Status: 204}))
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 3)
+	var event server.AuditEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &event))
+	assert.Equal(t, "delete", event.Op)
+	assert.Error(t, sink.Send(&server.AuditEvent{Op: "update"}))
+}
+
+func TestSyslogSink(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+	sink, err := server.NewSyslogSink("udp", udp.LocalAddr().String())
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(&server.AuditEvent{Time: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), Op: "create"}))
+	require.NoError(t, sink.Close())
+
+	buffer := make([]byte, 4096)
+	require.NoError(t, udp.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := udp.ReadFrom(buffer)
+	require.NoError(t, err)
+	message := string(buffer[:n])
+	assert.True(t, strings.HasPrefix(message, "<86>1 2024-01-31T12:00:00Z "), message)
+	assert.Contains(t, message, ` contacts - audit - {"time":"2024-01-31T12:00:00Z","op":"create"`)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	sink, err = server.NewSyslogSink("tcp", tcp.Addr().String())
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Send(&server.AuditEvent{Op: "create"}))
+
+	conn, err := tcp.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	// Octet counting: length of the message, a space and the message
+	length, err := bufio.NewReader(conn).ReadString(' ')
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9]+ $`, length)
+
+	_, err = server.NewSyslogSink("unix", "/dev/log")
+	assert.Error(t, err)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []server.AuditEvent
+	status := http.StatusNoContent
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event server.AuditEvent
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+
+	sink := server.NewWebhookSink(webhook.URL, webhook.Client())
+	require.NoError(t, sink.Send(&server.AuditEvent{Op: "deleteById", ContactId: 3}))
+	require.Len(t, received, 1)
+	assert.Equal(t, 3, received[0].ContactId)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, sink.Send(&server.AuditEvent{Op: "create"}))
+}
+
+type failingSink struct{}
+
+func (failingSink) Send(event *server.AuditEvent) error {
+	return errors.New("collector down")
+}
+
+func (failingSink) Close() error {
+	return nil
+}
+
+func TestRestAuditSinks(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	sink := &recordingSink{}
+	testServer := httptest.NewServer(restServer.WithAuditSinks(10, sink, failingSink{}).Handler())
+
+	resp, err := http.Post(testServer.URL+"/v1/contacts", "application/json",
+		strings.NewReader(`{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	testServer.Close()
+
+	// Closing delivers events still buffered
+	require.NoError(t, restServer.Close())
+	assert.Equal(t, []string{"create"}, sink.ops())
+}
@@ -0,0 +1,304 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Events of the audit log, in order
+func readAuditEvents(t *testing.T, path string) []server.AuditEvent {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var events []server.AuditEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var record struct {
+			Event *server.AuditEvent `json:"event"`
+		}
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record.Event != nil {
+			events = append(events, *record.Event)
+		}
+	}
+	return events
+}
+
+var auditKey = []byte("fedcba9876543210fedcba9876543210")
+
+func writeAuditLog(t *testing.T, path string, events int) {
+	audit, err := server.OpenAuditLog(path)
+	require.NoError(t, err)
+	audit.WithCheckpoints(auditKey, 3)
+	for i := 0; i < events; i++ {
+		require.NoError(t, audit.Append(map[string]int{"event": i}))
+	}
+	require.NoError(t, audit.Close())
+}
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 4)
+	// Reopening continues the chain
+	writeAuditLog(t, path, 3)
+
+	verification, err := server.VerifyAuditLog(path, auditKey)
+	require.NoError(t, err)
+	// Checkpoints follow the 3rd, 6th and 9th record
+	assert.Equal(t, server.AuditVerification{FirstSeq: 1, Records: 10, Checkpoints: 3, LastCheckpoint: 10}, verification)
+	assert.True(t, verification.Signed())
+
+	// Without key the chain is consistent, but nothing proves it was not rewritten
+	verification, err = server.VerifyAuditLog(path, nil)
+	require.NoError(t, err)
+	assert.False(t, verification.Signed())
+
+	_, err = server.VerifyAuditLog(path, []byte("other key of the audit log 012345"))
+	assert.EqualError(t, err, "audit.log tampered at line 4 (seq 4): invalid checkpoint signature")
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 6)
+	original, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(original, []byte("\n"))
+
+	tests := []struct {
+		name   string
+		tamper func() []byte
+		err    string
+	}{
+		{"edited", func() []byte {
+			return bytes.Replace(original, []byte(`{"event":1}`), []byte(`{"event":7}`), 1)
+		}, "audit.log tampered at line 3 (seq 3): hash chain broken, this or the previous record was altered"},
+		{"deleted", func() []byte {
+			return bytes.Join(append(append([][]byte{}, lines[:1]...), lines[2:]...), nil)
+		}, "audit.log tampered at line 2 (seq 3): records 2 to 2 missing"},
+		{"reordered", func() []byte {
+			return bytes.Join([][]byte{lines[1], lines[0]}, nil)
+		}, "audit.log tampered at line 1 (seq 2): records 1 to 1 missing"},
+		{"truncated", func() []byte {
+			return original[:len(original)-10]
+		}, "audit.log tampered at line 8 (seq 8): malformed record"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "audit.log")
+			require.NoError(t, os.WriteFile(tampered, test.tamper(), 0600))
+			_, err := server.VerifyAuditLog(tampered, auditKey)
+			assert.True(t, errors.Is(err, server.ErrAuditTampered))
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestRestAuditEvents(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, auditPath)
+	require.NoError(t, err)
+	policy := server.DefaultPolicy()
+	policy.Subjects["test:ed"] = []string{server.RoleEditor}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(subject string, method string, path string, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", subject)
+		if method == "PATCH" {
+			req.Header.Set("Content-Type", server.MergePatchContentType)
+		}
+		req.Header.Set(server.RequestIdHeader, "req-"+method)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("ed", "PATCH", "/v1/contacts/1", `{"email":"john@lennon.com","notes":"Imagine","address":null}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "req-PATCH", resp.Header.Get(server.RequestIdHeader))
+	do("ed", "DELETE", "/v1/contacts/99", "")
+	do("intern", "POST", "/v1/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`)
+	do("ed", "POST", "/v1/contacts", `{"name":"Pete"}`)
+	do("ed", "GET", "/openapi.json", "")
+	do("", "DELETE", "/v1/contacts/2", "")
+	do("ed", "DELETE", "/admin/keys/1234", "")
+
+	events := readAuditEvents(t, auditPath)
+	require.Len(t, events, 6)
+	patch := events[0]
+	assert.Equal(t, "patchById", patch.Op)
+	assert.Equal(t, "req-PATCH", patch.RequestId)
+	assert.Equal(t, "127.0.0.1", patch.ClientIp)
+	assert.Equal(t, 1, patch.ContactId)
+	assert.Equal(t, server.AuditSuccess, patch.Outcome)
+	assert.Equal(t, []server.FieldChange{
+		{Field: "email", Change: server.FieldChanged},
+		{Field: "notes", Change: server.FieldAdded},
+	}, patch.Changes)
+
+	// Written after the operation, so missing contacts are failures
+	assert.Equal(t, "deleteById", events[1].Op)
+	assert.Equal(t, http.StatusNotFound, events[1].Status)
+	assert.Equal(t, server.AuditFailure, events[1].Outcome)
+
+	assert.Equal(t, "create", events[2].Op)
+	assert.Equal(t, server.AuditDenied, events[2].Outcome)
+	assert.Equal(t, "intern", events[2].Actor.Subject)
+
+	// Requests rejected before performing their operation are named by route
+	assert.Equal(t, "POST /v1/contacts", events[3].Op)
+	assert.Equal(t, http.StatusUnprocessableEntity, events[3].Status)
+
+	// Requests without credentials are audited before authentication rejects them
+	assert.Equal(t, "DELETE /v1/contacts/{id}", events[4].Op)
+	assert.Equal(t, http.StatusUnauthorized, events[4].Status)
+	assert.Equal(t, server.AuditDenied, events[4].Outcome)
+	assert.Nil(t, events[4].Actor)
+
+	// Ids of routes other than those of contacts, like API keys, are not contact ids
+	assert.Equal(t, "admin", events[5].Op)
+	assert.Equal(t, server.AuditDenied, events[5].Outcome)
+	assert.Zero(t, events[5].ContactId)
+
+	raw, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "lennon.com")
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	audit, err := server.OpenAuditLog(path)
+	require.NoError(t, err)
+	audit.WithCheckpoints(auditKey, 3).WithRotation(server.AuditRotation{MaxSize: 200, MaxSegments: 3})
+	for i := 0; i < 20; i++ {
+		require.NoError(t, audit.Append(server.AuditEvent{Op: "findById", ContactId: i + 1}))
+	}
+	require.NoError(t, audit.Close())
+
+	segments, err := filepath.Glob(path + ".*.gz")
+	require.NoError(t, err)
+	assert.Len(t, segments, 3)
+
+	// The chain continues across segments and from the oldest one retained, as attested by retention
+	verification, err := server.VerifyAuditLog(path, auditKey)
+	require.NoError(t, err)
+	assert.Greater(t, verification.FirstSeq, uint64(1))
+	assert.Equal(t, verification.FirstSeq-1, verification.PrunedThrough)
+
+	events, err := server.ReadAuditEvents(path, server.AuditFilter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, 20, events[len(events)-1].ContactId)
+	// Older segments were deleted by retention
+	assert.Greater(t, events[0].ContactId, 1)
+
+	events, err = server.ReadAuditEvents(path, server.AuditFilter{ContactId: 18})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	events, err = server.ReadAuditEvents(path, server.AuditFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, []int{19, 20}, []int{events[0].ContactId, events[1].ContactId})
+
+	// Segments rotated before the queried time are not read at all, so not even corrupt ones fail
+	oldest, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(segments[0], []byte("corrupt"), 0600))
+	_, err = server.ReadAuditEvents(path, server.AuditFilter{})
+	assert.Error(t, err)
+	events, err = server.ReadAuditEvents(path, server.AuditFilter{From: time.Now()})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	require.NoError(t, os.WriteFile(segments[0], oldest, 0600))
+
+	// Reopening continues after the last segment
+	audit, err = server.OpenAuditLog(path)
+	require.NoError(t, err)
+	require.NoError(t, audit.Append(server.AuditEvent{Op: "findAll"}))
+	require.NoError(t, audit.Close())
+	_, err = server.VerifyAuditLog(path, auditKey)
+	assert.NoError(t, err)
+
+	// Segments deleted other than by retention are missing records
+	require.NoError(t, os.Remove(segments[0]))
+	_, err = server.VerifyAuditLog(path, auditKey)
+	assert.True(t, errors.Is(err, server.ErrAuditTampered))
+	assert.Contains(t, err.Error(), fmt.Sprintf("records %d to ", verification.FirstSeq))
+}
+
+func TestAuditLogUncompressedSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 4)
+	// Rotated, but not compressed yet
+	require.NoError(t, os.Rename(path, path+".20240131T120000.000000000Z"))
+	writeAuditLog(t, path, 2)
+
+	_, err := server.VerifyAuditLog(path, auditKey)
+	require.NoError(t, err)
+	events, err := server.ReadAuditEvents(path, server.AuditFilter{})
+	require.NoError(t, err)
+	assert.Len(t, events, 6)
+}
+
+func TestRestAuditQuery(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	policy := server.DefaultPolicy()
+	policy.Subjects["test:ann"] = []string{server.RoleAdmin}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(subject string, path string) *http.Response {
+		req, err := http.NewRequest("GET", testServer.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", subject)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	start := time.Now().UTC()
+	do("ann", "/v1/contacts/1")
+	do("bob", "/v1/contacts/2")
+	do("bob", "/v1/contacts")
+
+	query := func(params string) []server.AuditEvent {
+		resp := do("ann", "/admin/audit?"+params)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var events []server.AuditEvent
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		return events
+	}
+	assert.Len(t, query("actor=bob"), 2)
+	assert.Len(t, query("actor=test:ann&op=findById"), 1)
+	assert.Len(t, query("contactId=2"), 1)
+	assert.Len(t, query("from="+start.Add(-time.Minute).Format(time.RFC3339)+"&to="+start.Add(time.Minute).Format(time.RFC3339)), 6)
+	assert.Empty(t, query("to="+start.Add(-time.Minute).Format(time.RFC3339)))
+	events := query("limit=1")
+	require.Len(t, events, 1)
+	assert.Equal(t, "queryAudit", events[0].Op)
+
+	assert.Equal(t, http.StatusBadRequest, do("ann", "/admin/audit?from=yesterday").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do("ann", "/admin/audit?limit=0").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do("ann", "/admin/audit?limit=1001").StatusCode)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// Caller of a request, as established by an authenticator
+type Identity struct {
+	// Unique within the authentication method, e.g. id of an API key
+	Subject string `json:"subject"`
+	// Human readable name, e.g. label of an API key
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method"`
+	// Roles the credentials carry. Those of API keys were granted by the server and always apply,
+	// those claimed by tokens only when the policy has TokenRoles set
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Establishes identity of the caller from request credentials. Returns nil identity and nil error
+// when the request carries no credentials of its kind, so another authenticator may be tried
+type Authenticator interface {
+	Authenticate(req *http.Request) (*Identity, error)
+	// WWW-Authenticate challenge sent with 401 responses
+	Challenge() string
+}
+
+// Identifies the caller across authentication methods, e.g. "jwt:jane"
+func (i *Identity) Key() string {
+	return i.Method + ":" + i.Subject
+}
+
+// Identity of the caller, nil when authentication is disabled
+func IdentityOf(req *http.Request) *Identity {
+	identity, _ := req.Context().Value(identityKey).(*Identity)
+	return identity
+}
+
+// Routes anyone may access, even with authentication enabled
+var publicPaths = map[string]bool{
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// Rejects requests no authenticator accepts with 401
+func authenticated(authenticators []Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if publicPaths[req.URL.Path] {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		for _, authenticator := range authenticators {
+			identity, err := authenticator.Authenticate(req)
+			if err != nil {
+				writeUnauthorized(w, req, authenticators, Unauthorized("%s", err))
+				return
+			}
+			if identity != nil {
+				if event := auditEventOf(req); event != nil {
+					event.Actor = identity
+				}
+				next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), identityKey, identity)))
+				return
+			}
+		}
+		writeUnauthorized(w, req, authenticators, Unauthorized("missing credentials"))
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter, req *http.Request, authenticators []Authenticator, problem *Problem) {
+	for _, authenticator := range authenticators {
+		w.Header().Add("WWW-Authenticate", authenticator.Challenge())
+	}
+	writeProblem(w, req, problem)
+}
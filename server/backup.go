@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	BackupFormat        = "contacts-backup"
+	BackupSchemaVersion = 1
+)
+
+type Backup struct {
+	Format        string            `json:"format"`
+	SchemaVersion int               `json:"schemaVersion"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+
+	// Highest id ever assigned, so that restored database does not reuse ids of deleted contacts
+	HighestId int `json:"highestId"`
+	Count     int `json:"count"`
+	// Hex encoded SHA-256 of the JSON encoded contacts
+	Checksum string    `json:"checksum"`
+	Contacts []Contact `json:"contacts"`
+}
+
+func contactsChecksum(contacts []Contact) (string, error) {
+	data, err := json.Marshal(contacts)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Creates a backup of a consistent snapshot of the database, with contacts ordered by id
+func NewBackup(db ContactDatabase, metadata map[string]string) (*Backup, error) {
+	contacts, highestId := db.Snapshot()
+	if contacts == nil {
+		contacts = []Contact{}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Id < contacts[j].Id
+	})
+
+	checksum, err := contactsChecksum(contacts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backup{
+		Format:        BackupFormat,
+		SchemaVersion: BackupSchemaVersion,
+		CreatedAt:     time.Now().UTC(),
+		Metadata:      metadata,
+		HighestId:     highestId,
+		Count:         len(contacts),
+		Checksum:      checksum,
+		Contacts:      contacts,
+	}, nil
+}
+
+// Checks integrity of the backup - format, checksum and validity of every contact
+func (b *Backup) Verify() error {
+	if b.Format != BackupFormat {
+		return fmt.Errorf("unknown backup format %q", b.Format)
+	}
+	if b.SchemaVersion != BackupSchemaVersion {
+		return fmt.Errorf("unsupported backup schema version %d", b.SchemaVersion)
+	}
+	if b.Count != len(b.Contacts) {
+		return fmt.Errorf("backup declares %d contacts but contains %d", b.Count, len(b.Contacts))
+	}
+
+	contacts := b.Contacts
+	if contacts == nil {
+		contacts = []Contact{}
+	}
+	checksum, err := contactsChecksum(contacts)
+	if err != nil {
+		return err
+	}
+	if checksum != b.Checksum {
+		return errors.New("backup checksum mismatch")
+	}
+
+	ids := make(map[int]bool)
+	for _, contact := range b.Contacts {
+		if err := contact.Validate(); err != nil {
+			return fmt.Errorf("contact %d: %w", contact.Id, err)
+		}
+		if contact.Id <= 0 || ids[contact.Id] {
+			return fmt.Errorf("invalid or duplicate contact id %d", contact.Id)
+		}
+		if contact.Id > b.HighestId {
+			return fmt.Errorf("contact id %d is higher than highest id %d", contact.Id, b.HighestId)
+		}
+		ids[contact.Id] = true
+	}
+
+	return nil
+}
+
+func WriteBackup(w io.Writer, backup *Backup) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backup)
+}
+
+// Reads and verifies a backup
+func ReadBackup(r io.Reader) (*Backup, error) {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, err
+	}
+	if err := backup.Verify(); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// Verifies the backup and replaces all data of the database with it
+func RestoreBackup(db ContactDatabase, backup *Backup) error {
+	if err := backup.Verify(); err != nil {
+		return err
+	}
+	db.Restore(backup.Contacts, backup.HighestId)
+	return nil
+}
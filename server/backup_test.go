@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	db.Delete(server.Contact{Id: 4})
+
+	backup, err := server.NewBackup(db, map[string]string{"host": "test"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, backup.Count)
+	assert.Equal(t, 4, backup.HighestId)
+
+	var buf bytes.Buffer
+	require.NoError(t, server.WriteBackup(&buf, backup))
+
+	read, err := server.ReadBackup(&buf)
+	require.NoError(t, err)
+
+	restored := server.NewMemoryDatabase()
+	restored.InsertWithNewId(server.Contact{Name: "Test", LastName: "test", Email: "test@test.com"})
+	require.NoError(t, server.RestoreBackup(restored, read))
+
+	assert.Equal(t, sortContactsById(db.FindAll()), sortContactsById(restored.FindAll()))
+	assert.Equal(t, 5, restored.InsertWithNewId(server.Contact{Name: "Test", LastName: "test", Email: "test@test.com"}).Id)
+}
+
+func TestBackupTampered(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+
+	backup, err := server.NewBackup(db, nil)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, server.WriteBackup(&buf, backup))
+
+	tampered := strings.Replace(buf.String(), "Lennon", "Lenin", 1)
+	_, err = server.ReadBackup(strings.NewReader(tampered))
+	assert.EqualError(t, err, "backup checksum mismatch")
+
+	backup.Count = 3
+	assert.Error(t, backup.Verify())
+
+	backup.Count = 4
+	backup.HighestId = 2
+	assert.Error(t, backup.Verify())
+
+	backup.HighestId = 4
+	backup.SchemaVersion = 99
+	assert.Error(t, backup.Verify())
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Book served by the unscoped routes, e.g. /v1/contacts - it always exists
+const DefaultBook = "default"
+
+var (
+	ErrBookExists  = errors.New("book already exists")
+	ErrDefaultBook = errors.New("default book cannot be deleted")
+
+	bookNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+type Book struct {
+	Name string `json:"name"`
+	// Identity key of the owner of a personal book, empty for team books
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Count     int       `json:"count"`
+}
+
+type bookEntry struct {
+	db        ContactDatabase
+	owner     string
+	createdAt time.Time
+
+	// Access of subjects to the whole book and to single contacts by id
+	grants        map[string]Access
+	contactGrants map[int]map[string]Access
+}
+
+func (e *bookEntry) book(name string) Book {
+	return Book{Name: name, Owner: e.owner, CreatedAt: e.createdAt, Count: e.db.Count()}
+}
+
+// Independent address books, each with its own database and id space
+type Books struct {
+	mu          sync.RWMutex
+	books       map[string]*bookEntry
+	newDatabase func(name string) (ContactDatabase, error)
+}
+
+// Registry holding defaultDb as the default book. New books get databases of newDatabase
+func NewBooks(defaultDb ContactDatabase, newDatabase func(name string) (ContactDatabase, error)) *Books {
+	return &Books{
+		books:       map[string]*bookEntry{DefaultBook: newBookEntry(defaultDb, "")},
+		newDatabase: newDatabase,
+	}
+}
+
+func ValidateBookName(name string) error {
+	if !bookNamePattern.MatchString(name) {
+		return &ValidationError{Field: "name", Message: "book name must be 1-63 lowercase letters, digits or dashes, not starting with a dash"}
+	}
+	return nil
+}
+
+func newBookEntry(db ContactDatabase, owner string) *bookEntry {
+	return &bookEntry{
+		db:            db,
+		owner:         owner,
+		createdAt:     time.Now().UTC(),
+		grants:        make(map[string]Access),
+		contactGrants: make(map[int]map[string]Access),
+	}
+}
+
+// Adds empty team book
+func (b *Books) Create(name string) (Book, error) {
+	return b.create(name, "")
+}
+
+// Adds empty book only the owner and those it is shared with may access
+func (b *Books) CreatePersonal(name string, owner string) (Book, error) {
+	return b.create(name, owner)
+}
+
+func (b *Books) create(name string, owner string) (Book, error) {
+	if err := ValidateBookName(name); err != nil {
+		return Book{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.books[name]; ok {
+		return Book{}, ErrBookExists
+	}
+	db, err := b.newDatabase(name)
+	if err != nil {
+		return Book{}, err
+	}
+	entry := newBookEntry(db, owner)
+	b.books[name] = entry
+	return entry.book(name), nil
+}
+
+// Removes book with all its contacts, returning false when there is no book of the name
+func (b *Books) Delete(name string) (bool, error) {
+	if name == DefaultBook {
+		return false, ErrDefaultBook
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.books[name]; !ok {
+		return false, nil
+	}
+	delete(b.books, name)
+	return true, nil
+}
+
+// Database of the book, or nil when there is no book of the name
+func (b *Books) Database(name string) ContactDatabase {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if entry, ok := b.books[name]; ok {
+		return entry.db
+	}
+	return nil
+}
+
+// All books ordered by name
+func (b *Books) List() []Book {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	books := []Book{}
+	for name, entry := range b.books {
+		books = append(books, entry.book(name))
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Name < books[j].Name
+	})
+	return books
+}
+
+type requestBook struct {
+	name string
+	db   ContactDatabase
+}
+
+// Serves routes below /books/{book} from the database of the book, 404 when there is no such book
+func (r *RestServer) withBook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["book"]
+		db := r.books.Database(name)
+		if db == nil {
+			writeProblem(w, req, NotFound("book %s not found", name))
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), bookKey, requestBook{name: name, db: db})))
+	})
+}
+
+// Name of the book the request was routed to
+func bookOf(req *http.Request) string {
+	if book, ok := req.Context().Value(bookKey).(requestBook); ok {
+		return book.name
+	}
+	return DefaultBook
+}
+
+// Database of the book the request was routed to
+func (r *RestServer) dbOf(req *http.Request) ContactDatabase {
+	if book, ok := req.Context().Value(bookKey).(requestBook); ok {
+		return book.db
+	}
+	return r.db
+}
+
+type createBookRequest struct {
+	Name string `json:"name"`
+	// Owned by the caller, instead of a team book managed by admins
+	Personal bool `json:"personal"`
+}
+
+func (r *RestServer) listBooks(w http.ResponseWriter, req *http.Request) error {
+	r.auditLog(req, "listBooks", nil)
+
+	// Callers only see books they may read
+	books := []Book{}
+	for _, book := range r.books.List() {
+		if r.allowed(req, book.Name, OpFindAll, 0) {
+			books = append(books, book)
+		}
+	}
+	return writeJson(books, w)
+}
+
+func (r *RestServer) createBook(w http.ResponseWriter, req *http.Request) error {
+	var createReq createBookRequest
+	if err := json.NewDecoder(req.Body).Decode(&createReq); err != nil {
+		return BadRequest("invalid request body: %s", err)
+	}
+
+	r.auditLog(req, "createBook", createReq)
+
+	var book Book
+	var err error
+	if createReq.Personal {
+		identity := IdentityOf(req)
+		if identity == nil {
+			return Invalid(&ValidationError{Field: "personal", Message: "personal books require authentication"})
+		}
+		book, err = r.books.CreatePersonal(createReq.Name, identity.Key())
+	} else {
+		if !r.allowed(req, DefaultBook, OpAdmin, 0) {
+			return Forbidden("operation %s is not permitted", OpAdmin)
+		}
+		book, err = r.books.Create(createReq.Name)
+	}
+	if errors.Is(err, ErrBookExists) {
+		return Conflict("book %s already exists", createReq.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", "/books/"+createReq.Name)
+	return writeResponse(w, req, http.StatusCreated, book, nil)
+}
+
+func (r *RestServer) deleteBook(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	r.auditLog(req, "deleteBook", name)
+
+	deleted, err := r.books.Delete(name)
+	if errors.Is(err, ErrDefaultBook) {
+		return Conflict("%s", err)
+	}
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return NotFound("book %s not found", name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Book of admin operations, selected with the book query parameter
+func (r *RestServer) adminDb(req *http.Request) (ContactDatabase, error) {
+	name := req.URL.Query().Get("book")
+	if name == "" {
+		return r.db, nil
+	}
+	db := r.books.Database(name)
+	if db == nil {
+		return nil, NotFound("book %s not found", name)
+	}
+	// Admins of the server only manage personal books they own or may administer otherwise
+	if !r.allowed(req, name, OpAdmin, 0) {
+		return nil, Forbidden("operation %s is not permitted", OpAdmin)
+	}
+	return db, nil
+}
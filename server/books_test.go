@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestBooks(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp := doRequest(t, "POST", testServer.URL+"/books", `{"name":"team-a"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/books/team-a", resp.Header.Get("Location"))
+
+	resp = doRequest(t, "POST", testServer.URL+"/books", `{"name":"team-a"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = doRequest(t, "POST", testServer.URL+"/books", `{"name":"Team A"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// Books have their own id spaces
+	resp = doRequest(t, "POST", testServer.URL+"/v1/books/team-a/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/v1/books/team-a/contacts/1", resp.Header.Get("Location"))
+
+	resp = doRequest(t, "GET", testServer.URL+"/v2/books/team-a/contacts/1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var contact server.ContactV2
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&contact))
+	assert.Equal(t, "Best", contact.Name.Family)
+
+	resp = doRequest(t, "GET", testServer.URL+"/v1/contacts/1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var fixture server.Contact
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&fixture))
+	assert.Equal(t, "Lennon", fixture.LastName)
+
+	resp = doRequest(t, "GET", testServer.URL+"/books/team-a/contacts", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+
+	resp = doRequest(t, "GET", testServer.URL+"/books", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var books []server.Book
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&books))
+	require.Len(t, books, 2)
+	assert.Equal(t, server.Book{Name: "team-a", CreatedAt: books[1].CreatedAt, Count: 1}, books[1])
+	assert.Equal(t, server.DefaultBook, books[0].Name)
+
+	resp = doRequest(t, "DELETE", testServer.URL+"/books/default", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = doRequest(t, "DELETE", testServer.URL+"/books/team-a", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = doRequest(t, "GET", testServer.URL+"/v1/books/team-a/contacts", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doRequest(t, "DELETE", testServer.URL+"/books/team-a", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRestBookPermissions(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	for _, book := range []string{"team-a", "team-b"} {
+		_, err := restServer.Books().Create(book)
+		require.NoError(t, err)
+	}
+
+	// Nobody may read books by default, members of team A may edit its book
+	policy := server.DefaultPolicy()
+	policy.DefaultRoles = nil
+	policy.Books = map[string]server.BookPolicy{
+		"team-a": {Subjects: map[string][]string{"test:ann": {server.RoleEditor}}},
+	}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(method string, path string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", "ann")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, do("GET", "/v1/books/team-a/contacts").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/v1/books/team-b/contacts").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/v1/contacts").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/books/team-a").StatusCode)
+}
@@ -1,6 +1,14 @@
 package server
 
-import "errors"
+// Failed validation of a single field
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
 
 type Contact struct {
 	Id int `json:"id" yaml:"id"`
@@ -8,6 +16,10 @@ type Contact struct {
 	Name     string `json:"name" yaml:"name"`
 	LastName string `json:"lastName" yaml:"lastName"`
 	Email    string `json:"email" yaml:"email"`
+
+	Phones  []string `json:"phones,omitempty" yaml:"phones,omitempty"`
+	Address string   `json:"address,omitempty" yaml:"address,omitempty"`
+	Notes   string   `json:"notes,omitempty" yaml:"notes,omitempty"`
 }
 
 func (c *Contact) Clone() *Contact {
@@ -17,30 +29,64 @@ func (c *Contact) Clone() *Contact {
 		Name:     c.Name,
 		LastName: c.LastName,
 		Email:    c.Email,
+
+		Phones:  append([]string(nil), c.Phones...),
+		Address: c.Address,
+		Notes:   c.Notes,
 	}
 }
 
+// Whether both contacts hold the same values, an empty list of phones equals none
+func (c *Contact) Equal(other Contact) bool {
+	if c.Id != other.Id || c.Name != other.Name || c.LastName != other.LastName || c.Email != other.Email ||
+		c.Address != other.Address || c.Notes != other.Notes || len(c.Phones) != len(other.Phones) {
+		return false
+	}
+	for i, phone := range c.Phones {
+		if phone != other.Phones[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Contact) Validate() error {
 	// Or could use validator library in future
 
 	if c.Name == "" {
-		return errors.New("empty contact name")
+		return &ValidationError{Field: "name", Message: "empty contact name"}
 	}
 	if c.LastName == "" {
-		return errors.New("empty contact last name")
+		return &ValidationError{Field: "lastName", Message: "empty contact last name"}
 	}
 	if c.Email == "" {
-		return errors.New("empty contact email")
+		return &ValidationError{Field: "email", Message: "empty contact email"}
 	}
 
 	return nil
 }
 
+func anonymizeOptional(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "*** ANONYMIZED ***"
+}
+
 func (c *Contact) Anonymize() Contact {
+	var phones []string
+	for range c.Phones {
+		phones = append(phones, "*** ANONYMIZED ***")
+	}
+
 	return Contact{
 		Id:       c.Id,
 		Email:    "*** ANONYMIZED ***",
 		Name:     "*** ANONYMIZED ***",
 		LastName: "*** ANONYMIZED ***",
+
+		Phones:  phones,
+		Address: anonymizeOptional(c.Address),
+		Notes:   anonymizeOptional(c.Notes),
 	}
 }
@@ -8,8 +8,15 @@ type ContactDatabase interface {
 
 	// Updates a contact in the database - in case of no matching contact by id, false will be returned
 	Update(contact Contact) bool
+	// Updates a contact only when it still equals before, so read-modify-write cycles do not lose
+	// concurrent changes - in case the contact was changed or deleted, false will be returned
+	UpdateIf(before Contact, after Contact) bool
 	// Deletes a contact in the database - in case of no matching contact by id, false will be returned
 	Delete(contact Contact) bool
+	// Updates the survivor and deletes the other merged contacts as one operation - in case of any
+	// merged contact changed or deleted since it was read, nothing is changed and false will be
+	// returned
+	Merge(merged []Contact, survivor Contact) bool
 
 	// Find a contact by id, or returns nil if not found
 	FindById(id int) *Contact
@@ -19,4 +26,13 @@ type ContactDatabase interface {
 	FindByEmail(email string) []Contact
 	// Finds all contacts in the database. Order is unspecified
 	FindAll() []Contact
+	// Finds up to limit contacts with id greater than afterId, ordered by id
+	FindAfterId(afterId int, limit int) []Contact
+	// Returns number of contacts in the database
+	Count() int
+
+	// Returns all contacts and the highest id ever assigned as one consistent point in time
+	Snapshot() ([]Contact, int)
+	// Replaces all data of the database as one operation
+	Restore(contacts []Contact, highestId int)
 }
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	CsvContentType = "text/csv"
+
+	CsvLayoutDefault = "default"
+	CsvLayoutGoogle  = "google"
+	CsvLayoutOutlook = "outlook"
+
+	// Separator of multiple values within single cell, as used by Google Contacts
+	csvValueSeparator = " ::: "
+	// Limits size of the import report, rows failing beyond it are only counted
+	maxCsvRowErrors = 100
+	// Leading characters spreadsheets take for a formula
+	csvFormulaPrefixes = "=+-@"
+)
+
+// Maps contact fields to CSV column headers. Empty header means the field is not mapped
+type CsvMapping struct {
+	// Only used for export - imported contacts always get a new id
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	LastName string `json:"lastName"`
+	Email    string `json:"email"`
+	// Phones are spread over the columns in order, the last column holds any remaining ones
+	Phones  []string `json:"phones,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Notes   string   `json:"notes,omitempty"`
+}
+
+var CsvLayouts = map[string]CsvMapping{
+	CsvLayoutDefault: {
+		Id:       "id",
+		Name:     "name",
+		LastName: "lastName",
+		Email:    "email",
+		Phones:   []string{"phones"},
+		Address:  "address",
+		Notes:    "notes",
+	},
+	CsvLayoutGoogle: {
+		Name:     "First Name",
+		LastName: "Last Name",
+		Email:    "E-mail 1 - Value",
+		Phones:   []string{"Phone 1 - Value", "Phone 2 - Value", "Phone 3 - Value"},
+		Address:  "Address 1 - Formatted",
+		Notes:    "Notes",
+	},
+	CsvLayoutOutlook: {
+		Name:     "First Name",
+		LastName: "Last Name",
+		Email:    "E-mail Address",
+		Phones:   []string{"Mobile Phone", "Home Phone", "Business Phone"},
+		Address:  "Home Street",
+		Notes:    "Notes",
+	},
+}
+
+type CsvRowError struct {
+	// Line of the record in the file, header is line 1
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type CsvImportReport struct {
+	Layout   string        `json:"layout,omitempty"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []CsvRowError `json:"errors"`
+}
+
+func (m *CsvMapping) headers() []string {
+	var headers []string
+	for _, header := range []string{m.Id, m.Name, m.LastName, m.Email} {
+		if header != "" {
+			headers = append(headers, header)
+		}
+	}
+	headers = append(headers, m.Phones...)
+	for _, header := range []string{m.Address, m.Notes} {
+		if header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+func normalizeCsvHeader(header string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+}
+
+// Picks the built-in layout matching most of the header columns
+func DetectCsvLayout(header []string) (string, error) {
+	present := make(map[string]bool)
+	for _, column := range header {
+		present[normalizeCsvHeader(column)] = true
+	}
+
+	best, bestScore := "", 0
+	for _, layout := range []string{CsvLayoutDefault, CsvLayoutGoogle, CsvLayoutOutlook} {
+		mapping := CsvLayouts[layout]
+		if !present[normalizeCsvHeader(mapping.Name)] || !present[normalizeCsvHeader(mapping.LastName)] {
+			continue
+		}
+
+		score := 0
+		for _, column := range mapping.headers() {
+			if present[normalizeCsvHeader(column)] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = layout, score
+		}
+	}
+
+	if best == "" {
+		return "", errors.New("unrecognized CSV header")
+	}
+	return best, nil
+}
+
+type CsvReader struct {
+	reader  *csv.Reader
+	mapping CsvMapping
+	columns map[string]int
+}
+
+// Creates a reader reading the header row immediately. Nil mapping means auto-detection of the layout
+func NewCsvReader(r io.Reader, mapping *CsvMapping) (*CsvReader, string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	layout := ""
+	if mapping == nil {
+		layout, err = DetectCsvLayout(header)
+		if err != nil {
+			return nil, "", err
+		}
+		layoutMapping := CsvLayouts[layout]
+		mapping = &layoutMapping
+	}
+
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[normalizeCsvHeader(column)] = i
+	}
+	for _, required := range []string{mapping.Name, mapping.LastName, mapping.Email} {
+		if _, ok := columns[normalizeCsvHeader(required)]; !ok {
+			return nil, "", fmt.Errorf("missing CSV column %q", required)
+		}
+	}
+
+	return &CsvReader{reader: reader, mapping: *mapping, columns: columns}, layout, nil
+}
+
+func (c *CsvReader) cell(record []string, header string) string {
+	if header == "" {
+		return ""
+	}
+	i, ok := c.columns[normalizeCsvHeader(header)]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return unescapeCsvFormula(strings.TrimSpace(record[i]))
+}
+
+// Quotes values spreadsheets would evaluate as formulas, like "=HYPERLINK(...)", with a leading
+// apostrophe, which spreadsheets hide and CsvReader removes again. Phones like "+44 20" are quoted too
+func escapeCsvFormula(value string) string {
+	if value != "" && strings.IndexByte(csvFormulaPrefixes, value[0]) >= 0 {
+		return "'" + value
+	}
+	return value
+}
+
+func unescapeCsvFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.IndexByte(csvFormulaPrefixes, value[1]) >= 0 {
+		return value[1:]
+	}
+	return value
+}
+
+// Reads next contact. Returns io.EOF at the end of input; other errors only affect the current row
+func (c *CsvReader) Read() (Contact, int, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		return Contact{}, 0, err
+	}
+	row, _ := c.reader.FieldPos(0)
+
+	contact := Contact{
+		Name:     c.cell(record, c.mapping.Name),
+		LastName: c.cell(record, c.mapping.LastName),
+		Email:    c.cell(record, c.mapping.Email),
+		Address:  c.cell(record, c.mapping.Address),
+		Notes:    c.cell(record, c.mapping.Notes),
+	}
+	for _, header := range c.mapping.Phones {
+		for _, phone := range strings.Split(c.cell(record, header), csvValueSeparator) {
+			if phone = strings.TrimSpace(phone); phone != "" {
+				contact.Phones = append(contact.Phones, phone)
+			}
+		}
+	}
+
+	return contact, row, nil
+}
+
+// Imports rows one by one, collecting a report of failed rows instead of aborting. Only fatal errors
+// (unreadable header, failing reader) are returned
+func ImportCsv(r io.Reader, mapping *CsvMapping, insert func(Contact) error) (CsvImportReport, error) {
+	report := CsvImportReport{Errors: []CsvRowError{}}
+
+	reader, layout, err := NewCsvReader(r, mapping)
+	if err != nil {
+		return report, err
+	}
+	report.Layout = layout
+
+	fail := func(row int, err error) {
+		report.Failed++
+		if len(report.Errors) < maxCsvRowErrors {
+			report.Errors = append(report.Errors, CsvRowError{Row: row, Error: err.Error()})
+		}
+	}
+
+	for {
+		contact, row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			fail(parseErr.StartLine, err)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if err := contact.Validate(); err != nil {
+			fail(row, err)
+			continue
+		}
+		if err := insert(contact); err != nil {
+			fail(row, err)
+			continue
+		}
+		report.Imported++
+	}
+
+	return report, nil
+}
+
+type CsvWriter struct {
+	writer  *csv.Writer
+	mapping CsvMapping
+	record  []string
+}
+
+// Creates a writer, writing the header row immediately
+func NewCsvWriter(w io.Writer, mapping CsvMapping) (*CsvWriter, error) {
+	writer := csv.NewWriter(w)
+	headers := mapping.headers()
+	if err := writer.Write(headers); err != nil {
+		return nil, err
+	}
+	return &CsvWriter{writer: writer, mapping: mapping, record: make([]string, len(headers))}, nil
+}
+
+func (c *CsvWriter) Write(contact Contact) error {
+	record := c.record[:0]
+	if c.mapping.Id != "" {
+		record = append(record, strconv.Itoa(contact.Id))
+	}
+	for _, field := range []struct{ header, value string }{
+		{c.mapping.Name, contact.Name},
+		{c.mapping.LastName, contact.LastName},
+		{c.mapping.Email, contact.Email},
+	} {
+		if field.header != "" {
+			record = append(record, field.value)
+		}
+	}
+	for i := range c.mapping.Phones {
+		switch {
+		case i >= len(contact.Phones):
+			record = append(record, "")
+		case i == len(c.mapping.Phones)-1:
+			record = append(record, strings.Join(contact.Phones[i:], csvValueSeparator))
+		default:
+			record = append(record, contact.Phones[i])
+		}
+	}
+	for _, field := range []struct{ header, value string }{
+		{c.mapping.Address, contact.Address},
+		{c.mapping.Notes, contact.Notes},
+	} {
+		if field.header != "" {
+			record = append(record, field.value)
+		}
+	}
+	for i := range record {
+		record[i] = escapeCsvFormula(record[i])
+	}
+
+	return c.writer.Write(record)
+}
+
+func (c *CsvWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCsvRoundTrip(t *testing.T) {
+	contacts := []server.Contact{
+		{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com",
+			Phones: []string{"111", "222", "333", "444"}, Address: "251 Menlove Avenue, Liverpool", Notes: "Guitar, \"vocals\""},
+		{Id: 2, Name: "Paul", LastName: "McCartney", Email: "paul.mccartney@thebeatles.com"},
+		{Id: 3, Name: "=HYPERLINK(\"http://evil.example\")", LastName: "-Best", Email: "pete@beatles.com",
+			Phones: []string{"+44 20 7946 0000"}, Notes: "@mention"},
+	}
+
+	for layout, mapping := range server.CsvLayouts {
+		var buf bytes.Buffer
+		writer, err := server.NewCsvWriter(&buf, mapping)
+		require.NoError(t, err)
+		for _, contact := range contacts {
+			require.NoError(t, writer.Write(contact))
+		}
+		require.NoError(t, writer.Flush())
+
+		var imported []server.Contact
+		report, err := server.ImportCsv(&buf, nil, func(contact server.Contact) error {
+			imported = append(imported, contact)
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, layout, report.Layout)
+		assert.Equal(t, 3, report.Imported)
+		require.Equal(t, 3, len(imported))
+		for i := range contacts {
+			expected := contacts[i]
+			expected.Id = 0
+			assert.Equal(t, expected, imported[i], "layout %s", layout)
+		}
+	}
+}
+
+func TestCsvFormulaEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := server.NewCsvWriter(&buf, server.CsvLayouts[server.CsvLayoutGoogle])
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(server.Contact{Name: "=HYPERLINK(\"http://evil.example\")", LastName: "Best",
+		Email: "pete@beatles.com", Phones: []string{"+44 20 7946 0000"}, Notes: "'quoted' -"}))
+	require.NoError(t, writer.Flush())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "'=HYPERLINK(\"http://evil.example\")", records[1][0])
+	assert.Equal(t, "Best", records[1][1])
+	assert.Equal(t, "'+44 20 7946 0000", records[1][3])
+	assert.Equal(t, "'quoted' -", records[1][7])
+}
+
+func TestImportCsvRowErrors(t *testing.T) {
+	data := "First Name,Last Name,E-mail Address,Mobile Phone\n" +
+		"John,Lennon,john.lennon@thebeatles.com,111\n" +
+		"Paul,,paul.mccartney@thebeatles.com,\n" +
+		"George,Harrison,george.harrison@thebeatles.com,222,extra\n" +
+		"Ringo,Starr,ringo.starr@thebeatles.com\n" +
+		"Pete,Best,pete.best@thebeatles.com,\n"
+
+	var imported []string
+	report, err := server.ImportCsv(strings.NewReader(data), nil, func(contact server.Contact) error {
+		if contact.Name == "Pete" {
+			return errors.New("rejected")
+		}
+		imported = append(imported, contact.Name)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, server.CsvLayoutOutlook, report.Layout)
+	assert.Equal(t, []string{"John", "George", "Ringo"}, imported)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 2, report.Failed)
+	assert.Equal(t, []server.CsvRowError{
+		{Row: 3, Error: "empty contact last name"},
+		{Row: 6, Error: "rejected"},
+	}, report.Errors)
+}
+
+func TestImportCsvCustomMapping(t *testing.T) {
+	data := "Mail;Given;Family\nringo.starr@thebeatles.com;Ringo;Starr\n"
+	mapping := &server.CsvMapping{Name: "given", LastName: "family", Email: "mail"}
+
+	var imported []server.Contact
+	_, err := server.ImportCsv(strings.NewReader(strings.ReplaceAll(data, ";", ",")), mapping, func(contact server.Contact) error {
+		imported = append(imported, contact)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []server.Contact{{Name: "Ringo", LastName: "Starr", Email: "ringo.starr@thebeatles.com"}}, imported)
+
+	_, err = server.ImportCsv(strings.NewReader("foo,bar\n1,2\n"), nil, func(server.Contact) error { return nil })
+	assert.Error(t, err, "undetected layout")
+}
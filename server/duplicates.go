@@ -0,0 +1,246 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+const DefaultDuplicateThreshold = 0.8
+
+// Scored pair of contacts considered to be possible duplicates of each other
+type DuplicatePair struct {
+	FirstId  int     `json:"firstId" yaml:"firstId"`
+	SecondId int     `json:"secondId" yaml:"secondId"`
+	Score    float64 `json:"score" yaml:"score"`
+}
+
+// Group of contacts transitively linked by duplicate pairs
+type DuplicateCluster struct {
+	Contacts []Contact       `json:"contacts" yaml:"contacts"`
+	Pairs    []DuplicatePair `json:"pairs" yaml:"pairs"`
+	// Highest score of all pairs in the cluster
+	Score float64 `json:"score" yaml:"score"`
+}
+
+type DuplicateDetector struct {
+	threshold float64
+}
+
+func NewDuplicateDetector(threshold float64) *DuplicateDetector {
+	return &DuplicateDetector{threshold: threshold}
+}
+
+func normalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	// Strip sub-addressing, ie. john+work@example.com
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return local + "@" + domain
+}
+
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Returns similarity of two strings in range [0, 1] based on edit distance
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func emailSimilarity(a, b string) float64 {
+	a, b = normalizeEmail(a), normalizeEmail(b)
+	if a == b {
+		return 1
+	}
+
+	localA, domainA := splitEmail(a)
+	localB, domainB := splitEmail(b)
+	if domainA != domainB {
+		return 0
+	}
+
+	// Same domain, similar local part - "jon.lennon" vs "john.lennon"
+	return similarity(localA, localB) * 0.8
+}
+
+func splitEmail(email string) (string, string) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email, ""
+	}
+	return email[:at], email[at+1:]
+}
+
+// Scores how likely two contacts describe the same person, in range [0, 1]
+func (d *DuplicateDetector) Score(a, b Contact) float64 {
+	email := emailSimilarity(a.Email, b.Email)
+	name := similarity(normalizeName(a.Name), normalizeName(b.Name))
+	lastName := similarity(normalizeName(a.LastName), normalizeName(b.LastName))
+
+	return 0.4*email + 0.3*name + 0.3*lastName
+}
+
+// Returns keys used to group candidates - only contacts sharing at least one key are compared
+func blockingKeys(contact Contact) []string {
+	var keys []string
+
+	email := normalizeEmail(contact.Email)
+	if email != "" {
+		keys = append(keys, "email:"+email)
+	}
+
+	lastName := []rune(strings.ReplaceAll(normalizeName(contact.LastName), " ", ""))
+	if len(lastName) > 3 {
+		lastName = lastName[:3]
+	}
+	if len(lastName) > 0 {
+		keys = append(keys, "lastName:"+string(lastName))
+	}
+
+	return keys
+}
+
+// Finds clusters of probable duplicates. Clusters and contacts within are ordered by id
+func (d *DuplicateDetector) FindDuplicates(contacts []Contact) []DuplicateCluster {
+	blocks := make(map[string][]int)
+	for i, contact := range contacts {
+		for _, key := range blockingKeys(contact) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	parent := make([]int, len(contacts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	type pairKey struct{ i, j int }
+	seen := make(map[pairKey]bool)
+	var pairs []pairKey
+	scores := make(map[pairKey]float64)
+
+	for _, block := range blocks {
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				key := pairKey{block[x], block[y]}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				score := d.Score(contacts[key.i], contacts[key.j])
+				if score < d.threshold {
+					continue
+				}
+
+				pairs = append(pairs, key)
+				scores[key] = score
+				parent[find(key.i)] = find(key.j)
+			}
+		}
+	}
+
+	byRoot := make(map[int]*DuplicateCluster)
+	for _, pair := range pairs {
+		root := find(pair.i)
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &DuplicateCluster{}
+			byRoot[root] = cluster
+		}
+
+		first, second := contacts[pair.i], contacts[pair.j]
+		if first.Id > second.Id {
+			first, second = second, first
+		}
+		score := scores[pair]
+		cluster.Pairs = append(cluster.Pairs, DuplicatePair{FirstId: first.Id, SecondId: second.Id, Score: score})
+		if score > cluster.Score {
+			cluster.Score = score
+		}
+	}
+	for i, contact := range contacts {
+		if cluster, ok := byRoot[find(i)]; ok {
+			cluster.Contacts = append(cluster.Contacts, contact)
+		}
+	}
+
+	var result []DuplicateCluster
+	for _, cluster := range byRoot {
+		sort.Slice(cluster.Contacts, func(i, j int) bool {
+			return cluster.Contacts[i].Id < cluster.Contacts[j].Id
+		})
+		sort.Slice(cluster.Pairs, func(i, j int) bool {
+			if cluster.Pairs[i].FirstId != cluster.Pairs[j].FirstId {
+				return cluster.Pairs[i].FirstId < cluster.Pairs[j].FirstId
+			}
+			return cluster.Pairs[i].SecondId < cluster.Pairs[j].SecondId
+		})
+		result = append(result, *cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Contacts[0].Id < result[j].Contacts[0].Id
+	})
+
+	return result
+}
@@ -0,0 +1,56 @@
+package server_test
+
+import (
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreNearDuplicates(t *testing.T) {
+	detector := server.NewDuplicateDetector(server.DefaultDuplicateThreshold)
+
+	a := server.Contact{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com"}
+	b := server.Contact{Id: 2, Name: "Jon", LastName: "Lennon", Email: " John.Lennon@TheBeatles.com"}
+	c := server.Contact{Id: 3, Name: "Ringo", LastName: "Starr", Email: "ringo.starr@thebeatles.com"}
+
+	assert.Equal(t, 1.0, detector.Score(a, a))
+	assert.Greater(t, detector.Score(a, b), server.DefaultDuplicateThreshold)
+	assert.Less(t, detector.Score(a, c), server.DefaultDuplicateThreshold)
+}
+
+func TestFindDuplicatesClusters(t *testing.T) {
+	detector := server.NewDuplicateDetector(server.DefaultDuplicateThreshold)
+	contacts := []server.Contact{
+		{Id: 4, Name: "Johnny", LastName: "Lennon", Email: "john.lennon+music@thebeatles.com"},
+		{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com"},
+		{Id: 2, Name: "Paul", LastName: "McCartney", Email: "paul.mccartney@thebeatles.com"},
+		{Id: 3, Name: "Jon", LastName: "Lennon", Email: "John.Lennon@thebeatles.com"},
+		{Id: 5, Name: "Paul", LastName: "McCartney", Email: "paul@wings.com"},
+	}
+
+	clusters := detector.FindDuplicates(contacts)
+
+	require.Equal(t, 1, len(clusters))
+	ids := []int{}
+	for _, contact := range clusters[0].Contacts {
+		ids = append(ids, contact.Id)
+	}
+	assert.Equal(t, []int{1, 3, 4}, ids)
+	assert.NotEmpty(t, clusters[0].Pairs)
+	for _, pair := range clusters[0].Pairs {
+		assert.Less(t, pair.FirstId, pair.SecondId)
+		assert.LessOrEqual(t, pair.Score, clusters[0].Score)
+	}
+}
+
+func TestFindDuplicatesNoMatch(t *testing.T) {
+	detector := server.NewDuplicateDetector(server.DefaultDuplicateThreshold)
+	contacts := []server.Contact{
+		{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com"},
+		{Id: 2, Name: "George", LastName: "Harrison", Email: "george.harrison@thebeatles.com"},
+	}
+
+	assert.Empty(t, detector.FindDuplicates(contacts))
+}
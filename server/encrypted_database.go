@@ -0,0 +1,440 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix of sealed field values - "enc1:<keyId>:<base64 nonce+ciphertext>[:<hex blind index>]"
+const sealedPrefix = "enc1:"
+
+const fieldKeyLen = 32
+
+type fieldKeyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+type fieldKey struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// Set of keys for field encryption - new values are sealed with the active key, older keys are
+// kept for decryption until all records are re-encrypted
+type FieldKeyRing struct {
+	active string
+	keys   map[string]*fieldKey
+}
+
+func newFieldKey(secret []byte) (*fieldKey, error) {
+	if len(secret) != fieldKeyLen {
+		return nil, fmt.Errorf("field key must be %d bytes", fieldKeyLen)
+	}
+
+	// Separate subkeys, so that blind indexes reveal nothing about the encryption key
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("contacts field encryption"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac = hmac.New(sha256.New, secret)
+	mac.Write([]byte("contacts blind index"))
+	return &fieldKey{aead: aead, indexKey: mac.Sum(nil)}, nil
+}
+
+func readFieldKeyFile(path string) (*fieldKeyFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file fieldKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &file, nil
+}
+
+func LoadFieldKeyRing(path string) (*FieldKeyRing, error) {
+	file, err := readFieldKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &FieldKeyRing{active: file.Active, keys: make(map[string]*fieldKey)}
+	for id, encoded := range file.Keys {
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if ring.keys[id], err = newFieldKey(secret); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+	}
+	if _, ok := ring.keys[ring.active]; !ok {
+		return nil, fmt.Errorf("active key %q not found in %s", file.Active, path)
+	}
+
+	return ring, nil
+}
+
+// Adds a new random key to the key file and makes it active, creating the file if needed.
+// Returns id of the new key
+func RotateFieldKeyFile(path string) (string, error) {
+	file, err := readFieldKeyFile(path)
+	if os.IsNotExist(err) {
+		file, err = &fieldKeyFile{Keys: make(map[string]string)}, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	n := len(file.Keys) + 1
+	for file.Keys["k"+strconv.Itoa(n)] != "" {
+		n++
+	}
+	id := "k" + strconv.Itoa(n)
+	secret := make([]byte, fieldKeyLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	file.Keys[id] = base64.StdEncoding.EncodeToString(secret)
+	file.Active = id
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return id, ioutil.WriteFile(path, data, 0600)
+}
+
+func (k *FieldKeyRing) blindIndex(keyId string, value string) string {
+	mac := hmac.New(sha256.New, k.keys[keyId].indexKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k *FieldKeyRing) seal(value string, withIndex bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	key := k.keys[k.active]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// Key id is authenticated, so a value can't be swapped to be opened by another key
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), []byte(k.active))
+
+	result := sealedPrefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+	if withIndex {
+		result += ":" + k.blindIndex(k.active, value)
+	}
+	return result, nil
+}
+
+func parseSealed(value string) (keyId string, sealed string, index string, ok bool) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", "", "", false
+	}
+	if len(parts) == 3 {
+		index = parts[2]
+	}
+	return parts[0], parts[1], index, true
+}
+
+// Opens a sealed value - values which are not sealed (ie. stored before encryption was enabled)
+// are returned as they are
+func (k *FieldKeyRing) open(value string) (string, error) {
+	keyId, encoded, _, ok := parseSealed(value)
+	if !ok {
+		return value, nil
+	}
+
+	key, ok := k.keys[keyId]
+	if !ok {
+		return "", fmt.Errorf("unknown field key %q", keyId)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("malformed sealed value")
+	}
+	nonceSize := key.aead.NonceSize()
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyId))
+	if err != nil {
+		return "", errors.New("sealed value failed authentication")
+	}
+	return string(plaintext), nil
+}
+
+// Wraps a database, encrypting email, phones, address and notes of every stored contact. Emails
+// carry a blind index, so FindByEmail works without decrypting the stored records
+type EncryptedDatabase struct {
+	inner ContactDatabase
+	keys  *FieldKeyRing
+	// Serializes writes with re-encryption, so that it never overwrites a concurrent update
+	mu sync.Mutex
+}
+
+var _ ContactDatabase = (*EncryptedDatabase)(nil)
+
+func NewEncryptedDatabase(inner ContactDatabase, keys *FieldKeyRing) *EncryptedDatabase {
+	return &EncryptedDatabase{inner: inner, keys: keys}
+}
+
+func (e *EncryptedDatabase) encrypt(contact Contact) Contact {
+	sealed := *contact.Clone()
+
+	// Sealing only fails when the system random source fails, which is not recoverable
+	must := func(value string, err error) string {
+		if err != nil {
+			panic(err)
+		}
+		return value
+	}
+
+	sealed.Email = must(e.keys.seal(contact.Email, true))
+	sealed.Address = must(e.keys.seal(contact.Address, false))
+	sealed.Notes = must(e.keys.seal(contact.Notes, false))
+	for i, phone := range contact.Phones {
+		sealed.Phones[i] = must(e.keys.seal(phone, false))
+	}
+	return sealed
+}
+
+// Decrypts stored contact. Values which can't be opened (ie. with a removed key) are left sealed,
+// as the database interface has no way to report the failure
+func (e *EncryptedDatabase) decrypt(sealed Contact) Contact {
+	contact := *sealed.Clone()
+
+	open := func(value string) string {
+		plaintext, err := e.keys.open(value)
+		if err != nil {
+			return value
+		}
+		return plaintext
+	}
+
+	contact.Email = open(sealed.Email)
+	contact.Address = open(sealed.Address)
+	contact.Notes = open(sealed.Notes)
+	for i, phone := range sealed.Phones {
+		contact.Phones[i] = open(phone)
+	}
+	return contact
+}
+
+func (e *EncryptedDatabase) decryptAll(sealed []Contact) []Contact {
+	var result []Contact
+	for _, contact := range sealed {
+		result = append(result, e.decrypt(contact))
+	}
+	return result
+}
+
+func (e *EncryptedDatabase) Insert(contact Contact) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.inner.Insert(e.encrypt(contact))
+}
+
+func (e *EncryptedDatabase) InsertWithNewId(contact Contact) Contact {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	contact.Id = e.inner.InsertWithNewId(e.encrypt(contact)).Id
+	return contact
+}
+
+func (e *EncryptedDatabase) Update(contact Contact) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.inner.Update(e.encrypt(contact))
+}
+
+// Compares before with the decrypted contact, as sealing the same value twice never gives the same
+// ciphertext
+func (e *EncryptedDatabase) UpdateIf(before Contact, after Contact) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := e.inner.FindById(before.Id)
+	if current == nil || !before.Equal(e.decrypt(*current)) {
+		return false
+	}
+	return e.inner.UpdateIf(*current, e.encrypt(after))
+}
+
+func (e *EncryptedDatabase) Delete(contact Contact) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.inner.Delete(contact)
+}
+
+func (e *EncryptedDatabase) Merge(merged []Contact, survivor Contact) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var sealed []Contact
+	for _, contact := range merged {
+		current := e.inner.FindById(contact.Id)
+		if current == nil || !contact.Equal(e.decrypt(*current)) {
+			return false
+		}
+		sealed = append(sealed, *current)
+	}
+	return e.inner.Merge(sealed, e.encrypt(survivor))
+}
+
+func (e *EncryptedDatabase) FindById(id int) *Contact {
+	sealed := e.inner.FindById(id)
+	if sealed == nil {
+		return nil
+	}
+	contact := e.decrypt(*sealed)
+	return &contact
+}
+
+func (e *EncryptedDatabase) FindByLastNameContains(part string) []Contact {
+	return e.decryptAll(e.inner.FindByLastNameContains(part))
+}
+
+func (e *EncryptedDatabase) FindByEmail(email string) []Contact {
+	// Index differs per key, so it is computed once for each key in use
+	indexes := make(map[string]string)
+
+	var result []Contact
+	for _, sealed := range e.inner.FindAll() {
+		keyId, _, index, ok := parseSealed(sealed.Email)
+		if !ok {
+			// Not encrypted yet
+			if sealed.Email == email {
+				result = append(result, *sealed.Clone())
+			}
+			continue
+		}
+		if _, known := e.keys.keys[keyId]; !known {
+			continue
+		}
+
+		expected, computed := indexes[keyId]
+		if !computed {
+			expected = e.keys.blindIndex(keyId, email)
+			indexes[keyId] = expected
+		}
+		if hmac.Equal([]byte(index), []byte(expected)) {
+			contact := e.decrypt(sealed)
+			// Index is case insensitive, while FindByEmail matches exactly
+			if contact.Email == email {
+				result = append(result, contact)
+			}
+		}
+	}
+	return result
+}
+
+func (e *EncryptedDatabase) FindAll() []Contact {
+	return e.decryptAll(e.inner.FindAll())
+}
+
+func (e *EncryptedDatabase) FindAfterId(afterId int, limit int) []Contact {
+	return e.decryptAll(e.inner.FindAfterId(afterId, limit))
+}
+
+func (e *EncryptedDatabase) Count() int {
+	return e.inner.Count()
+}
+
+func (e *EncryptedDatabase) Snapshot() ([]Contact, int) {
+	sealed, highestId := e.inner.Snapshot()
+	return e.decryptAll(sealed), highestId
+}
+
+func (e *EncryptedDatabase) Restore(contacts []Contact, highestId int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var sealed []Contact
+	for _, contact := range contacts {
+		sealed = append(sealed, e.encrypt(contact))
+	}
+	e.inner.Restore(sealed, highestId)
+}
+
+func (e *EncryptedDatabase) needsReencryption(sealed Contact) bool {
+	needed := false
+	values := append([]string{sealed.Email, sealed.Address, sealed.Notes}, sealed.Phones...)
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		keyId, _, _, ok := parseSealed(value)
+		if ok && e.keys.keys[keyId] == nil {
+			// Sealed with a removed key, re-encrypting would seal the ciphertext
+			return false
+		}
+		needed = needed || !ok || keyId != e.keys.active
+	}
+	return needed
+}
+
+// Re-encrypts all records not sealed with the active key, including records stored before the
+// encryption was enabled. Safe to run in background while the database is in use; returns the
+// number of re-encrypted records
+func (e *EncryptedDatabase) Reencrypt() int {
+	reencrypted := 0
+	afterId := 0
+
+	for {
+		page := e.inner.FindAfterId(afterId, 100)
+		for _, stale := range page {
+			afterId = stale.Id
+			if !e.needsReencryption(stale) {
+				continue
+			}
+
+			e.mu.Lock()
+			// Re-read under the lock, the record might have changed meanwhile
+			if current := e.inner.FindById(stale.Id); current != nil && e.needsReencryption(*current) {
+				if e.inner.Update(e.encrypt(e.decrypt(*current))) {
+					reencrypted++
+				}
+			}
+			e.mu.Unlock()
+		}
+
+		if len(page) < 100 {
+			return reencrypted
+		}
+	}
+}
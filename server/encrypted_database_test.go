@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createEncryptedDatabase(t *testing.T) (*server.EncryptedDatabase, *server.MemoryDatabase, string) {
+	keyFile := filepath.Join(t.TempDir(), "fields.key")
+	_, err := server.RotateFieldKeyFile(keyFile)
+	require.NoError(t, err)
+
+	keys, err := server.LoadFieldKeyRing(keyFile)
+	require.NoError(t, err)
+
+	inner := server.NewMemoryDatabase()
+	return server.NewEncryptedDatabase(inner, keys), inner, keyFile
+}
+
+func assertSealed(t *testing.T, contact server.Contact, keyId string) {
+	for _, value := range append([]string{contact.Email, contact.Address, contact.Notes}, contact.Phones...) {
+		if value != "" {
+			assert.True(t, strings.HasPrefix(value, "enc1:"+keyId+":"), "value %q not sealed with %s", value, keyId)
+		}
+	}
+}
+
+func TestEncryptedDatabaseSealsFields(t *testing.T) {
+	db, inner, _ := createEncryptedDatabase(t)
+	contact := server.Contact{
+		Name:     "John",
+		LastName: "Lennon",
+		Email:    "john.lennon@thebeatles.com",
+		Phones:   []string{"111", "222"},
+		Address:  "251 Menlove Avenue",
+		Notes:    "Guitar",
+	}
+
+	contact = db.InsertWithNewId(contact)
+	assert.Equal(t, 1, contact.Id)
+
+	stored := inner.FindById(contact.Id)
+	require.NotNil(t, stored)
+	assert.Equal(t, "Lennon", stored.LastName)
+	assertSealed(t, *stored, "k1")
+	assert.NotContains(t, stored.Email, "lennon")
+
+	assert.Equal(t, &contact, db.FindById(contact.Id))
+	assert.Equal(t, []server.Contact{contact}, db.FindAll())
+	assert.Equal(t, []server.Contact{contact}, db.FindByLastNameContains("Len"))
+
+	assert.Equal(t, []server.Contact{contact}, db.FindByEmail("john.lennon@thebeatles.com"))
+	assert.Empty(t, db.FindByEmail("JOHN.LENNON@thebeatles.com"))
+	assert.Empty(t, db.FindByEmail("paul.mccartney@thebeatles.com"))
+}
+
+func TestEncryptedDatabaseKeyRotation(t *testing.T) {
+	db, inner, keyFile := createEncryptedDatabase(t)
+
+	// Plaintext records from before encryption was enabled
+	require.NoError(t, inner.LoadFixtures())
+	contact := db.InsertWithNewId(server.Contact{Name: "Pete", LastName: "Best", Email: "pete.best@thebeatles.com", Notes: "Drums"})
+	assert.Equal(t, 4, db.Reencrypt())
+
+	keyId, err := server.RotateFieldKeyFile(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keyId)
+	keys, err := server.LoadFieldKeyRing(keyFile)
+	require.NoError(t, err)
+	rotated := server.NewEncryptedDatabase(inner, keys)
+
+	// Old records are readable and searchable before re-encryption finishes
+	assert.Equal(t, []server.Contact{contact}, rotated.FindByEmail(contact.Email))
+
+	assert.Equal(t, 5, rotated.Reencrypt())
+	assert.Equal(t, 0, rotated.Reencrypt())
+	for _, stored := range inner.FindAll() {
+		assertSealed(t, stored, "k2")
+	}
+	assert.Equal(t, &contact, rotated.FindById(contact.Id))
+	assert.Equal(t, "john.lennon@thebeatles.com", rotated.FindById(1).Email)
+}
+
+func TestEncryptedDatabaseUpdateIf(t *testing.T) {
+	db, _, _ := createEncryptedDatabase(t)
+	before := db.InsertWithNewId(server.Contact{Name: "John", LastName: "Lennon", Email: "john@beatles.com", Notes: "Guitar"})
+
+	after := before
+	after.Notes = "Piano"
+	assert.True(t, db.UpdateIf(before, after))
+	assert.Equal(t, &after, db.FindById(before.Id))
+	assert.False(t, db.UpdateIf(before, after))
+}
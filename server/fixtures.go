@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type FixtureConflictMode string
+
+const (
+	// Existing contact with the same id is replaced
+	FixtureConflictUpsert FixtureConflictMode = "upsert"
+	// Existing contact with the same id is kept
+	FixtureConflictSkip FixtureConflictMode = "skip"
+	// Existing contact with the same id aborts the whole load
+	FixtureConflictFail FixtureConflictMode = "fail"
+)
+
+const (
+	FixtureActionInsert = "insert"
+	FixtureActionUpdate = "update"
+	FixtureActionSkip   = "skip"
+)
+
+type FixtureOptions struct {
+	Conflict FixtureConflictMode
+	// Only reports what would change, without touching the database
+	DryRun bool
+}
+
+type FixtureAction struct {
+	File string `json:"file"`
+	// Zero for contacts without id, which get a new one
+	Id     int    `json:"id"`
+	Action string `json:"action"`
+}
+
+type FixtureReport struct {
+	Actions []FixtureAction `json:"actions"`
+	// Invalid contacts, duplicate ids and conflicts - any problem means nothing was loaded
+	Problems []string `json:"problems"`
+}
+
+func (r *FixtureReport) Count(action string) int {
+	count := 0
+	for _, a := range r.Actions {
+		if a.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *FixtureReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d to insert, %d to update, %d to skip",
+		r.Count(FixtureActionInsert), r.Count(FixtureActionUpdate), r.Count(FixtureActionSkip))
+	for _, problem := range r.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// Reads contacts from a YAML or JSON file, chosen by extension
+func ReadFixtureFile(path string) ([]Contact, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []Contact
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &contacts)
+	} else {
+		err = yaml.Unmarshal(data, &contacts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return contacts, nil
+}
+
+// Loads contacts from given files in order. All files are validated before anything is applied, so
+// the database is left untouched whenever the report has problems
+func LoadFixtureFiles(db ContactDatabase, paths []string, options FixtureOptions) (FixtureReport, error) {
+	report := FixtureReport{Actions: []FixtureAction{}, Problems: []string{}}
+
+	switch options.Conflict {
+	case FixtureConflictUpsert, FixtureConflictSkip, FixtureConflictFail:
+	default:
+		return report, fmt.Errorf("unknown conflict mode %q", options.Conflict)
+	}
+
+	type fixture struct {
+		file    string
+		contact Contact
+	}
+	var fixtures []fixture
+
+	for _, path := range paths {
+		contacts, err := ReadFixtureFile(path)
+		if err != nil {
+			return report, err
+		}
+
+		for i, contact := range contacts {
+			if err := contact.Validate(); err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: contact %d: %s", path, i+1, err))
+				continue
+			}
+
+			fixtures = append(fixtures, fixture{file: path, contact: contact})
+		}
+	}
+
+	// Ids defined by an earlier file conflict just like ids already in the database
+	defined := make(map[int]string)
+	for _, f := range fixtures {
+		action := FixtureActionInsert
+		id := f.contact.Id
+		if id != 0 {
+			previous, duplicate := defined[id]
+			if duplicate || db.FindById(id) != nil {
+				switch options.Conflict {
+				case FixtureConflictUpsert:
+					action = FixtureActionUpdate
+				case FixtureConflictSkip:
+					action = FixtureActionSkip
+				case FixtureConflictFail:
+					if duplicate {
+						report.Problems = append(report.Problems, fmt.Sprintf("%s: duplicate id %d, already defined in %s", f.file, id, previous))
+					} else {
+						report.Problems = append(report.Problems, fmt.Sprintf("%s: id %d already exists", f.file, id))
+					}
+				}
+			}
+			if !duplicate {
+				defined[id] = f.file
+			}
+		}
+		report.Actions = append(report.Actions, FixtureAction{File: f.file, Id: id, Action: action})
+	}
+
+	if len(report.Problems) > 0 {
+		return report, errors.New("invalid fixtures")
+	}
+	if options.DryRun {
+		return report, nil
+	}
+
+	// Contacts with ids go first, so new ids are never taken by a later fixture
+	for _, withId := range []bool{true, false} {
+		for i, f := range fixtures {
+			if (f.contact.Id != 0) != withId {
+				continue
+			}
+			applied := true
+			switch report.Actions[i].Action {
+			case FixtureActionInsert:
+				if f.contact.Id == 0 {
+					report.Actions[i].Id = db.InsertWithNewId(f.contact).Id
+				} else {
+					applied = db.Insert(f.contact)
+				}
+			case FixtureActionUpdate:
+				applied = db.Update(f.contact)
+			}
+			if !applied {
+				return report, fmt.Errorf("%s: id %d changed concurrently, fixtures partially loaded", f.file, f.contact.Id)
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,137 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFixture(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLoadFixtureFilesConflictModes(t *testing.T) {
+	yamlPath := writeFixture(t, "contacts.yaml", `
+- id: 1
+  name: Johnny
+  lastName: Lennon
+  email: john.lennon@thebeatles.com
+- name: Pete
+  lastName: Best
+  email: pete.best@thebeatles.com
+`)
+	jsonPath := writeFixture(t, "contacts.json", `[{"id": 10, "name": "Brian", "lastName": "Epstein", "email": "brian@nems.com"}]`)
+
+	for _, mode := range []server.FixtureConflictMode{server.FixtureConflictUpsert, server.FixtureConflictSkip, server.FixtureConflictFail} {
+		db := server.NewMemoryDatabase()
+		require.NoError(t, db.LoadFixtures())
+
+		report, err := server.LoadFixtureFiles(db, []string{yamlPath, jsonPath}, server.FixtureOptions{Conflict: mode})
+
+		switch mode {
+		case server.FixtureConflictUpsert:
+			require.NoError(t, err)
+			assert.Equal(t, 1, report.Count(server.FixtureActionUpdate))
+			assert.Equal(t, "Johnny", db.FindById(1).Name)
+		case server.FixtureConflictSkip:
+			require.NoError(t, err)
+			assert.Equal(t, 1, report.Count(server.FixtureActionSkip))
+			assert.Equal(t, "John", db.FindById(1).Name)
+		case server.FixtureConflictFail:
+			require.Error(t, err)
+			assert.Equal(t, []string{yamlPath + ": id 1 already exists"}, report.Problems)
+			assert.Equal(t, 4, len(db.FindAll()), "database changed despite failure")
+			continue
+		}
+
+		assert.Equal(t, 2, report.Count(server.FixtureActionInsert))
+		assert.Equal(t, 6, len(db.FindAll()))
+		assert.NotNil(t, db.FindById(10))
+		assert.Equal(t, 1, len(db.FindByEmail("pete.best@thebeatles.com")))
+	}
+}
+
+func TestLoadFixtureFilesDryRun(t *testing.T) {
+	path := writeFixture(t, "contacts.yaml", `
+- id: 5
+  name: Pete
+  lastName: Best
+  email: pete.best@thebeatles.com
+`)
+	db := server.NewMemoryDatabase()
+
+	report, err := server.LoadFixtureFiles(db, []string{path}, server.FixtureOptions{Conflict: server.FixtureConflictFail, DryRun: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, []server.FixtureAction{{File: path, Id: 5, Action: server.FixtureActionInsert}}, report.Actions)
+	assert.Empty(t, db.FindAll())
+}
+
+func TestLoadFixtureFilesProblems(t *testing.T) {
+	first := writeFixture(t, "first.yaml", `
+- id: 1
+  name: John
+  lastName: Lennon
+  email: john.lennon@thebeatles.com
+- id: 2
+  name: Paul
+  email: paul.mccartney@thebeatles.com
+`)
+	second := writeFixture(t, "second.json", `[{"id": 1, "name": "John", "lastName": "Lennon", "email": "john@example.com"}]`)
+	db := server.NewMemoryDatabase()
+
+	report, err := server.LoadFixtureFiles(db, []string{first, second}, server.FixtureOptions{Conflict: server.FixtureConflictFail})
+	require.Error(t, err)
+
+	assert.Equal(t, []string{
+		first + ": contact 2: empty contact last name",
+		second + ": duplicate id 1, already defined in " + first,
+	}, report.Problems)
+	assert.Empty(t, db.FindAll())
+}
+
+func TestLoadFixtureFilesDuplicateIds(t *testing.T) {
+	first := writeFixture(t, "first.yaml", `
+- name: Pete
+  lastName: Best
+  email: pete.best@thebeatles.com
+- id: 1
+  name: John
+  lastName: Lennon
+  email: john.lennon@thebeatles.com
+`)
+	second := writeFixture(t, "second.json", `[{"id": 1, "name": "Johnny", "lastName": "Lennon", "email": "john@example.com"}]`)
+
+	for _, mode := range []server.FixtureConflictMode{server.FixtureConflictUpsert, server.FixtureConflictSkip, server.FixtureConflictFail} {
+		db := server.NewMemoryDatabase()
+
+		report, err := server.LoadFixtureFiles(db, []string{first, second}, server.FixtureOptions{Conflict: mode})
+
+		switch mode {
+		case server.FixtureConflictUpsert:
+			require.NoError(t, err)
+			assert.Equal(t, server.FixtureActionUpdate, report.Actions[2].Action)
+			assert.Equal(t, "Johnny", db.FindById(1).Name)
+		case server.FixtureConflictSkip:
+			require.NoError(t, err)
+			assert.Equal(t, server.FixtureActionSkip, report.Actions[2].Action)
+			assert.Equal(t, "John", db.FindById(1).Name)
+		case server.FixtureConflictFail:
+			require.Error(t, err)
+			assert.Equal(t, []string{second + ": duplicate id 1, already defined in " + first}, report.Problems)
+			assert.Empty(t, db.FindAll())
+			continue
+		}
+
+		// The contact without id comes first, yet never takes an id given explicitly
+		assert.Equal(t, server.FixtureAction{File: first, Id: 2, Action: server.FixtureActionInsert}, report.Actions[0])
+		assert.Equal(t, "Pete", db.FindById(2).Name)
+		assert.Equal(t, 2, db.Count())
+	}
+}
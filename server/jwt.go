@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Signature algorithms accepted in JWT headers
+const (
+	JwtHS256 = "HS256"
+	JwtRS256 = "RS256"
+	JwtEdDSA = "EdDSA"
+)
+
+// Allowed difference between the clocks of token issuer and server
+const DefaultJwtLeeway = 30 * time.Second
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Key verifying tokens of one algorithm - []byte for HS256, *rsa.PublicKey for RS256 and
+// ed25519.PublicKey for EdDSA
+type JwtKey struct {
+	Id        string
+	Algorithm string
+	Key       interface{}
+}
+
+func (k JwtKey) verify(algorithm string, signed []byte, signature []byte) bool {
+	if k.Algorithm != algorithm {
+		return false
+	}
+
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, signature)
+	}
+	return false
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+func (k jsonWebKey) jwtKey() (JwtKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "oct":
+		secret, err := decode(k.K)
+		if err != nil || len(secret) == 0 {
+			return JwtKey{}, fmt.Errorf("key %q: invalid secret", k.Kid)
+		}
+		return JwtKey{Id: k.Kid, Algorithm: JwtHS256, Key: secret}, nil
+
+	case "RSA":
+		n, errN := decode(k.N)
+		e, errE := decode(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return JwtKey{}, fmt.Errorf("key %q: invalid RSA key", k.Kid)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return JwtKey{Id: k.Kid, Algorithm: JwtRS256, Key: key}, nil
+
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return JwtKey{}, fmt.Errorf("key %q: invalid Ed25519 key", k.Kid)
+		}
+		return JwtKey{Id: k.Kid, Algorithm: JwtEdDSA, Key: ed25519.PublicKey(x)}, nil
+	}
+	return JwtKey{}, fmt.Errorf("key %q: unsupported key type %q", k.Kid, k.Kty)
+}
+
+// Parses JSON Web Key Set, skipping keys not meant for signatures
+func ParseJwks(data []byte) ([]JwtKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []JwtKey
+	for _, webKey := range set.Keys {
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+		key, err := webKey.jwtKey()
+		if err != nil {
+			return nil, err
+		}
+		if webKey.Alg != "" && webKey.Alg != key.Algorithm {
+			return nil, fmt.Errorf("key %q: algorithm %s does not match key type %s", webKey.Kid, webKey.Alg, webKey.Kty)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Loads verification keys from file - a JWKS document (.json), a PEM public key (RSA or Ed25519)
+// or else a raw HS256 secret. Single keys are identified by the file name without extension
+func LoadJwtKeys(path string) ([]JwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) == ".json" {
+		return ParseJwks(data)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		return []JwtKey{{Id: id, Algorithm: JwtHS256, Key: secret}}, nil
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return []JwtKey{{Id: id, Algorithm: JwtRS256, Key: key}}, nil
+	case ed25519.PublicKey:
+		return []JwtKey{{Id: id, Algorithm: JwtEdDSA, Key: key}}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Registered claims checked by the authenticator, plus name claims for the identity
+type JwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Roles             []string `json:"roles"`
+}
+
+// The aud claim is either a single string or an array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+func (a jwtAudience) contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticates requests by signed JWT bearer tokens
+type JwtAuthenticator struct {
+	keys []JwtKey
+	// Empty issuer or audience is not checked
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+func NewJwtAuthenticator(keys []JwtKey) *JwtAuthenticator {
+	return &JwtAuthenticator{keys: keys, leeway: DefaultJwtLeeway}
+}
+
+// Requires the iss claim to be issuer
+func (a *JwtAuthenticator) WithIssuer(issuer string) *JwtAuthenticator {
+	a.issuer = issuer
+	return a
+}
+
+// Requires the aud claim to contain audience
+func (a *JwtAuthenticator) WithAudience(audience string) *JwtAuthenticator {
+	a.audience = audience
+	return a
+}
+
+func (a *JwtAuthenticator) WithLeeway(leeway time.Duration) *JwtAuthenticator {
+	a.leeway = leeway
+	return a
+}
+
+// Checks signature and claims of a compact serialized token at given time
+func (a *JwtAuthenticator) Verify(token string, now time.Time) (JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JwtClaims{}, ErrInvalidToken
+	}
+	headerJson, errHeader := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJson, errClaims := base64.RawURLEncoding.DecodeString(parts[1])
+	signature, errSignature := base64.RawURLEncoding.DecodeString(parts[2])
+	if errHeader != nil || errClaims != nil || errSignature != nil {
+		return JwtClaims{}, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return JwtClaims{}, ErrInvalidToken
+	}
+
+	// The algorithm must match the key, so a public key is never used as HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range a.keys {
+		if (header.Kid == "" || key.Id == header.Kid) && key.verify(header.Alg, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return JwtClaims{}, ErrInvalidToken
+	}
+
+	var claims JwtClaims
+	if err := json.Unmarshal(claimsJson, &claims); err != nil {
+		return JwtClaims{}, ErrInvalidToken
+	}
+	if err := a.checkClaims(claims, now); err != nil {
+		return JwtClaims{}, err
+	}
+	return claims, nil
+}
+
+func (a *JwtAuthenticator) checkClaims(claims JwtClaims, now time.Time) error {
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(a.leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(a.leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if a.audience != "" && !claims.Audience.contains(a.audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	return nil
+}
+
+// Authenticates requests by the "Authorization: Bearer" header
+func (a *JwtAuthenticator) Authenticate(req *http.Request) (*Identity, error) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	claims, err := a.Verify(strings.TrimSpace(authorization[7:]), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	return &Identity{Subject: claims.Subject, Name: name, Method: "jwt", Roles: claims.Roles}, nil
+}
+
+func (a *JwtAuthenticator) Challenge() string {
+	return `Bearer realm="contacts"`
+}
+
+var _ Authenticator = (*JwtAuthenticator)(nil)
@@ -0,0 +1,196 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// Signs claims with key of the algorithm - HMAC secret, RSA or Ed25519 private key
+func signJwt(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims(changes map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": "jane",
+		"iss": "https://sso.example.com",
+		"aud": []string{"contacts"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"nbf": time.Now().Add(-time.Minute).Unix(),
+	}
+	for claim, value := range changes {
+		if value == nil {
+			delete(claims, claim)
+		} else {
+			claims[claim] = value
+		}
+	}
+	return claims
+}
+
+func TestJwtVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	authenticator := server.NewJwtAuthenticator([]server.JwtKey{
+		{Id: "hs", Algorithm: server.JwtHS256, Key: hmacSecret},
+		{Id: "rs", Algorithm: server.JwtRS256, Key: &rsaKey.PublicKey},
+		{Id: "ed", Algorithm: server.JwtEdDSA, Key: edPublic},
+	}).WithIssuer("https://sso.example.com").WithAudience("contacts")
+
+	otherRsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"HS256", signJwt(t, "HS256", "hs", hmacSecret, validClaims(nil)), nil},
+		{"RS256", signJwt(t, "RS256", "rs", rsaKey, validClaims(nil)), nil},
+		{"EdDSA", signJwt(t, "EdDSA", "ed", edPrivate, validClaims(nil)), nil},
+		{"without kid", signJwt(t, "EdDSA", "", edPrivate, validClaims(nil)), nil},
+		{"single audience", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"aud": "contacts"})), nil},
+		{"unknown key", signJwt(t, "RS256", "rs", otherRsaKey, validClaims(nil)), server.ErrInvalidToken},
+		{"algorithm of other key", signJwt(t, "HS256", "rs", hmacSecret, validClaims(nil)), server.ErrInvalidToken},
+		{"expired", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), server.ErrTokenExpired},
+		{"expired within leeway", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"exp": time.Now().Add(-10 * time.Second).Unix()})), nil},
+		{"without exp", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"exp": nil})), server.ErrInvalidToken},
+		{"not valid yet", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})), server.ErrInvalidToken},
+		{"other issuer", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"iss": "https://evil.example.com"})), server.ErrInvalidToken},
+		{"other audience", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"aud": []string{"billing"}})), server.ErrInvalidToken},
+		{"without sub", signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"sub": nil})), server.ErrInvalidToken},
+		{"unsigned", strings.TrimRight(signJwt(t, "none", "", []byte{}, validClaims(nil)), "."), server.ErrInvalidToken},
+		{"malformed", "not.a.token", server.ErrInvalidToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := authenticator.Verify(test.token, time.Now())
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "jane", claims.Subject)
+		})
+	}
+}
+
+func TestLoadJwtKeys(t *testing.T) {
+	dir := t.TempDir()
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	pemPath := filepath.Join(dir, "sso.pem")
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	keys, err := server.LoadJwtKeys(pemPath)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "sso", keys[0].Id)
+	assert.Equal(t, server.JwtEdDSA, keys[0].Algorithm)
+
+	jwksPath := filepath.Join(dir, "jwks.json")
+	jwks := `{"keys":[
+		{"kty":"OKP","crv":"Ed25519","kid":"ed","x":"` + base64.RawURLEncoding.EncodeToString(edPublic) + `"},
+		{"kty":"oct","kid":"hs","alg":"HS256","k":"` + base64.RawURLEncoding.EncodeToString(hmacSecret) + `"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"}
+	]}`
+	require.NoError(t, os.WriteFile(jwksPath, []byte(jwks), 0600))
+	keys, err = server.LoadJwtKeys(jwksPath)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	_, err = server.NewJwtAuthenticator(keys).Verify(signJwt(t, "EdDSA", "ed", edPrivate, validClaims(nil)), time.Now())
+	assert.NoError(t, err)
+
+	secretPath := filepath.Join(dir, "short.secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("short\n"), 0600))
+	_, err = server.LoadJwtKeys(secretPath)
+	assert.Error(t, err)
+}
+
+func TestRestJwtAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, auditPath)
+	require.NoError(t, err)
+	authenticator := server.NewJwtAuthenticator([]server.JwtKey{{Id: "hs", Algorithm: server.JwtHS256, Key: hmacSecret}})
+	// Roles of tokens count once the policy trusts them
+	policy := server.DefaultPolicy()
+	policy.TokenRoles = true
+	testServer := httptest.NewServer(restServer.WithAuthenticator(authenticator).WithPolicy(policy).Handler())
+	t.Cleanup(testServer.Close)
+
+	withToken := func(method string, path string, token string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	expired := signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}))
+	resp := withToken("GET", "/v1/contacts", expired)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="contacts"`, resp.Header.Get("WWW-Authenticate"))
+
+	token := signJwt(t, "HS256", "hs", hmacSecret, validClaims(map[string]interface{}{"name": "Jane Doe", "roles": []string{server.RoleEditor}}))
+	resp = withToken("DELETE", "/v1/contacts/1", token)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	events := readAuditEvents(t, auditPath)
+	require.Len(t, events, 2)
+	assert.Equal(t, "GET /v1/contacts", events[0].Op)
+	assert.Equal(t, server.AuditDenied, events[0].Outcome)
+	assert.Nil(t, events[0].Actor)
+	assert.Equal(t, "deleteById", events[1].Op)
+	assert.Equal(t, &server.Identity{Subject: "jane", Name: "Jane Doe", Method: "jwt", Roles: []string{server.RoleEditor}}, events[1].Actor)
+}
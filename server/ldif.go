@@ -0,0 +1,448 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LdifContentType = "text/x-ldif"
+
+	DefaultLdifBaseDn = "ou=contacts,dc=example,dc=com"
+
+	LdifChangeAdd    = "add"
+	LdifChangeDelete = "delete"
+	LdifChangeModify = "modify"
+
+	// Maximum line length before folding, as recommended by RFC 2849
+	ldifLineLimit = 76
+)
+
+type LdifModification struct {
+	// One of "add", "delete" or "replace"
+	Op        string
+	Attribute string
+	Values    []string
+}
+
+// Single LDIF record - either content record (ChangeType "add") or change record
+type LdifRecord struct {
+	Dn         string
+	ChangeType string
+	// Attributes of content and "add" records, keyed by lower case name
+	Attributes    map[string][]string
+	Modifications []LdifModification
+}
+
+type LdifRecordError struct {
+	// Index of the record in the file, starting from 1
+	Record int    `json:"record"`
+	Dn     string `json:"dn"`
+	Error  string `json:"error"`
+}
+
+type LdifImportReport struct {
+	Added    int               `json:"added"`
+	Modified int               `json:"modified"`
+	Deleted  int               `json:"deleted"`
+	Failed   int               `json:"failed"`
+	Errors   []LdifRecordError `json:"errors"`
+	// Ids of deleted contacts, so callers can drop state kept per contact
+	DeletedIds []int `json:"-"`
+}
+
+// Maps inetOrgPerson attributes to contact fields
+var ldifStringAttributes = map[string]func(c *Contact) *string{
+	"givenname":     func(c *Contact) *string { return &c.Name },
+	"sn":            func(c *Contact) *string { return &c.LastName },
+	"mail":          func(c *Contact) *string { return &c.Email },
+	"postaladdress": func(c *Contact) *string { return &c.Address },
+	"description":   func(c *Contact) *string { return &c.Notes },
+}
+
+// Reads lines with folded continuation lines joined and comments removed. Empty string marks end of record
+func unfoldLdifLines(r io.Reader) ([]string, error) {
+	var lines []string
+	inComment := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, " ") {
+			if inComment {
+				continue
+			}
+			if len(lines) == 0 || lines[len(lines)-1] == "" {
+				return nil, errors.New("continuation line without preceding line")
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		inComment = strings.HasPrefix(line, "#")
+		if inComment {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return append(lines, ""), scanner.Err()
+}
+
+func parseLdifLine(line string) (string, string, error) {
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return "", "", fmt.Errorf("invalid LDIF line %q", line)
+	}
+
+	// Attribute options, ie. "cn;lang-en", are ignored
+	name := strings.ToLower(strings.SplitN(line[:colon], ";", 2)[0])
+	rest := line[colon+1:]
+
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value of %q: %w", name, err)
+		}
+		return name, string(value), nil
+	case strings.HasPrefix(rest, "<"):
+		return "", "", fmt.Errorf("URL values are not supported (%q)", name)
+	default:
+		return name, strings.TrimLeft(rest, " "), nil
+	}
+}
+
+func parseLdifRecord(lines []string) (*LdifRecord, error) {
+	name, dn, err := parseLdifLine(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if name != "dn" {
+		return nil, errors.New("record does not start with dn")
+	}
+
+	record := &LdifRecord{Dn: dn, ChangeType: LdifChangeAdd, Attributes: make(map[string][]string)}
+	lines = lines[1:]
+
+	if len(lines) > 0 {
+		if name, value, err := parseLdifLine(lines[0]); err == nil && name == "control" {
+			return nil, errors.New("controls are not supported")
+		} else if err == nil && name == "changetype" {
+			record.ChangeType = strings.ToLower(value)
+			lines = lines[1:]
+		}
+	}
+
+	switch record.ChangeType {
+	case LdifChangeAdd:
+		for _, line := range lines {
+			name, value, err := parseLdifLine(line)
+			if err != nil {
+				return nil, err
+			}
+			record.Attributes[name] = append(record.Attributes[name], value)
+		}
+	case LdifChangeDelete:
+		if len(lines) > 0 {
+			return nil, errors.New("unexpected attributes in delete record")
+		}
+	case LdifChangeModify:
+		var mod *LdifModification
+		for _, line := range lines {
+			if line == "-" {
+				if mod == nil {
+					return nil, errors.New("unexpected modification separator")
+				}
+				record.Modifications = append(record.Modifications, *mod)
+				mod = nil
+				continue
+			}
+
+			name, value, err := parseLdifLine(line)
+			if err != nil {
+				return nil, err
+			}
+			if mod == nil {
+				if name != "add" && name != "delete" && name != "replace" {
+					return nil, fmt.Errorf("unknown modification %q", name)
+				}
+				mod = &LdifModification{Op: name, Attribute: strings.ToLower(value)}
+				continue
+			}
+			if name != mod.Attribute {
+				return nil, fmt.Errorf("attribute %q does not match modification of %q", name, mod.Attribute)
+			}
+			mod.Values = append(mod.Values, value)
+		}
+		if mod != nil {
+			return nil, errors.New("modification not terminated by separator")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported changetype %q", record.ChangeType)
+	}
+
+	return record, nil
+}
+
+// Reads all records of an LDIF file. The version line is optional
+func ReadLdif(r io.Reader) ([]LdifRecord, error) {
+	lines, err := unfoldLdifLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []LdifRecord
+	var current []string
+	for _, line := range lines {
+		if line != "" {
+			current = append(current, line)
+			continue
+		}
+		if len(current) == 0 {
+			continue
+		}
+
+		if len(records) == 0 && strings.HasPrefix(current[0], "version:") {
+			if strings.TrimSpace(strings.TrimPrefix(current[0], "version:")) != "1" {
+				return nil, fmt.Errorf("unsupported LDIF %s", current[0])
+			}
+			current = current[1:]
+			if len(current) == 0 {
+				continue
+			}
+		}
+
+		record, err := parseLdifRecord(current)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, *record)
+		current = nil
+	}
+
+	return records, nil
+}
+
+// Returns contact id from the "uid" component of the dn
+func (r *LdifRecord) ContactId() (int, error) {
+	rdn := strings.SplitN(r.Dn, ",", 2)[0]
+	parts := strings.SplitN(rdn, "=", 2)
+	if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "uid") {
+		return 0, fmt.Errorf("dn %q is not identified by uid", r.Dn)
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, fmt.Errorf("dn %q has invalid uid", r.Dn)
+	}
+	return id, nil
+}
+
+func applyLdifAttribute(contact *Contact, attribute string, values []string) {
+	if attribute == "telephonenumber" {
+		contact.Phones = append([]string(nil), values...)
+		return
+	}
+	if get, ok := ldifStringAttributes[attribute]; ok {
+		value := ""
+		if len(values) > 0 {
+			value = values[0]
+		}
+		*get(contact) = value
+	}
+}
+
+// Builds contact from attributes of a content or "add" record. Id is not set
+func (r *LdifRecord) Contact() Contact {
+	var contact Contact
+	for attribute, values := range r.Attributes {
+		applyLdifAttribute(&contact, attribute, values)
+	}
+
+	// Fallback for entries without givenName
+	if cn := r.Attributes["cn"]; contact.Name == "" && len(cn) > 0 {
+		contact.Name = strings.TrimSpace(strings.TrimSuffix(cn[0], contact.LastName))
+	}
+	return contact
+}
+
+// Applies modifications of a "modify" record to the contact
+func (r *LdifRecord) Apply(contact *Contact) {
+	for _, mod := range r.Modifications {
+		if mod.Attribute == "telephonenumber" {
+			switch mod.Op {
+			case "add":
+				contact.Phones = append(contact.Phones, mod.Values...)
+			case "replace":
+				contact.Phones = append([]string(nil), mod.Values...)
+			case "delete":
+				if len(mod.Values) == 0 {
+					contact.Phones = nil
+					continue
+				}
+				var phones []string
+				for _, phone := range contact.Phones {
+					deleted := false
+					for _, value := range mod.Values {
+						deleted = deleted || phone == value
+					}
+					if !deleted {
+						phones = append(phones, phone)
+					}
+				}
+				contact.Phones = phones
+			}
+			continue
+		}
+
+		if mod.Op == "delete" {
+			applyLdifAttribute(contact, mod.Attribute, nil)
+		} else {
+			applyLdifAttribute(contact, mod.Attribute, mod.Values)
+		}
+	}
+}
+
+func applyLdifRecord(db ContactDatabase, record LdifRecord) error {
+	if record.ChangeType == LdifChangeAdd {
+		contact := record.Contact()
+		if err := contact.Validate(); err != nil {
+			return err
+		}
+		db.InsertWithNewId(contact)
+		return nil
+	}
+
+	id, err := record.ContactId()
+	if err != nil {
+		return err
+	}
+
+	if record.ChangeType == LdifChangeDelete {
+		if !db.Delete(Contact{Id: id}) {
+			return errors.New("contact not found")
+		}
+		return nil
+	}
+
+	contact := db.FindById(id)
+	if contact == nil {
+		return errors.New("contact not found")
+	}
+	record.Apply(contact)
+	if err := contact.Validate(); err != nil {
+		return err
+	}
+	if !db.Update(*contact) {
+		return errors.New("contact not found")
+	}
+	return nil
+}
+
+// Applies records one by one - content and "add" records create new contacts, change records
+// address existing contacts by uid. Failing records are reported and skipped
+func ApplyLdif(db ContactDatabase, records []LdifRecord) LdifImportReport {
+	report := LdifImportReport{Errors: []LdifRecordError{}}
+
+	for i, record := range records {
+		if err := applyLdifRecord(db, record); err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, LdifRecordError{Record: i + 1, Dn: record.Dn, Error: err.Error()})
+			continue
+		}
+
+		switch record.ChangeType {
+		case LdifChangeAdd:
+			report.Added++
+		case LdifChangeModify:
+			report.Modified++
+		case LdifChangeDelete:
+			report.Deleted++
+			id, _ := record.ContactId()
+			report.DeletedIds = append(report.DeletedIds, id)
+		}
+	}
+
+	return report
+}
+
+func ldifNeedsBase64(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value[0] == ' ' || value[0] == ':' || value[0] == '<' || value[len(value)-1] == ' ' {
+		return true
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] == 0 || value[i] == '\r' || value[i] == '\n' || value[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+func writeLdifAttribute(w *bufio.Writer, name string, value string) {
+	line := name + ": " + value
+	if ldifNeedsBase64(value) {
+		line = name + ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	for len(line) > ldifLineLimit {
+		w.WriteString(line[:ldifLineLimit])
+		w.WriteString("\n ")
+		line = line[ldifLineLimit:]
+	}
+	w.WriteString(line)
+	w.WriteString("\n")
+}
+
+// Writes contacts as inetOrgPerson content records under given base dn
+func WriteLdif(w io.Writer, contacts []Contact, baseDn string) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("version: 1\n")
+
+	for _, contact := range contacts {
+		buf.WriteString("\n")
+		writeLdifAttribute(buf, "dn", "uid="+strconv.Itoa(contact.Id)+","+baseDn)
+		for _, objectClass := range []string{"top", "person", "organizationalPerson", "inetOrgPerson"} {
+			writeLdifAttribute(buf, "objectClass", objectClass)
+		}
+		writeLdifAttribute(buf, "uid", strconv.Itoa(contact.Id))
+		writeLdifAttribute(buf, "cn", strings.TrimSpace(contact.Name+" "+contact.LastName))
+
+		attributes := make([]string, 0, len(ldifStringAttributes))
+		for attribute := range ldifStringAttributes {
+			attributes = append(attributes, attribute)
+		}
+		sort.Strings(attributes)
+		for _, attribute := range attributes {
+			if value := *ldifStringAttributes[attribute](&contact); value != "" {
+				writeLdifAttribute(buf, ldifAttributeNames[attribute], value)
+			}
+		}
+		for _, phone := range contact.Phones {
+			writeLdifAttribute(buf, "telephoneNumber", phone)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// Canonical spelling of attribute names for writing
+var ldifAttributeNames = map[string]string{
+	"givenname":     "givenName",
+	"sn":            "sn",
+	"mail":          "mail",
+	"postaladdress": "postalAddress",
+	"description":   "description",
+}
@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLdifRoundTrip(t *testing.T) {
+	contacts := []server.Contact{
+		{Id: 1, Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com",
+			Phones: []string{"111", "222"}, Address: "251 Menlove Avenue", Notes: "Żółć\nsecond line " + strings.Repeat("x", 100)},
+		{Id: 2, Name: "Paul", LastName: "McCartney", Email: "paul.mccartney@thebeatles.com"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, server.WriteLdif(&buf, contacts, server.DefaultLdifBaseDn))
+	for _, line := range strings.Split(buf.String(), "\n") {
+		assert.LessOrEqual(t, len(line), 77, "line not folded")
+	}
+	assert.Contains(t, buf.String(), "dn: uid=2,ou=contacts,dc=example,dc=com\n")
+	assert.Contains(t, buf.String(), "description:: ")
+
+	records, err := server.ReadLdif(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(records))
+
+	for i, record := range records {
+		assert.Equal(t, server.LdifChangeAdd, record.ChangeType)
+		id, err := record.ContactId()
+		require.NoError(t, err)
+		assert.Equal(t, contacts[i].Id, id)
+
+		expected := contacts[i]
+		expected.Id = 0
+		assert.Equal(t, expected, record.Contact())
+	}
+}
+
+func TestApplyLdifChangeRecords(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+
+	data := `version: 1
+
+# Add Pete
+dn: cn=Pete Best,ou=contacts,dc=example,dc=com
+changetype: add
+objectClass: inetOrgPerson
+cn: Pete Best
+sn: Best
+mail: pete.best@thebeatles.com
+
+dn: uid=1,ou=contacts,dc=example,dc=com
+changetype: modify
+replace: mail
+mail: john@thebeatles.com
+-
+add: telephoneNumber
+telephoneNumber: 111
+telephoneNumber: 222
+-
+
+dn: uid=2,ou=contacts,dc=example,dc=com
+changetype: delete
+
+dn: uid=42,ou=contacts,dc=example,dc=com
+changetype: delete
+`
+
+	records, err := server.ReadLdif(strings.NewReader(data))
+	require.NoError(t, err)
+
+	report := server.ApplyLdif(db, records)
+	assert.Equal(t, 1, report.Added)
+	assert.Equal(t, 1, report.Modified)
+	assert.Equal(t, 1, report.Deleted)
+	assert.Equal(t, []int{2}, report.DeletedIds)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, []server.LdifRecordError{
+		{Record: 4, Dn: "uid=42,ou=contacts,dc=example,dc=com", Error: "contact not found"},
+	}, report.Errors)
+
+	assert.Nil(t, db.FindById(2))
+	john := db.FindById(1)
+	require.NotNil(t, john)
+	assert.Equal(t, "john@thebeatles.com", john.Email)
+	assert.Equal(t, []string{"111", "222"}, john.Phones)
+
+	pete := db.FindByEmail("pete.best@thebeatles.com")
+	require.Equal(t, 1, len(pete))
+	assert.Equal(t, "Pete", pete[0].Name)
+}
+
+func TestReadLdifInvalid(t *testing.T) {
+	inputs := []string{
+		"version: 2\n\ndn: uid=1\n",
+		"cn: no dn\n",
+		"dn: uid=1\nchangetype: modrdn\nnewrdn: uid=2\n",
+		"dn: uid=1\nchangetype: modify\nreplace: mail\nmail: x\n",
+		"dn: uid=1\njpegPhoto:< file:///tmp/photo.jpg\n",
+		"dn: uid=1\nmail:: !!!\n",
+	}
+
+	for _, input := range inputs {
+		_, err := server.ReadLdif(strings.NewReader(input))
+		assert.Error(t, err, "input %q", input)
+	}
+}
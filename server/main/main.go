@@ -1,22 +1,239 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"strings"
 
 	"example.com/contacts/server"
 )
 
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
+	var fixtureFiles fileList
+	flag.Var(&fixtureFiles, "fixtures", "YAML or JSON file to seed contacts from, can be repeated (default: built-in fixtures)")
+	conflict := flag.String("fixtures-conflict", string(server.FixtureConflictFail), "handling of fixtures with existing or repeated ids: upsert, skip or fail")
+	dryRun := flag.Bool("fixtures-dry-run", false, "only report what fixtures would change and exit")
+	fieldKeyFile := flag.String("field-keyfile", "", "key file enabling encryption of email, phones, address and notes at rest")
+	rotateFieldKey := flag.Bool("rotate-field-key", false, "add a new active key to the field key file (created if missing)")
+	apiKeyFile := flag.String("api-keys", "", "file of hashed API keys, enables API key authentication")
+	var jwtKeyFiles fileList
+	flag.Var(&jwtKeyFiles, "jwt-keys", "JWKS (.json), PEM public key or HS256 secret file verifying bearer tokens, can be repeated")
+	jwtIssuer := flag.String("jwt-issuer", "", "required iss claim of bearer tokens")
+	jwtAudience := flag.String("jwt-audience", "", "required aud claim of bearer tokens")
+	var bookSpecs fileList
+	flag.Var(&bookSpecs, "book", "address book to create at startup as <name> or <name>=<fixtures file>, can be repeated")
+	policyFile := flag.String("policy", "", "YAML role policy enforced on every request (default: built-in viewer, editor and admin roles with everybody a viewer when authentication is enabled, otherwise everything is permitted. API keys created with -api-key-role admin are admins nonetheless)")
+	createApiKey := flag.String("create-api-key", "", "add an API key of given label to the API key file, print it and exit")
+	var apiKeyRoles fileList
+	flag.Var(&apiKeyRoles, "api-key-role", "role granted to the key added by -create-api-key whatever the policy says, e.g. admin for managing keys through the API, can be repeated")
+	auditFile := flag.String("audit-log", "./audit.log", "hash-chained audit log file")
+	auditKeyFile := flag.String("audit-key", "", "secret file of at least 32 bytes signing audit log checkpoints")
+	auditCheckpoints := flag.Int("audit-checkpoint-interval", server.DefaultAuditCheckpointInterval, "audit records between signed checkpoints")
+	auditMaxSize := flag.Int64("audit-max-size", 0, "rotate the audit log when it reaches this many bytes (default: never)")
+	auditMaxAge := flag.Duration("audit-max-age", 0, "rotate the audit log after this long, e.g. 24h (default: never)")
+	auditRetention := flag.Duration("audit-retention", 0, "delete rotated audit segments older than this, e.g. 2160h (default: keep)")
+	auditMaxSegments := flag.Int("audit-max-segments", 0, "keep at most this many rotated audit segments (default: all)")
+	auditStdout := flag.Bool("audit-stdout", false, "also write audit events as JSON lines to stdout")
+	auditSinkFile := flag.String("audit-file", "", "also append audit events as JSON lines to this file")
+	auditSyslog := flag.String("audit-syslog", "", "also send audit events to a syslog collector, e.g. udp://127.0.0.1:514 or tcp://127.0.0.1:601")
+	var auditWebhooks fileList
+	flag.Var(&auditWebhooks, "audit-webhook", "also post audit events as JSON to this URL, can be repeated")
+	auditBuffer := flag.Int("audit-buffer", server.DefaultAuditBufferSize, "audit events buffered per sink before dropping them")
+	verifyAudit := flag.Bool("verify-audit", false, "verify the audit log, report the first tampered or missing record and exit, with status 2 when no checkpoint signature proves it")
+	flag.Parse()
+
 	fmt.Println("Contacts API server")
-	db := server.NewMemoryDatabase()
-	if err := db.LoadFixtures(); err != nil {
-		log.Fatal(err)
+
+	var auditKey []byte
+	if *auditKeyFile != "" {
+		var err error
+		if auditKey, err = server.LoadAuditKey(*auditKeyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *verifyAudit {
+		verification, err := server.VerifyAuditLog(*auditFile, auditKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !verification.Signed() {
+			fmt.Printf("Audit log unsigned: integrity not proven, %d records from seq %d without a verified checkpoint signature\n", verification.Records, verification.FirstSeq)
+			if auditKey == nil {
+				fmt.Println("Pass -audit-key to verify checkpoint signatures")
+			}
+			os.Exit(2)
+		}
+		fmt.Printf("Audit log intact: %d records from seq %d, %d checkpoints\n", verification.Records, verification.FirstSeq, verification.Checkpoints)
+		if verification.PrunedThrough > 0 {
+			fmt.Printf("Records up to seq %d deleted by retention\n", verification.PrunedThrough)
+		}
+		fmt.Printf("Last signed checkpoint at seq %d\n", verification.LastCheckpoint)
+		os.Exit(0)
+	}
+
+	var apiKeys *server.ApiKeyStore
+	if *apiKeyFile != "" {
+		var err error
+		if apiKeys, err = server.NewApiKeyStore(*apiKeyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *createApiKey != "" {
+		if apiKeys == nil {
+			log.Fatal("-create-api-key requires -api-keys")
+		}
+		created, err := apiKeys.Create(*createApiKey, apiKeyRoles...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("API key %s (%s) created with roles [%s], it is not shown again:\n%s\n", created.Id, created.Label, strings.Join(created.Roles, ", "), created.Key)
+		os.Exit(0)
+	}
+
+	memoryDb := server.NewMemoryDatabase()
+	var db server.ContactDatabase = memoryDb
+
+	var encryptedDb *server.EncryptedDatabase
+	var fieldKeys *server.FieldKeyRing
+	if *fieldKeyFile != "" {
+		if *rotateFieldKey {
+			keyId, err := server.RotateFieldKeyFile(*fieldKeyFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Field encryption key rotated, active key:", keyId)
+		}
+
+		var err error
+		if fieldKeys, err = server.LoadFieldKeyRing(*fieldKeyFile); err != nil {
+			log.Fatal(err)
+		}
+		encryptedDb = server.NewEncryptedDatabase(memoryDb, fieldKeys)
+		db = encryptedDb
 	}
 
-	server, err := server.NewRestServer(db, "./audit.log")
+	if len(fixtureFiles) == 0 {
+		if err := memoryDb.LoadFixtures(); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		report, err := server.LoadFixtureFiles(db, fixtureFiles, server.FixtureOptions{
+			Conflict: server.FixtureConflictMode(*conflict),
+			DryRun:   *dryRun,
+		})
+		fmt.Println("Fixtures:", report.String())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *dryRun {
+			os.Exit(0)
+		}
+	}
+
+	if encryptedDb != nil {
+		// Encrypts built-in fixtures and records sealed with previous keys
+		go func() {
+			log.Printf("Field encryption: re-encrypted %d contacts", encryptedDb.Reencrypt())
+		}()
+	}
+
+	restServer, err := server.NewRestServer(db, *auditFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	server.Start(8080)
+	if auditKey != nil {
+		restServer.WithAuditCheckpoints(auditKey, *auditCheckpoints)
+	} else {
+		log.Printf("Warning: audit log %s is not signed, so it is not tamper-evident. Pass -audit-key to sign checkpoints", *auditFile)
+	}
+	var auditSinks []server.AuditSink
+	if *auditStdout {
+		auditSinks = append(auditSinks, server.NewStdoutSink())
+	}
+	if *auditSinkFile != "" {
+		sink, err := server.NewFileSink(*auditSinkFile)
+		if err != nil {
+			log.Fatalf("Audit file %s: %s", *auditSinkFile, err)
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	if *auditSyslog != "" {
+		collector, err := url.Parse(*auditSyslog)
+		if err != nil {
+			log.Fatalf("Audit syslog %s: %s", *auditSyslog, err)
+		}
+		sink, err := server.NewSyslogSink(collector.Scheme, collector.Host)
+		if err != nil {
+			log.Fatalf("Audit syslog %s: %s", *auditSyslog, err)
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	for _, webhook := range auditWebhooks {
+		auditSinks = append(auditSinks, server.NewWebhookSink(webhook, nil))
+	}
+	if len(auditSinks) > 0 {
+		restServer.WithAuditSinks(*auditBuffer, auditSinks...)
+	}
+	restServer.WithAuditRotation(server.AuditRotation{
+		MaxSize:     *auditMaxSize,
+		MaxAge:      *auditMaxAge,
+		Retention:   *auditRetention,
+		MaxSegments: *auditMaxSegments,
+	})
+	if fieldKeys != nil {
+		restServer.WithBookDatabases(func(name string) (server.ContactDatabase, error) {
+			return server.NewEncryptedDatabase(server.NewMemoryDatabase(), fieldKeys), nil
+		})
+	}
+	for _, spec := range bookSpecs {
+		parts := strings.SplitN(spec, "=", 2)
+		if _, err := restServer.Books().Create(parts[0]); err != nil {
+			log.Fatalf("Book %s: %s", parts[0], err)
+		}
+		if len(parts) == 2 {
+			report, err := server.LoadFixtureFiles(restServer.Books().Database(parts[0]), []string{parts[1]}, server.FixtureOptions{
+				Conflict: server.FixtureConflictFail,
+			})
+			fmt.Printf("Fixtures of book %s: %s\n", parts[0], report.String())
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if apiKeys != nil {
+		restServer.WithApiKeys(apiKeys)
+	}
+	if len(jwtKeyFiles) > 0 {
+		var keys []server.JwtKey
+		for _, file := range jwtKeyFiles {
+			fileKeys, err := server.LoadJwtKeys(file)
+			if err != nil {
+				log.Fatalf("JWT keys %s: %s", file, err)
+			}
+			keys = append(keys, fileKeys...)
+		}
+		restServer.WithAuthenticator(server.NewJwtAuthenticator(keys).WithIssuer(*jwtIssuer).WithAudience(*jwtAudience))
+	}
+	if *policyFile != "" {
+		policy, err := server.LoadPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("Policy %s: %s", *policyFile, err)
+		}
+		restServer.WithPolicy(policy)
+	}
+	restServer.Start(8080)
 }
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"sort"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 )
 
 type MemoryDatabase struct {
+	mu        sync.RWMutex
 	data      map[int]Contact
 	highestId int
+	// Ids of data in ascending order, so pages by id don't sort all contacts
+	ids []int
 }
 
 var _ ContactDatabase = (*MemoryDatabase)(nil)
@@ -25,16 +30,29 @@ func (m *MemoryDatabase) hasContact(id int) bool {
 	return ok
 }
 
+func (m *MemoryDatabase) addId(id int) {
+	i := sort.SearchInts(m.ids, id)
+	m.ids = append(m.ids, 0)
+	copy(m.ids[i+1:], m.ids[i:])
+	m.ids[i] = id
+}
+
+func (m *MemoryDatabase) removeId(id int) {
+	if i := sort.SearchInts(m.ids, id); i < len(m.ids) && m.ids[i] == id {
+		m.ids = append(m.ids[:i], m.ids[i+1:]...)
+	}
+}
+
 func (m *MemoryDatabase) dataCopy() []Contact {
 	// Order is unspecified
 	var result []Contact
 	for _, contact := range m.data {
-		result = append(result, contact)
+		result = append(result, *contact.Clone())
 	}
 	return result
 }
 
-func (m *MemoryDatabase) Insert(contact Contact) bool {
+func (m *MemoryDatabase) insert(contact Contact) bool {
 	if m.hasContact(contact.Id) {
 		return false
 	}
@@ -42,39 +60,156 @@ func (m *MemoryDatabase) Insert(contact Contact) bool {
 		m.highestId = contact.Id
 	}
 
-	m.data[contact.Id] = contact
+	m.data[contact.Id] = *contact.Clone()
+	m.addId(contact.Id)
 	return true
 }
 
+func (m *MemoryDatabase) Insert(contact Contact) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insert(contact)
+}
+
 func (m *MemoryDatabase) InsertWithNewId(contact Contact) Contact {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	contact.Id = m.highestId + 1
-	m.Insert(contact)
+	m.insert(contact)
 	return contact
 }
 
 func (m *MemoryDatabase) Delete(contact Contact) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if !m.hasContact(contact.Id) {
 		return false
 	}
 
 	delete(m.data, contact.Id)
+	m.removeId(contact.Id)
 	return true
 }
 
 func (m *MemoryDatabase) Update(contact Contact) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if !m.hasContact(contact.Id) {
 		return false
 	}
 
-	m.data[contact.Id] = contact
+	m.data[contact.Id] = *contact.Clone()
+	return true
+}
+
+func (m *MemoryDatabase) UpdateIf(before Contact, after Contact) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.data[after.Id]
+	if !ok || before.Id != after.Id || !current.Equal(before) {
+		return false
+	}
+
+	m.data[after.Id] = *after.Clone()
+	return true
+}
+
+func (m *MemoryDatabase) Merge(merged []Contact, survivor Contact) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	survives := false
+	for _, contact := range merged {
+		current, ok := m.data[contact.Id]
+		if !ok || !current.Equal(contact) {
+			return false
+		}
+		survives = survives || contact.Id == survivor.Id
+	}
+	if !survives {
+		return false
+	}
+
+	for _, contact := range merged {
+		if contact.Id != survivor.Id {
+			delete(m.data, contact.Id)
+			m.removeId(contact.Id)
+		}
+	}
+	m.data[survivor.Id] = *survivor.Clone()
 	return true
 }
 
 func (m *MemoryDatabase) FindAll() []Contact {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.dataCopy()
 }
 
+func (m *MemoryDatabase) FindAfterId(afterId int, limit int) []Contact {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Searching for the first greater id, as afterId+1 overflows for the largest int
+	ids := m.ids[sort.Search(len(m.ids), func(i int) bool { return m.ids[i] > afterId }):]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	var result []Contact
+	for _, id := range ids {
+		contact := m.data[id]
+		result = append(result, *contact.Clone())
+	}
+	return result
+}
+
+func (m *MemoryDatabase) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.data)
+}
+
+func (m *MemoryDatabase) Snapshot() ([]Contact, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.dataCopy(), m.highestId
+}
+
+func (m *MemoryDatabase) Restore(contacts []Contact, highestId int) {
+	data := make(map[int]Contact)
+	var ids []int
+	for _, contact := range contacts {
+		if _, ok := data[contact.Id]; !ok {
+			ids = append(ids, contact.Id)
+		}
+		data[contact.Id] = *contact.Clone()
+		if contact.Id > highestId {
+			highestId = contact.Id
+		}
+	}
+	sort.Ints(ids)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data = data
+	m.highestId = highestId
+	m.ids = ids
+}
+
 func (m *MemoryDatabase) FindById(id int) *Contact {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if !m.hasContact(id) {
 		return nil
 	}
@@ -84,11 +219,14 @@ func (m *MemoryDatabase) FindById(id int) *Contact {
 }
 
 func (m *MemoryDatabase) FindByEmail(email string) []Contact {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	var result []Contact
 
 	for _, contact := range m.data {
 		if contact.Email == email {
-			result = append(result, contact)
+			result = append(result, *contact.Clone())
 		}
 	}
 
@@ -96,11 +234,14 @@ func (m *MemoryDatabase) FindByEmail(email string) []Contact {
 }
 
 func (m *MemoryDatabase) FindByLastNameContains(part string) []Contact {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	var result []Contact
 
 	for _, contact := range m.data {
 		if strings.Contains(contact.LastName, part) {
-			result = append(result, contact)
+			result = append(result, *contact.Clone())
 		}
 	}
 
@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"math"
 	"sort"
 	"testing"
 
@@ -87,6 +88,25 @@ func TestUpdateNoMatch(t *testing.T) {
 	assert.False(t, ret)
 }
 
+func TestUpdateIf(t *testing.T) {
+	db := createDatabaset(t)
+	before := server.Contact{Id: 1, Name: "Test", LastName: "test", Email: "test@test.com"}
+	require.True(t, db.Insert(before))
+
+	after := before
+	after.Phones = []string{"123"}
+	assert.True(t, db.UpdateIf(before, after))
+	assert.Equal(t, &after, db.FindById(1))
+
+	// Before is stale now
+	stale := before
+	stale.Name = "Stale"
+	assert.False(t, db.UpdateIf(before, stale))
+	assert.Equal(t, &after, db.FindById(1))
+
+	assert.False(t, db.UpdateIf(server.Contact{Id: 2}, server.Contact{Id: 2}))
+}
+
 func TestDeleteNormalAndNoMatch(t *testing.T) {
 	db := createDatabaset(t)
 	contact := server.Contact{
@@ -228,3 +248,78 @@ func TestFindByLastNameContains(t *testing.T) {
 	assert.Equal(t, contacts[0], ret[0])
 	assert.Equal(t, contacts[1], ret[1])
 }
+
+func TestMergeNormalAndNoMatch(t *testing.T) {
+	db := createDatabaset(t)
+	contacts := []server.Contact{
+		{Id: 1, Name: "Test", LastName: "test", Email: "test@test.com"},
+		{Id: 2, Name: "Test2", LastName: "test", Email: "test@test.com"},
+	}
+	db.Insert(contacts[0])
+	db.Insert(contacts[1])
+
+	survivor := contacts[0]
+	survivor.Name = "Merged"
+
+	ret := db.Merge(append(contacts, server.Contact{Id: 3}), survivor)
+	assert.False(t, ret, "wrong return value without match")
+	assert.Equal(t, 2, len(db.FindAll()), "database changed without match")
+
+	changed := append([]server.Contact{}, contacts...)
+	changed[1].Email = "changed@test.com"
+	ret = db.Merge(changed, survivor)
+	assert.False(t, ret, "wrong return value with changed contact")
+	assert.Equal(t, 2, len(db.FindAll()), "database changed with changed contact")
+
+	ret = db.Merge(contacts, survivor)
+	assert.True(t, ret, "wrong return value with match")
+	assert.Equal(t, []server.Contact{survivor}, db.FindAll())
+}
+
+func TestFindAfterIdAndCount(t *testing.T) {
+	db := createDatabaset(t)
+	for _, id := range []int{5, 2, 9, 7} {
+		db.Insert(server.Contact{Id: id, Name: "Test", LastName: "test", Email: "test@test.com"})
+	}
+
+	assert.Equal(t, 4, db.Count())
+
+	ids := func(contacts []server.Contact) []int {
+		result := []int{}
+		for _, contact := range contacts {
+			result = append(result, contact.Id)
+		}
+		return result
+	}
+	assert.Equal(t, []int{2, 5}, ids(db.FindAfterId(0, 2)))
+	assert.Equal(t, []int{7, 9}, ids(db.FindAfterId(5, 10)))
+	assert.Equal(t, []int{}, ids(db.FindAfterId(9, 10)))
+	assert.Equal(t, []int{}, ids(db.FindAfterId(math.MaxInt64, 10)))
+
+	db.Delete(server.Contact{Id: 5})
+	db.Merge([]server.Contact{*db.FindById(7), *db.FindById(9)}, *db.FindById(9))
+	assert.Equal(t, []int{2, 9}, ids(db.FindAfterId(0, 10)))
+
+	contacts, highestId := db.Snapshot()
+	db.Restore(append(contacts, server.Contact{Id: 4, Name: "Test", LastName: "test", Email: "test@test.com"}), highestId)
+	assert.Equal(t, []int{4, 9}, ids(db.FindAfterId(2, 10)))
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	db := createDatabaset(t)
+	contact := server.Contact{Id: 1, Name: "Test", LastName: "test", Email: "test@test.com"}
+	db.Insert(contact)
+	db.InsertWithNewId(contact)
+	db.Delete(server.Contact{Id: 2})
+
+	contacts, highestId := db.Snapshot()
+	assert.Equal(t, []server.Contact{contact}, contacts)
+	assert.Equal(t, 2, highestId)
+
+	other := createDatabaset(t)
+	other.InsertWithNewId(contact)
+	other.Restore(contacts, highestId)
+
+	assert.Equal(t, []server.Contact{contact}, other.FindAll())
+	assert.Equal(t, 3, other.InsertWithNewId(contact).Id, "id counter not restored")
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type MergeStrategy string
+
+const (
+	// Value of the most recently created contact, ie. the one with highest id
+	MergeKeepNewest MergeStrategy = "newest"
+	// Longest value (or list with most entries)
+	MergeKeepLongest MergeStrategy = "longest"
+	// All distinct values of all contacts - only applicable to list fields
+	MergeUnion MergeStrategy = "union"
+	// Value of the contact given by FieldResolution.SourceId
+	MergeChoose MergeStrategy = "choose"
+)
+
+type FieldResolution struct {
+	Strategy MergeStrategy `json:"strategy" yaml:"strategy"`
+	SourceId int           `json:"sourceId,omitempty" yaml:"sourceId,omitempty"`
+}
+
+type MergeRequest struct {
+	Ids []int `json:"ids" yaml:"ids"`
+	// Id of the contact which survives the merge, defaults to the lowest id
+	SurvivorId int `json:"survivorId,omitempty" yaml:"survivorId,omitempty"`
+	// Resolution per field name (as in json tags). Missing fields default to
+	// "newest" for single values and "union" for lists
+	Fields map[string]FieldResolution `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+var mergeStringFields = map[string]func(c *Contact) *string{
+	"name":     func(c *Contact) *string { return &c.Name },
+	"lastName": func(c *Contact) *string { return &c.LastName },
+	"email":    func(c *Contact) *string { return &c.Email },
+	"address":  func(c *Contact) *string { return &c.Address },
+	"notes":    func(c *Contact) *string { return &c.Notes },
+}
+
+var mergeListFields = map[string]func(c *Contact) *[]string{
+	"phones": func(c *Contact) *[]string { return &c.Phones },
+}
+
+func (r *MergeRequest) Validate() error {
+	if len(r.Ids) < 2 {
+		return errors.New("at least two contacts are required for merge")
+	}
+
+	ids := make(map[int]bool)
+	for _, id := range r.Ids {
+		if ids[id] {
+			return fmt.Errorf("duplicate id %d", id)
+		}
+		ids[id] = true
+	}
+	if r.SurvivorId != 0 && !ids[r.SurvivorId] {
+		return fmt.Errorf("survivor %d is not one of merged contacts", r.SurvivorId)
+	}
+
+	for field, resolution := range r.Fields {
+		_, isString := mergeStringFields[field]
+		_, isList := mergeListFields[field]
+		if !isString && !isList {
+			return fmt.Errorf("unknown field %q", field)
+		}
+
+		switch resolution.Strategy {
+		case MergeKeepNewest, MergeKeepLongest:
+		case MergeUnion:
+			if !isList {
+				return fmt.Errorf("union strategy is not applicable to field %q", field)
+			}
+		case MergeChoose:
+			if !ids[resolution.SourceId] {
+				return fmt.Errorf("source %d of field %q is not one of merged contacts", resolution.SourceId, field)
+			}
+		default:
+			return fmt.Errorf("unknown strategy %q for field %q", resolution.Strategy, field)
+		}
+	}
+
+	return nil
+}
+
+func (r *MergeRequest) resolution(field string, defaultStrategy MergeStrategy) FieldResolution {
+	if resolution, ok := r.Fields[field]; ok {
+		return resolution
+	}
+	return FieldResolution{Strategy: defaultStrategy}
+}
+
+// Combines given contacts into the survivor according to the request. Contacts must match request ids
+func MergeContacts(contacts []Contact, req MergeRequest) (Contact, error) {
+	if err := req.Validate(); err != nil {
+		return Contact{}, err
+	}
+	if len(contacts) != len(req.Ids) {
+		return Contact{}, errors.New("contacts do not match merged ids")
+	}
+
+	// Oldest first, so that the last one is the newest
+	sorted := make([]Contact, len(contacts))
+	copy(sorted, contacts)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	byId := make(map[int]*Contact)
+	for i := range sorted {
+		byId[sorted[i].Id] = &sorted[i]
+	}
+
+	survivorId := req.SurvivorId
+	if survivorId == 0 {
+		survivorId = sorted[0].Id
+	}
+	survivor, ok := byId[survivorId]
+	if !ok {
+		return Contact{}, errors.New("contacts do not match merged ids")
+	}
+	merged := survivor.Clone()
+
+	for field, get := range mergeStringFields {
+		resolution := req.resolution(field, MergeKeepNewest)
+		switch resolution.Strategy {
+		case MergeKeepNewest:
+			*get(merged) = *get(&sorted[len(sorted)-1])
+		case MergeKeepLongest:
+			longest := ""
+			for i := range sorted {
+				if value := *get(&sorted[i]); len(value) > len(longest) {
+					longest = value
+				}
+			}
+			*get(merged) = longest
+		case MergeChoose:
+			*get(merged) = *get(byId[resolution.SourceId])
+		}
+	}
+
+	for field, get := range mergeListFields {
+		resolution := req.resolution(field, MergeUnion)
+		var value []string
+		switch resolution.Strategy {
+		case MergeKeepNewest:
+			value = *get(&sorted[len(sorted)-1])
+		case MergeKeepLongest:
+			for i := range sorted {
+				if list := *get(&sorted[i]); len(list) > len(value) {
+					value = list
+				}
+			}
+		case MergeUnion:
+			seen := make(map[string]bool)
+			for i := range sorted {
+				for _, item := range *get(&sorted[i]) {
+					if !seen[item] {
+						seen[item] = true
+						value = append(value, item)
+					}
+				}
+			}
+		case MergeChoose:
+			value = *get(byId[resolution.SourceId])
+		}
+		*get(merged) = append([]string(nil), value...)
+	}
+
+	return *merged, nil
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mergeFixtures() []server.Contact {
+	return []server.Contact{
+		{Id: 1, Name: "John", LastName: "Lennon", Email: "john@thebeatles.com", Phones: []string{"111"}},
+		{Id: 3, Name: "Jon", LastName: "Lennon", Email: "john.lennon@thebeatles.com", Phones: []string{"222", "111"}},
+	}
+}
+
+func TestMergeDefaults(t *testing.T) {
+	merged, err := server.MergeContacts(mergeFixtures(), server.MergeRequest{Ids: []int{3, 1}})
+	require.NoError(t, err)
+
+	assert.Equal(t, server.Contact{
+		Id:       1,
+		Name:     "Jon",
+		LastName: "Lennon",
+		Email:    "john.lennon@thebeatles.com",
+		Phones:   []string{"111", "222"},
+	}, merged)
+}
+
+func TestMergeFieldStrategies(t *testing.T) {
+	merged, err := server.MergeContacts(mergeFixtures(), server.MergeRequest{
+		Ids:        []int{1, 3},
+		SurvivorId: 3,
+		Fields: map[string]server.FieldResolution{
+			"name":   {Strategy: server.MergeKeepLongest},
+			"email":  {Strategy: server.MergeChoose, SourceId: 1},
+			"phones": {Strategy: server.MergeKeepNewest},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, merged.Id)
+	assert.Equal(t, "John", merged.Name)
+	assert.Equal(t, "john@thebeatles.com", merged.Email)
+	assert.Equal(t, []string{"222", "111"}, merged.Phones)
+}
+
+func TestMergeInvalidRequests(t *testing.T) {
+	requests := []server.MergeRequest{
+		{Ids: []int{1}},
+		{Ids: []int{1, 1}},
+		{Ids: []int{1, 3}, SurvivorId: 2},
+		{Ids: []int{1, 3}, Fields: map[string]server.FieldResolution{"unknown": {Strategy: server.MergeKeepNewest}}},
+		{Ids: []int{1, 3}, Fields: map[string]server.FieldResolution{"email": {Strategy: server.MergeUnion}}},
+		{Ids: []int{1, 3}, Fields: map[string]server.FieldResolution{"email": {Strategy: server.MergeChoose, SourceId: 2}}},
+	}
+
+	for _, req := range requests {
+		_, err := server.MergeContacts(mergeFixtures(), req)
+		assert.Error(t, err, "request %+v", req)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+const (
+	NdjsonContentType = "application/x-ndjson"
+
+	// Contacts read from the database at once during export
+	ndjsonPageSize = 100
+	// Import reports progress after this many lines
+	ndjsonProgressInterval = 100
+	// Longest accepted line of import
+	ndjsonMaxLineSize = 1024 * 1024
+	// Limits number of failure events returned by the REST import
+	maxNdjsonReportedFailures = 100
+)
+
+// Import progress, emitted periodically, for each failed line and once at the end
+type NdjsonImportProgress struct {
+	Processed int `json:"processed"`
+	Imported  int `json:"imported"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	// Highest id imported so far - an import of contacts with ids can be resumed after it
+	LastId int `json:"lastId"`
+
+	// Set for events reporting a failed line
+	Line  int    `json:"line,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	Done bool `json:"done,omitempty"`
+}
+
+// Writes contacts with id greater than afterId as one JSON object per line, ordered by id. Only one
+// page of contacts is held in memory at a time, flush is called after each page
+func ExportNdjson(w io.Writer, db ContactDatabase, afterId int, flush func()) (int, error) {
+	encoder := json.NewEncoder(w)
+	written := 0
+
+	for {
+		page := db.FindAfterId(afterId, ndjsonPageSize)
+		for _, contact := range page {
+			if err := encoder.Encode(contact); err != nil {
+				return written, err
+			}
+			written++
+			afterId = contact.Id
+		}
+
+		if flush != nil {
+			flush()
+		}
+		if len(page) < ndjsonPageSize {
+			return written, nil
+		}
+	}
+}
+
+// Imports contacts one line at a time. Contacts with id keep it - already existing ids and ids not
+// greater than afterId are skipped, which makes repeating an interrupted import of them safe.
+// Contacts without id get a new one on every run, so repeating an import inserts them again. Failed
+// lines are reported through progress and don't abort the import
+func ImportNdjson(r io.Reader, db ContactDatabase, afterId int, progress func(NdjsonImportProgress) error) (NdjsonImportProgress, error) {
+	var state NdjsonImportProgress
+	state.LastId = afterId
+
+	report := func(event NdjsonImportProgress) error {
+		if progress == nil {
+			return nil
+		}
+		return progress(event)
+	}
+	fail := func(line int, err error) error {
+		state.Failed++
+		event := state
+		event.Line, event.Error = line, err.Error()
+		return report(event)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), ndjsonMaxLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		state.Processed++
+
+		var contact Contact
+		if err := json.Unmarshal(scanner.Bytes(), &contact); err != nil {
+			if err := fail(line, err); err != nil {
+				return state, err
+			}
+			continue
+		}
+
+		if err := contact.Validate(); err != nil {
+			if err := fail(line, err); err != nil {
+				return state, err
+			}
+			continue
+		}
+
+		switch {
+		case contact.Id == 0:
+			contact = db.InsertWithNewId(contact)
+			state.Imported++
+		case contact.Id <= afterId || !db.Insert(contact):
+			state.Skipped++
+		default:
+			state.Imported++
+		}
+		if contact.Id > state.LastId {
+			state.LastId = contact.Id
+		}
+
+		if state.Processed%ndjsonProgressInterval == 0 {
+			if err := report(state); err != nil {
+				return state, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return state, err
+	}
+
+	state.Done = true
+	return state, report(state)
+}
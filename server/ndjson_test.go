@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportNdjsonResume(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	for id := 1; id <= 250; id++ {
+		db.Insert(server.Contact{Id: id, Name: "Test", LastName: "test", Email: "test@test.com"})
+	}
+
+	var buf bytes.Buffer
+	flushes := 0
+	written, err := server.ExportNdjson(&buf, db, 120, func() { flushes++ })
+	require.NoError(t, err)
+
+	assert.Equal(t, 130, written)
+	assert.Equal(t, 2, flushes)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, 130, len(lines))
+	var first, last server.Contact
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal(t, 121, first.Id)
+	assert.Equal(t, 250, last.Id)
+}
+
+func TestRestExportNdjsonAfter(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp := doRequest(t, "GET", testServer.URL+"/v1/contacts/export.ndjson?after=9223372036854775807", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	for _, after := range []string{"-1", "x", "9223372036854775808"} {
+		resp = doRequest(t, "GET", testServer.URL+"/v1/contacts/export.ndjson?after="+after, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, after)
+	}
+}
+
+func TestImportNdjson(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	db.Insert(server.Contact{Id: 3, Name: "Existing", LastName: "test", Email: "test@test.com"})
+
+	data := `{"id": 1, "name": "Old", "lastName": "test", "email": "test@test.com"}
+{"id": 2, "name": "Test", "lastName": "test", "email": "test@test.com"}
+{"id": 3, "name": "Conflict", "lastName": "test", "email": "test@test.com"}
+
+{"name": "Invalid", "email": "test@test.com"}
+not json
+{"name": "New", "lastName": "test", "email": "test@test.com"}
+`
+
+	var events []server.NdjsonImportProgress
+	final, err := server.ImportNdjson(strings.NewReader(data), db, 1, func(event server.NdjsonImportProgress) error {
+		events = append(events, event)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, server.NdjsonImportProgress{Processed: 6, Imported: 2, Skipped: 2, Failed: 2, LastId: 4, Done: true}, final)
+	require.Equal(t, 3, len(events))
+	assert.Equal(t, 5, events[0].Line)
+	assert.Equal(t, "empty contact last name", events[0].Error)
+	assert.Equal(t, 6, events[1].Line)
+	assert.Equal(t, final, events[2])
+
+	assert.Nil(t, db.FindById(1))
+	assert.Equal(t, "Existing", db.FindById(3).Name)
+	assert.Equal(t, "New", db.FindById(4).Name)
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const YamlContentType = "application/yaml"
+
+// Media type the API can read and write. Formats of contacts only, like vCard, leave the value
+// functions nil, formats of arbitrary values leave the contact functions nil
+type Codec struct {
+	// Short name, e.g. for command line flags
+	Name      string
+	MediaType string
+
+	// Writes any response body, which is encoded with JSON tags
+	EncodeValue func(w io.Writer, value interface{}) error
+	// Converts request body to JSON, which versions of the API decode contacts from
+	DecodeValue func(data []byte) ([]byte, error)
+
+	// Writes contacts, params are those of the accepted media type
+	EncodeContacts func(w io.Writer, contacts []Contact, params map[string]string) error
+	// Rejects params of the accepted media type before the request is handled, optional
+	CheckParams func(params map[string]string) error
+	// Reads contacts from request body
+	DecodeContacts func(r io.Reader) ([]Contact, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	// In order of preference, when the client accepts any media type
+	codecs []*Codec
+)
+
+// Adds codec to the registry, replacing a codec of the same media type
+func RegisterCodec(codec *Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for i, registered := range codecs {
+		if registered.MediaType == codec.MediaType {
+			codecs[i] = codec
+			return
+		}
+	}
+	codecs = append(codecs, codec)
+}
+
+func registeredCodecs() []*Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return append([]*Codec(nil), codecs...)
+}
+
+// Finds codec by name or media type
+func LookupCodec(nameOrMediaType string) *Codec {
+	for _, codec := range registeredCodecs() {
+		if codec.Name == nameOrMediaType || codec.MediaType == nameOrMediaType {
+			return codec
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:      "json",
+		MediaType: "application/json",
+		EncodeValue: func(w io.Writer, value interface{}) error {
+			return json.NewEncoder(w).Encode(value)
+		},
+		DecodeValue: func(data []byte) ([]byte, error) {
+			return data, nil
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:        "yaml",
+		MediaType:   YamlContentType,
+		EncodeValue: encodeYaml,
+		DecodeValue: yamlToJson,
+	})
+	RegisterCodec(&Codec{
+		Name:      "csv",
+		MediaType: CsvContentType,
+		EncodeContacts: func(w io.Writer, contacts []Contact, params map[string]string) error {
+			writer, err := NewCsvWriter(w, CsvLayouts[CsvLayoutDefault])
+			if err != nil {
+				return err
+			}
+			for _, contact := range contacts {
+				if err := writer.Write(contact); err != nil {
+					return err
+				}
+			}
+			return writer.Flush()
+		},
+		DecodeContacts: func(r io.Reader) ([]Contact, error) {
+			reader, _, err := NewCsvReader(r, nil)
+			if err != nil {
+				return nil, err
+			}
+			var contacts []Contact
+			for {
+				contact, _, err := reader.Read()
+				if err == io.EOF {
+					return contacts, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				contacts = append(contacts, contact)
+			}
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:      "vcard",
+		MediaType: VCardContentType,
+		EncodeContacts: func(w io.Writer, contacts []Contact, params map[string]string) error {
+			version := params["version"]
+			if version == "" {
+				version = VCardVersion3
+			}
+			return WriteVCards(w, contacts, version)
+		},
+		CheckParams: func(params map[string]string) error {
+			if version, ok := params["version"]; ok && !supportedVCardVersion(version) {
+				return BadRequest("unsupported vCard version %q, use %s or %s", version, VCardVersion3, VCardVersion4)
+			}
+			return nil
+		},
+		DecodeContacts: ReadVCards,
+	})
+}
+
+// Writes value as YAML, keeping the order of JSON fields
+func encodeYaml(w io.Writer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	ordered, err := orderedJsonValue(decoder)
+	if err != nil {
+		return err
+	}
+
+	data, err = yaml.Marshal(ordered)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Reads next JSON value, representing objects as yaml.MapSlice
+func orderedJsonValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := yaml.MapSlice{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := orderedJsonValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err := decoder.Token()
+		return object, err
+
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := orderedJsonValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	}
+
+	if number, ok := token.(json.Number); ok {
+		if i, err := number.Int64(); err == nil {
+			return i, nil
+		}
+		return number.Float64()
+	}
+	return token, nil
+}
+
+func yamlToJson(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	value, err := jsonCompatible(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// YAML allows non-string keys, JSON does not
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported non-string key %v", key)
+			}
+			converted, err := jsonCompatible(child)
+			if err != nil {
+				return nil, err
+			}
+			object[keyStr] = converted
+		}
+		return object, nil
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, child := range value {
+			converted, err := jsonCompatible(child)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = converted
+		}
+		return array, nil
+	}
+	return value, nil
+}
+
+type acceptedType struct {
+	mediaType string
+	params    map[string]string
+	quality   float64
+}
+
+func (a acceptedType) specificity() int {
+	switch {
+	case a.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(a.mediaType, "/*"):
+		return 1
+	}
+	return 2 + len(a.params)
+}
+
+func (a acceptedType) matches(mediaType string) bool {
+	if a.mediaType == "*/*" || a.mediaType == mediaType {
+		return true
+	}
+	return strings.HasSuffix(a.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*"))
+}
+
+// Parses Accept header, ordered by quality and then specificity
+func parseAccept(header string) []acceptedType {
+	var accepted []acceptedType
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedType{mediaType: mediaType, params: params, quality: quality})
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		if accepted[i].quality != accepted[j].quality {
+			return accepted[i].quality > accepted[j].quality
+		}
+		return accepted[i].specificity() > accepted[j].specificity()
+	})
+	return accepted
+}
+
+type negotiatedCodec struct {
+	codec  *Codec
+	params map[string]string
+}
+
+var errNotAcceptable = errors.New("not acceptable")
+
+// Picks the most preferred codec of the Accept header able to write the response
+func negotiate(header string, contacts bool) (negotiatedCodec, error) {
+	var usable []*Codec
+	for _, codec := range registeredCodecs() {
+		if codec.EncodeValue != nil || (contacts && codec.EncodeContacts != nil) {
+			usable = append(usable, codec)
+		}
+	}
+	if strings.TrimSpace(header) == "" {
+		return negotiatedCodec{codec: usable[0]}, nil
+	}
+
+	for _, accepted := range parseAccept(header) {
+		for _, codec := range usable {
+			if accepted.matches(codec.MediaType) {
+				return negotiatedCodec{codec: codec, params: accepted.params}, nil
+			}
+		}
+	}
+	return negotiatedCodec{}, errNotAcceptable
+}
+
+// Negotiates the response format before the handler runs, so unacceptable requests have no effect.
+// Contacts is set for handlers responding with contacts, which formats of contacts only can write
+func negotiated(contacts bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		negotiated, err := negotiate(req.Header.Get("Accept"), contacts)
+		if err != nil {
+			var available []string
+			for _, codec := range registeredCodecs() {
+				if codec.EncodeValue != nil || (contacts && codec.EncodeContacts != nil) {
+					available = append(available, codec.MediaType)
+				}
+			}
+			writeProblem(w, req, NotAcceptable("supported media types: %s", strings.Join(available, ", ")))
+			return
+		}
+		if negotiated.codec.CheckParams != nil {
+			if err := negotiated.codec.CheckParams(negotiated.params); err != nil {
+				writeProblem(w, req, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), negotiatedCodecKey, negotiated)))
+	})
+}
+
+// Writes response in the negotiated format - value for formats of any value, contacts for formats of
+// contacts only
+func writeResponse(w http.ResponseWriter, req *http.Request, status int, value interface{}, contacts []Contact) error {
+	negotiated, ok := req.Context().Value(negotiatedCodecKey).(negotiatedCodec)
+	if !ok {
+		negotiated = negotiatedCodec{codec: LookupCodec("json")}
+	}
+
+	// Encoded up front, so failures are reported as problems instead of truncated responses
+	var buf bytes.Buffer
+	var err error
+	if negotiated.codec.EncodeValue != nil {
+		err = negotiated.codec.EncodeValue(&buf, value)
+	} else {
+		err = negotiated.codec.EncodeContacts(&buf, contacts, negotiated.params)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", negotiated.codec.MediaType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Reads request body of given media type as contact of the API version
+func decodeContact(version *ApiVersion, contentType string, body []byte) (Contact, error) {
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return Contact{}, UnsupportedMediaType("invalid content type %q", contentType)
+		}
+	}
+
+	codec := LookupCodec(mediaType)
+	if codec == nil || (codec.DecodeValue == nil && codec.DecodeContacts == nil) {
+		return Contact{}, UnsupportedMediaType("unsupported content type %q", mediaType)
+	}
+
+	if codec.DecodeValue == nil {
+		contacts, err := codec.DecodeContacts(bytes.NewReader(body))
+		if err != nil {
+			return Contact{}, BadRequest("invalid request body: %s", err)
+		}
+		if len(contacts) != 1 {
+			return Contact{}, BadRequest("request body must contain exactly one contact, found %d", len(contacts))
+		}
+		return contacts[0], nil
+	}
+
+	data, err := codec.DecodeValue(body)
+	if err != nil {
+		return Contact{}, BadRequest("invalid request body: %s", err)
+	}
+	contact, err := version.DecodeContact(data)
+	if err != nil {
+		return Contact{}, BadRequest("invalid request body: %s", err)
+	}
+	return contact, nil
+}
@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func negotiatedRequest(t *testing.T, method string, url string, contentType string, accept string, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestNegotiatedResponses(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp, body := negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/1", "", "application/yaml", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, server.YamlContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "id: 1\nname: John\nlastName: Lennon\nemail: john.lennon@thebeatles.com\n", body)
+
+	resp, body = negotiatedRequest(t, "GET", testServer.URL+"/v2/contacts/1", "", "text/html;q=0.9, application/yaml", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "name:\n  given: John\n  family: Lennon\n")
+
+	resp, body = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts", "", "text/csv", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, server.CsvContentType, resp.Header.Get("Content-Type"))
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 5)
+
+	resp, body = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/1", "", "text/vcard; version=4.0", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "VERSION:4.0")
+	resp, _ = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/1", "", "text/vcard; version=2.1", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/1", "", "*/*", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	resp, _ = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/duplicates", "", "text/csv", "")
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+	assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+
+	// Rejected before the contact is created
+	resp, _ = negotiatedRequest(t, "POST", testServer.URL+"/v1/contacts", "application/json", "text/html",
+		`{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`)
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+	resp, _ = negotiatedRequest(t, "POST", testServer.URL+"/v1/contacts", "application/json", "text/vcard; version=2.1",
+		`{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = negotiatedRequest(t, "GET", testServer.URL+"/v1/contacts/5", "", "application/json", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNegotiatedRequests(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp, body := negotiatedRequest(t, "POST", testServer.URL+"/v1/contacts", "application/yaml", "application/json",
+		"name: Pete\nlastName: Best\nemail: pete@beatles.com\nphones: [\"+44 5\"]\n")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.JSONEq(t, `{"id":5,"name":"Pete","lastName":"Best","email":"pete@beatles.com","phones":["+44 5"]}`, body)
+
+	resp, body = negotiatedRequest(t, "PUT", testServer.URL+"/v1/contacts/5", "text/vcard", "application/json",
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nN:Best;Peter;;;\r\nFN:Peter Best\r\nEMAIL:pete@beatles.com\r\nEND:VCARD\r\n")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"id":5,"name":"Peter","lastName":"Best","email":"pete@beatles.com"}`, body)
+
+	resp, _ = negotiatedRequest(t, "POST", testServer.URL+"/v1/contacts", "text/plain", "application/json", "Pete Best")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, _ = negotiatedRequest(t, "POST", testServer.URL+"/v1/contacts", "text/csv", "application/json",
+		"name,lastName,email\nPete,Best,pete@beatles.com\nStuart,Sutcliffe,stuart@beatles.com\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI documents are plain JSON trees, built from the route table below
+type object = map[string]interface{}
+
+//go:embed docs.html
+var docsPage []byte
+
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+}
+
+var (
+	idParam        = apiParam{"id", "path", "integer", "Contact id"}
+	versionParam   = apiParam{"version", "query", "string", "vCard version, 3.0 (default) or 4.0"}
+	afterParam     = apiParam{"after", "query", "integer", "Only contacts with greater id are processed"}
+	layoutParam    = apiParam{"layout", "query", "string", "CSV layout: default, google or outlook"}
+	mappingParam   = apiParam{"mapping", "query", "string", "CSV column mapping as JSON, overrides layout"}
+	thresholdParam = apiParam{"threshold", "query", "number", "Minimal similarity score from 0 to 1"}
+	baseDnParam    = apiParam{"baseDn", "query", "string", "Base DN of exported entries"}
+	keyIdParam     = apiParam{"id", "path", "string", "API key id"}
+	bookParam      = apiParam{"book", "path", "string", "Address book name"}
+	adminBookParam = apiParam{"book", "query", "string", "Address book name (default: the default book)"}
+	subjectParam   = apiParam{"subject", "path", "string", "Identity as <method>:<subject>, e.g. jwt:jane"}
+	contactIdParam = apiParam{"id", "path", "integer", "Contact id"}
+)
+
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Params  []apiParam
+
+	// Content type to schema of the request body, nil for no body
+	Request object
+	Status  int
+	// Content type to schema of the success response, nil for no body
+	Response object
+	Headers  []string
+	// Error statuses besides 500, which every route may return
+	Errors []int
+
+	// Response formats are negotiated with Accept header
+	Negotiated negotiation
+	// Request body may be in any format of the codec registry
+	NegotiatedRequest bool
+}
+
+type negotiation int
+
+const (
+	fixedFormat negotiation = iota
+	negotiatedValue
+	negotiatedContacts
+)
+
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(schema object) object {
+	return object{"type": "array", "items": schema}
+}
+
+func textSchema() object {
+	return object{"type": "string"}
+}
+
+func jsonBody(schema object) object {
+	return object{"application/json": schema}
+}
+
+var apiRoutes = []apiRoute{
+	{Method: "GET", Path: "/contacts", Summary: "List all contacts", Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("Contact"))), Negotiated: negotiatedContacts},
+	{Method: "POST", Path: "/contacts", Summary: "Create contact with a new id", Request: jsonBody(schemaRef("Contact")),
+		Status: 201, Response: jsonBody(schemaRef("Contact")), Headers: []string{"Location"}, Errors: []int{400, 422},
+		Negotiated: negotiatedContacts, NegotiatedRequest: true},
+	{Method: "GET", Path: "/contacts/duplicates", Summary: "Find clusters of likely duplicate contacts",
+		Params: []apiParam{thresholdParam}, Status: 200, Response: jsonBody(arrayOf(schemaRef("DuplicateCluster"))), Errors: []int{400},
+		Negotiated: negotiatedValue},
+	{Method: "POST", Path: "/contacts/merge", Summary: "Merge contacts into a single survivor",
+		Request: jsonBody(schemaRef("MergeRequest")), Status: 200, Response: jsonBody(schemaRef("Contact")), Errors: []int{400, 404, 409, 422},
+		Negotiated: negotiatedContacts},
+	{Method: "GET", Path: "/contacts/export.vcf", Summary: "Export all contacts as vCards",
+		Params: []apiParam{versionParam}, Status: 200, Response: object{VCardContentType: textSchema()}, Errors: []int{400}},
+	{Method: "POST", Path: "/contacts/import", Summary: "Import contacts from vCards, all or nothing",
+		Request: object{VCardContentType: textSchema()}, Status: 200, Response: jsonBody(arrayOf(schemaRef("Contact"))), Errors: []int{400, 422},
+		Negotiated: negotiatedContacts},
+	{Method: "GET", Path: "/contacts/export.csv", Summary: "Export all contacts as CSV, cells starting with =, +, - or @ prefixed with an apostrophe",
+		Params: []apiParam{layoutParam, mappingParam}, Status: 200, Response: object{CsvContentType: textSchema()}, Errors: []int{400}},
+	{Method: "POST", Path: "/contacts/import.csv", Summary: "Import contacts from CSV, skipping invalid rows",
+		Params: []apiParam{layoutParam, mappingParam}, Request: object{CsvContentType: textSchema()},
+		Status: 200, Response: jsonBody(schemaRef("CsvImportReport")), Errors: []int{400}, Negotiated: negotiatedValue},
+	{Method: "GET", Path: "/contacts/export.ldif", Summary: "Export all contacts as LDIF entries",
+		Params: []apiParam{baseDnParam}, Status: 200, Response: object{LdifContentType: textSchema()}},
+	{Method: "POST", Path: "/contacts/import.ldif", Summary: "Apply LDIF content and change records",
+		Request: object{LdifContentType: textSchema()}, Status: 200, Response: jsonBody(schemaRef("LdifImportReport")), Errors: []int{400},
+		Negotiated: negotiatedValue},
+	{Method: "GET", Path: "/contacts/export.ndjson", Summary: "Stream contacts ordered by id, one JSON object per line",
+		Params: []apiParam{afterParam}, Status: 200, Response: object{NdjsonContentType: schemaRef("Contact")},
+		Headers: []string{"X-Total-Count"}, Errors: []int{400}},
+	{Method: "POST", Path: "/contacts/import.ndjson", Summary: "Import contacts streamed one JSON object per line",
+		Params: []apiParam{afterParam}, Request: object{NdjsonContentType: schemaRef("Contact")},
+		Status: 200, Response: object{NdjsonContentType: schemaRef("NdjsonImportProgress")}, Errors: []int{400}},
+	{Method: "GET", Path: "/contacts/{id}.vcf", Summary: "Export single contact as vCard",
+		Params: []apiParam{idParam, versionParam}, Status: 200, Response: object{VCardContentType: textSchema()}, Errors: []int{400, 404}},
+	{Method: "GET", Path: "/contacts/{id}", Summary: "Find contact by id",
+		Params: []apiParam{idParam}, Status: 200, Response: jsonBody(schemaRef("Contact")), Errors: []int{400, 404},
+		Negotiated: negotiatedContacts},
+	{Method: "DELETE", Path: "/contacts/{id}", Summary: "Delete contact",
+		Params: []apiParam{idParam}, Status: 204, Errors: []int{400, 404}},
+	{Method: "PUT", Path: "/contacts/{id}", Summary: "Replace contact",
+		Params: []apiParam{idParam}, Request: jsonBody(schemaRef("Contact")), Status: 200, Response: jsonBody(schemaRef("Contact")),
+		Errors: []int{400, 404, 422}, Negotiated: negotiatedContacts, NegotiatedRequest: true},
+	{Method: "PATCH", Path: "/contacts/{id}", Summary: "Partially update contact with merge patch or JSON Patch",
+		Params: []apiParam{idParam}, Request: object{
+			MergePatchContentType: object{"type": "object"},
+			JsonPatchContentType:  arrayOf(schemaRef("JsonPatchOperation")),
+		}, Status: 200, Response: jsonBody(schemaRef("Contact")), Errors: []int{400, 404, 409, 415, 422},
+		Negotiated: negotiatedContacts},
+	{Method: "GET", Path: "/contacts/search/email/{email}", Summary: "Find contacts by email",
+		Params: []apiParam{{"email", "path", "string", "Email, matched exactly including case"}}, Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("Contact"))), Negotiated: negotiatedContacts},
+	{Method: "GET", Path: "/contacts/search/lastNamePart/{lastNamePart}", Summary: "Find contacts by part of last name",
+		Params: []apiParam{{"lastNamePart", "path", "string", "Part of last name"}}, Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("Contact"))), Negotiated: negotiatedContacts},
+	{Method: "GET", Path: "/admin/backup", Summary: "Download point-in-time backup of all contacts of a book",
+		Params: []apiParam{adminBookParam}, Status: 200, Response: jsonBody(schemaRef("Backup")), Errors: []int{404}},
+	{Method: "POST", Path: "/admin/restore", Summary: "Replace all contacts of a book with a verified backup",
+		Params: []apiParam{adminBookParam}, Request: jsonBody(schemaRef("Backup")), Status: 200,
+		Response: jsonBody(schemaRef("RestoreResult")), Errors: []int{400, 404}},
+	{Method: "GET", Path: "/books", Summary: "List address books the caller may read", Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("Book")))},
+	{Method: "POST", Path: "/books", Summary: "Create empty address book", Request: jsonBody(schemaRef("CreateBook")),
+		Status: 201, Response: jsonBody(schemaRef("Book")), Headers: []string{"Location"}, Errors: []int{400, 409, 422}},
+	{Method: "DELETE", Path: "/books/{book}", Summary: "Delete address book with all its contacts",
+		Params: []apiParam{bookParam}, Status: 204, Errors: []int{404, 409}},
+	{Method: "GET", Path: "/books/{book}/shares", Summary: "List shares of address book and its contacts",
+		Params: []apiParam{bookParam}, Status: 200, Response: jsonBody(arrayOf(schemaRef("Share"))), Errors: []int{404}},
+	{Method: "PUT", Path: "/books/{book}/shares/{subject}", Summary: "Share address book",
+		Params: []apiParam{bookParam, subjectParam}, Request: jsonBody(schemaRef("ShareAccess")), Status: 204,
+		Errors: []int{400, 404, 422}},
+	{Method: "DELETE", Path: "/books/{book}/shares/{subject}", Summary: "Stop sharing address book",
+		Params: []apiParam{bookParam, subjectParam}, Status: 204, Errors: []int{404}},
+	{Method: "PUT", Path: "/books/{book}/contacts/{id}/shares/{subject}", Summary: "Share single contact",
+		Params: []apiParam{bookParam, contactIdParam, subjectParam}, Request: jsonBody(schemaRef("ShareAccess")), Status: 204,
+		Errors: []int{400, 404, 422}},
+	{Method: "DELETE", Path: "/books/{book}/contacts/{id}/shares/{subject}", Summary: "Stop sharing single contact",
+		Params: []apiParam{bookParam, contactIdParam, subjectParam}, Status: 204, Errors: []int{400, 404}},
+	{Method: "GET", Path: "/shared", Summary: "List address books and contacts shared with the caller", Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("SharedItem")))},
+	{Method: "GET", Path: "/admin/keys", Summary: "List API keys", Status: 200,
+		Response: jsonBody(arrayOf(schemaRef("ApiKey"))), Errors: []int{404}},
+	{Method: "POST", Path: "/admin/keys", Summary: "Create API key, the response is the only copy of the key",
+		Request: jsonBody(schemaRef("CreateApiKey")), Status: 201, Response: jsonBody(schemaRef("CreatedApiKey")),
+		Headers: []string{"Location"}, Errors: []int{400, 404, 422}},
+	{Method: "DELETE", Path: "/admin/keys/{id}", Summary: "Revoke API key",
+		Params: []apiParam{keyIdParam}, Status: 204, Errors: []int{404}},
+	{Method: "GET", Path: "/admin/audit", Summary: "Query audit events, including rotated segments, oldest first",
+		Params: []apiParam{
+			{"from", "query", "string", "Only events at or after this RFC 3339 time"},
+			{"to", "query", "string", "Only events before this RFC 3339 time"},
+			{"op", "query", "string", "Only events of this operation"},
+			{"actor", "query", "string", "Only events of this subject or <method>:<subject>"},
+			{"contactId", "query", "integer", "Only events concerning this contact"},
+			{"limit", "query", "integer", "Most recent events returned, 1 to 1000 (default: 100)"},
+		}, Status: 200, Response: jsonBody(arrayOf(schemaRef("AuditEvent"))), Errors: []int{400}},
+	{Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI document", Status: 200, Response: jsonBody(object{"type": "object"})},
+	{Method: "GET", Path: "/docs", Summary: "API documentation page", Status: 200, Response: object{"text/html": textSchema()}},
+}
+
+var accessSchema = object{"type": "string", "enum": []Access{AccessRead, AccessWrite}}
+
+var problemResponses = map[int]string{
+	400: "BadRequest",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "NotFound",
+	406: "NotAcceptable",
+	409: "Conflict",
+	415: "UnsupportedMediaType",
+	422: "ValidationFailed",
+	500: "InternalError",
+}
+
+func stringProperties(names ...string) object {
+	properties := object{}
+	for _, name := range names {
+		properties[name] = object{"type": "string"}
+	}
+	return properties
+}
+
+func integerProperties(names ...string) object {
+	properties := object{}
+	for _, name := range names {
+		properties[name] = object{"type": "integer"}
+	}
+	return properties
+}
+
+func withProperties(properties object, extra object) object {
+	for name, schema := range extra {
+		properties[name] = schema
+	}
+	return properties
+}
+
+func apiSchemas() object {
+	return object{
+		"Contact": object{
+			"type":     "object",
+			"required": []string{"name", "lastName", "email"},
+			"properties": withProperties(stringProperties("name", "lastName", "email", "address", "notes"), object{
+				"id":     object{"type": "integer", "description": "Assigned by the server on create"},
+				"phones": arrayOf(textSchema()),
+			}),
+		},
+		"ContactV2": object{
+			"type":     "object",
+			"required": []string{"name", "email"},
+			"properties": object{
+				"id":      object{"type": "integer", "description": "Assigned by the server on create"},
+				"name":    object{"type": "object", "required": []string{"given", "family"}, "properties": stringProperties("given", "family")},
+				"email":   textSchema(),
+				"phones":  arrayOf(textSchema()),
+				"address": textSchema(),
+				"notes":   textSchema(),
+			},
+		},
+		"Problem": object{
+			"type":        "object",
+			"description": "RFC 7807 problem details",
+			"properties": withProperties(stringProperties("type", "title", "detail", "instance"), object{
+				"status":        object{"type": "integer"},
+				"invalidParams": arrayOf(schemaRef("InvalidParam")),
+			}),
+		},
+		"InvalidParam": object{
+			"type":       "object",
+			"properties": stringProperties("name", "reason"),
+		},
+		"DuplicatePair": object{
+			"type": "object",
+			"properties": withProperties(integerProperties("firstId", "secondId"), object{
+				"score": object{"type": "number"},
+			}),
+		},
+		"DuplicateCluster": object{
+			"type": "object",
+			"properties": object{
+				"contacts": arrayOf(schemaRef("Contact")),
+				"pairs":    arrayOf(schemaRef("DuplicatePair")),
+				"score":    object{"type": "number"},
+			},
+		},
+		"DuplicateClusterV2": object{
+			"type": "object",
+			"properties": object{
+				"contacts": arrayOf(schemaRef("ContactV2")),
+				"pairs":    arrayOf(schemaRef("DuplicatePair")),
+				"score":    object{"type": "number"},
+			},
+		},
+		"FieldResolution": object{
+			"type": "object",
+			"properties": object{
+				"strategy": object{"type": "string", "enum": []MergeStrategy{MergeKeepNewest, MergeKeepLongest, MergeUnion, MergeChoose}},
+				"sourceId": object{"type": "integer", "description": "Contact to take the field from, for choose strategy"},
+			},
+		},
+		"MergeRequest": object{
+			"type":     "object",
+			"required": []string{"ids"},
+			"properties": object{
+				"ids":        arrayOf(object{"type": "integer"}),
+				"survivorId": object{"type": "integer"},
+				"fields":     object{"type": "object", "additionalProperties": schemaRef("FieldResolution")},
+			},
+		},
+		"JsonPatchOperation": object{
+			"type":     "object",
+			"required": []string{"op", "path"},
+			"properties": object{
+				"op":    object{"type": "string", "enum": []string{"add", "remove", "replace", "test"}},
+				"path":  object{"type": "string"},
+				"value": object{},
+			},
+		},
+		"CsvImportReport": object{
+			"type": "object",
+			"properties": withProperties(integerProperties("imported", "failed"), object{
+				"layout": object{"type": "string"},
+				"errors": arrayOf(object{"type": "object", "properties": withProperties(integerProperties("row"), stringProperties("error"))}),
+			}),
+		},
+		"LdifImportReport": object{
+			"type": "object",
+			"properties": withProperties(integerProperties("added", "modified", "deleted", "failed"), object{
+				"errors": arrayOf(object{"type": "object", "properties": withProperties(integerProperties("record"), stringProperties("dn", "error"))}),
+			}),
+		},
+		"NdjsonImportProgress": object{
+			"type": "object",
+			"properties": withProperties(integerProperties("processed", "imported", "skipped", "failed", "lastId", "line"), object{
+				"error": object{"type": "string"},
+				"done":  object{"type": "boolean", "description": "Set on the final summary"},
+			}),
+		},
+		"Backup": object{
+			"type": "object",
+			"properties": withProperties(integerProperties("schemaVersion", "highestId", "count"), object{
+				"format":    object{"type": "string"},
+				"createdAt": object{"type": "string", "format": "date-time"},
+				"metadata":  object{"type": "object", "additionalProperties": textSchema()},
+				"checksum":  object{"type": "string", "description": "Hex SHA-256 of the contacts"},
+				"contacts":  arrayOf(schemaRef("Contact")),
+			}),
+		},
+		"RestoreResult": object{
+			"type":       "object",
+			"properties": integerProperties("restored", "highestId"),
+		},
+		"Book": object{
+			"type": "object",
+			"properties": withProperties(stringProperties("name", "owner"), object{
+				"createdAt": object{"type": "string", "format": "date-time"},
+				"count":     object{"type": "integer"},
+			}),
+		},
+		"CreateBook": object{
+			"type":     "object",
+			"required": []string{"name"},
+			"properties": withProperties(stringProperties("name"), object{
+				"personal": object{"type": "boolean", "description": "Owned by the caller instead of a team book"},
+			}),
+		},
+		"AuditEvent": object{
+			"type": "object",
+			"properties": withProperties(stringProperties("op", "book", "clientIp", "requestId", "outcome"), object{
+				"time":      object{"type": "string", "format": "date-time"},
+				"status":    object{"type": "integer"},
+				"contactId": object{"type": "integer"},
+				"actor": object{
+					"type":       "object",
+					"properties": withProperties(stringProperties("subject", "name", "method"), object{"roles": arrayOf(object{"type": "string"})}),
+				},
+				"data": object{"description": "Details of the operation"},
+				"changes": arrayOf(object{
+					"type":       "object",
+					"properties": stringProperties("field", "change"),
+				}),
+			}),
+		},
+		"ShareAccess": object{
+			"type":       "object",
+			"required":   []string{"access"},
+			"properties": object{"access": accessSchema},
+		},
+		"Share": object{
+			"type": "object",
+			"properties": withProperties(withProperties(stringProperties("subject"), integerProperties("contactId")),
+				object{"access": accessSchema}),
+		},
+		"SharedItem": object{
+			"type": "object",
+			"properties": withProperties(withProperties(stringProperties("book", "owner"), integerProperties("contactId")),
+				object{"access": accessSchema}),
+		},
+		"ApiKey": object{
+			"type": "object",
+			"properties": withProperties(stringProperties("id", "label"), object{
+				"roles":      arrayOf(object{"type": "string"}),
+				"createdAt":  object{"type": "string", "format": "date-time"},
+				"lastUsedAt": object{"type": "string", "format": "date-time"},
+			}),
+		},
+		"CreateApiKey": object{
+			"type":       "object",
+			"required":   []string{"label"},
+			"properties": stringProperties("label"),
+		},
+		"CreatedApiKey": object{
+			"allOf": []object{
+				schemaRef("ApiKey"),
+				object{"type": "object", "properties": stringProperties("key")},
+			},
+		},
+	}
+}
+
+// Adds media types of registered codecs to content types of a JSON body
+func negotiatedContent(content object, negotiation negotiation) object {
+	schema, ok := content["application/json"]
+	if !ok {
+		return content
+	}
+
+	negotiated := object{}
+	for contentType, schema := range content {
+		negotiated[contentType] = schema
+	}
+	for _, codec := range registeredCodecs() {
+		if codec.EncodeValue != nil {
+			negotiated[codec.MediaType] = schema
+		} else if negotiation == negotiatedContacts && codec.EncodeContacts != nil {
+			negotiated[codec.MediaType] = textSchema()
+		}
+	}
+	return negotiated
+}
+
+func (route apiRoute) operation() object {
+	responses := object{}
+	if route.Negotiated != fixedFormat {
+		route.Response = negotiatedContent(route.Response, route.Negotiated)
+		route.Errors = append(route.Errors, 406)
+	}
+	if route.NegotiatedRequest {
+		route.Request = negotiatedContent(route.Request, negotiatedContacts)
+		route.Errors = append(route.Errors, 415)
+	}
+
+	success := object{"description": http.StatusText(route.Status)}
+	if route.Response != nil {
+		success["content"] = schemaContent(route.Response)
+	}
+	if len(route.Headers) > 0 {
+		headers := object{}
+		for _, header := range route.Headers {
+			headers[header] = object{"schema": textSchema()}
+		}
+		success["headers"] = headers
+	}
+	responses[strconv.Itoa(route.Status)] = success
+
+	// Routes outside public paths reject requests without credentials when authentication is enabled,
+	// and requests of roles without the route's operation
+	if !publicPaths[route.Path] {
+		route.Errors = append(route.Errors, 401, 403)
+	}
+	for _, status := range append(route.Errors, 500) {
+		responses[strconv.Itoa(status)] = object{"$ref": "#/components/responses/" + problemResponses[status]}
+	}
+
+	operation := object{"summary": route.Summary, "responses": responses}
+	if publicPaths[route.Path] {
+		operation["security"] = []object{}
+	}
+	if len(route.Params) > 0 {
+		var params []object
+		for _, param := range route.Params {
+			params = append(params, object{
+				"name":        param.Name,
+				"in":          param.In,
+				"required":    param.In == "path",
+				"description": param.Description,
+				"schema":      object{"type": param.Type},
+			})
+		}
+		operation["parameters"] = params
+	}
+	if route.Request != nil {
+		operation["requestBody"] = object{"required": true, "content": schemaContent(route.Request)}
+	}
+	return operation
+}
+
+func schemaContent(types object) object {
+	content := object{}
+	for contentType, schema := range types {
+		content[contentType] = object{"schema": schema}
+	}
+	return content
+}
+
+// Copies schema tree, replacing references to schemas which are renamed in a version
+func renameSchemas(value interface{}, names map[string]string) interface{} {
+	switch value := value.(type) {
+	case object:
+		renamed := object{}
+		for key, child := range value {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if newName, ok := names[name]; ok {
+					child = "#/components/schemas/" + newName
+				}
+			}
+			renamed[key] = renameSchemas(child, names)
+		}
+		return renamed
+	case []object:
+		renamed := make([]object, len(value))
+		for i, child := range value {
+			renamed[i] = renameSchemas(child, names).(object)
+		}
+		return renamed
+	}
+	return value
+}
+
+// Builds OpenAPI 3 document describing all routes of the REST API
+func OpenApiSpec() map[string]interface{} {
+	paths := object{}
+	addOperation := func(path string, method string, operation object) {
+		pathItem, ok := paths[path].(object)
+		if !ok {
+			pathItem = object{}
+			paths[path] = pathItem
+		}
+		pathItem[strings.ToLower(method)] = operation
+	}
+
+	for _, route := range apiRoutes {
+		if !strings.HasPrefix(route.Path, "/contacts") {
+			addOperation(route.Path, route.Method, route.operation())
+			continue
+		}
+
+		// Contacts of the default book, then the same routes in any book
+		bookRoute := route
+		bookRoute.Path = "/books/{book}" + route.Path
+		bookRoute.Params = append([]apiParam{bookParam}, route.Params...)
+		bookRoute.Errors = append(append([]int{}, route.Errors...), 404)
+		for _, route := range []apiRoute{route, bookRoute} {
+			for _, version := range ApiVersions {
+				operation := renameSchemas(route.operation(), version.schemas).(object)
+				operation["tags"] = []string{version.Name}
+				addOperation(version.Prefix+route.Path, route.Method, operation)
+			}
+			legacy := route.operation()
+			legacy["deprecated"] = true
+			legacy["tags"] = []string{"unversioned"}
+			addOperation(route.Path, route.Method, legacy)
+		}
+	}
+
+	responses := object{}
+	for status, name := range problemResponses {
+		responses[name] = object{
+			"description": http.StatusText(status),
+			"content":     object{ProblemContentType: object{"schema": schemaRef("Problem")}},
+		}
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "Contacts API",
+			"version": "1.0.0",
+		},
+		"paths":    paths,
+		"security": []object{{"ApiKey": []string{}}, {"Bearer": []string{}}},
+		"components": object{
+			"schemas":   apiSchemas(),
+			"responses": responses,
+			"securitySchemes": object{
+				"ApiKey": object{"type": "apiKey", "in": "header", "name": ApiKeyHeader},
+				"Bearer": object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+func (r *RestServer) openApi(w http.ResponseWriter, req *http.Request) error {
+	return writeJson(OpenApiSpec(), w)
+}
+
+func (r *RestServer) docs(w http.ResponseWriter, req *http.Request) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := w.Write(docsPage)
+	return err
+}
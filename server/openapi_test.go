@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Route variables may carry a pattern, e.g. {id:[0-9]+}, which OpenAPI paths do not
+var routeVariablePattern = regexp.MustCompile(`\{([^:}]+):[^}]+\}`)
+
+func TestOpenApiMatchesRouter(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	router, ok := restServer.Handler().(*mux.Router)
+	require.True(t, ok)
+
+	var routes []string
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		// Subrouters of API versions match any method
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes = append(routes, strings.ToLower(method)+" "+routeVariablePattern.ReplaceAllString(path, "{$1}"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var documented []string
+	for path, pathItem := range server.OpenApiSpec()["paths"].(map[string]interface{}) {
+		for method := range pathItem.(map[string]interface{}) {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented)
+}
+
+func TestOpenApiServed(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp := doRequest(t, "GET", testServer.URL+"/openapi.json", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var spec map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&spec))
+	assert.Equal(t, "3.0.3", spec["openapi"])
+
+	// Every referenced schema and response must be defined
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	components := spec["components"].(map[string]interface{})
+	for _, ref := range regexp.MustCompile(`"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(data), -1) {
+		assert.Contains(t, components[ref[1]], ref[2])
+	}
+
+	resp = doRequest(t, "GET", testServer.URL+"/docs", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JsonPatchContentType  = "application/json-patch+json"
+)
+
+// Returned when a JSON Patch "test" operation does not match the contact
+var ErrPatchTestFailed = errors.New("patch test failed")
+
+// Single RFC 6902 operation - only add, remove, replace and test are supported
+type JsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Contact as generic JSON document of given API version. Optional fields are always present, so
+// they can be replaced even when empty
+func (v *ApiVersion) contactDocument(contact Contact) (map[string]interface{}, error) {
+	data, err := json.Marshal(v.EncodeContact(contact))
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if _, ok := doc["phones"]; !ok {
+		doc["phones"] = []interface{}{}
+	}
+	for _, field := range []string{"address", "notes"} {
+		if _, ok := doc[field]; !ok {
+			doc[field] = ""
+		}
+	}
+	return doc, nil
+}
+
+// Converts patched document back, rejecting fields missing from the original document, wrong types
+// and id changes
+func (v *ApiVersion) documentContact(doc interface{}, fields map[string]interface{}, id int) (Contact, error) {
+	if object, ok := doc.(map[string]interface{}); ok {
+		for field := range object {
+			if _, known := fields[field]; !known {
+				return Contact{}, fmt.Errorf("unknown field %q", field)
+			}
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Contact{}, err
+	}
+	contact, err := v.DecodeContact(data)
+	if err != nil {
+		return Contact{}, err
+	}
+	if contact.Id != id {
+		return Contact{}, errors.New("contact id cannot be changed")
+	}
+	if len(contact.Phones) == 0 {
+		contact.Phones = nil
+	}
+	return contact, nil
+}
+
+// Applies RFC 7396 merge patch to v1 representation of the contact
+func ApplyMergePatch(contact Contact, patch []byte) (Contact, error) {
+	return ApiV1.ApplyMergePatch(contact, patch)
+}
+
+// Applies RFC 7396 merge patch - null removes a field, objects are merged recursively
+func (v *ApiVersion) ApplyMergePatch(contact Contact, patch []byte) (Contact, error) {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return Contact{}, err
+	}
+
+	doc, err := v.contactDocument(contact)
+	if err != nil {
+		return Contact{}, err
+	}
+	fields := copyFields(doc)
+	return v.documentContact(mergePatch(doc, patchDoc), fields, contact.Id)
+}
+
+func copyFields(doc map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(doc))
+	for field, value := range doc {
+		fields[field] = value
+	}
+	return fields
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// Applies RFC 6902 JSON Patch to v1 representation of the contact
+func ApplyJsonPatch(contact Contact, operations []JsonPatchOperation) (Contact, error) {
+	return ApiV1.ApplyJsonPatch(contact, operations)
+}
+
+// Applies RFC 6902 JSON Patch. Operations are applied in order and either all or none take effect
+func (v *ApiVersion) ApplyJsonPatch(contact Contact, operations []JsonPatchOperation) (Contact, error) {
+	doc, err := v.contactDocument(contact)
+	if err != nil {
+		return Contact{}, err
+	}
+	fields := copyFields(doc)
+
+	var root interface{} = doc
+	for i, operation := range operations {
+		root, err = applyOperation(root, operation)
+		if err != nil {
+			return Contact{}, fmt.Errorf("operation %d (%s %s): %w", i+1, operation.Op, operation.Path, err)
+		}
+	}
+	return v.documentContact(root, fields, contact.Id)
+}
+
+func applyOperation(root interface{}, operation JsonPatchOperation) (interface{}, error) {
+	tokens, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, errors.New("missing value")
+		}
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unsupported operation %q", operation.Op)
+	}
+
+	if operation.Op == "test" {
+		current, err := resolvePointer(root, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return root, nil
+	}
+
+	if len(tokens) == 0 {
+		if operation.Op == "remove" {
+			return nil, errors.New("cannot remove whole contact")
+		}
+		return value, nil
+	}
+
+	parent, err := resolvePointer(root, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := applyToParent(parent, tokens[len(tokens)-1], operation.Op, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return updated, nil
+	}
+	// Arrays change identity when resized, so the new parent is stored back into its own parent
+	return root, setChild(root, tokens[:len(tokens)-1], updated)
+}
+
+func applyToParent(parent interface{}, token string, op string, value interface{}) (interface{}, error) {
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		_, exists := container[token]
+		if op != "add" && !exists {
+			return nil, fmt.Errorf("path member %q not found", token)
+		}
+		if op == "remove" {
+			delete(container, token)
+		} else {
+			container[token] = value
+		}
+		return container, nil
+
+	case []interface{}:
+		if op == "add" && token == "-" {
+			return append(container, value), nil
+		}
+		index, err := arrayIndex(token, len(container), op == "add")
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add":
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+		case "remove":
+			container = append(container[:index], container[index+1:]...)
+		case "replace":
+			container[index] = value
+		}
+		return container, nil
+	}
+	return nil, fmt.Errorf("cannot apply %s to a scalar value", op)
+}
+
+func setChild(root interface{}, tokens []string, child interface{}) error {
+	parent, err := resolvePointer(root, tokens[:len(tokens)-1])
+	if err != nil {
+		return err
+	}
+	_, err = applyToParent(parent, tokens[len(tokens)-1], "replace", child)
+	return err
+}
+
+// Splits RFC 6901 JSON Pointer into unescaped reference tokens
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func resolvePointer(root interface{}, tokens []string) (interface{}, error) {
+	current := root
+	for _, token := range tokens {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q not found", token)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			current = container[index]
+		default:
+			return nil, fmt.Errorf("path member %q not found", token)
+		}
+	}
+	return current, nil
+}
+
+// Parses array index - for add, index equal to the length appends
+func arrayIndex(token string, length int, add bool) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index > length || (index == length && !add) {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	aJson, errA := json.Marshal(a)
+	bJson, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJson, bJson)
+}
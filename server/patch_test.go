@@ -0,0 +1,87 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var patchContact = server.Contact{
+	Id:       1,
+	Name:     "John",
+	LastName: "Lennon",
+	Email:    "john@beatles.com",
+	Phones:   []string{"+44 1", "+44 2"},
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		patch    string
+		expected server.Contact
+	}{
+		{`{"email":"lennon@beatles.com"}`, server.Contact{Id: 1, Name: "John", LastName: "Lennon", Email: "lennon@beatles.com", Phones: []string{"+44 1", "+44 2"}}},
+		{`{"phones":null,"notes":"Walrus"}`, server.Contact{Id: 1, Name: "John", LastName: "Lennon", Email: "john@beatles.com", Notes: "Walrus"}},
+		{`{"phones":["+44 3"],"id":1}`, server.Contact{Id: 1, Name: "John", LastName: "Lennon", Email: "john@beatles.com", Phones: []string{"+44 3"}}},
+	}
+
+	for _, test := range tests {
+		patched, err := server.ApplyMergePatch(patchContact, []byte(test.patch))
+		require.NoError(t, err, test.patch)
+		assert.Equal(t, test.expected, patched, test.patch)
+	}
+	assert.Equal(t, []string{"+44 1", "+44 2"}, patchContact.Phones)
+
+	for _, patch := range []string{`{"id":2}`, `{"nickname":"Johnny"}`, `{"email":5}`, `[1]`} {
+		_, err := server.ApplyMergePatch(patchContact, []byte(patch))
+		assert.Error(t, err, patch)
+	}
+}
+
+func jsonPatch(t *testing.T, patch string) []server.JsonPatchOperation {
+	var operations []server.JsonPatchOperation
+	require.NoError(t, json.Unmarshal([]byte(patch), &operations))
+	return operations
+}
+
+func TestApplyJsonPatch(t *testing.T) {
+	patched, err := server.ApplyJsonPatch(patchContact, jsonPatch(t, `[
+		{"op":"test","path":"/email","value":"john@beatles.com"},
+		{"op":"replace","path":"/email","value":"lennon@beatles.com"},
+		{"op":"add","path":"/phones/0","value":"+44 0"},
+		{"op":"add","path":"/phones/-","value":"+44 3"},
+		{"op":"remove","path":"/phones/2"},
+		{"op":"add","path":"/address","value":"Liverpool"}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, server.Contact{
+		Id:       1,
+		Name:     "John",
+		LastName: "Lennon",
+		Email:    "lennon@beatles.com",
+		Phones:   []string{"+44 0", "+44 1", "+44 3"},
+		Address:  "Liverpool",
+	}, patched)
+	assert.Equal(t, []string{"+44 1", "+44 2"}, patchContact.Phones)
+
+	_, err = server.ApplyJsonPatch(patchContact, jsonPatch(t, `[
+		{"op":"replace","path":"/email","value":"lennon@beatles.com"},
+		{"op":"test","path":"/name","value":"Paul"}
+	]`))
+	assert.True(t, errors.Is(err, server.ErrPatchTestFailed))
+
+	for _, patch := range []string{
+		`[{"op":"replace","path":"/nickname","value":"Johnny"}]`,
+		`[{"op":"remove","path":"/phones/2"}]`,
+		`[{"op":"move","from":"/name","path":"/notes"}]`,
+		`[{"op":"replace","path":"/id","value":2}]`,
+		`[{"op":"replace","path":"/email"}]`,
+		`[{"op":"remove","path":""}]`,
+	} {
+		_, err := server.ApplyJsonPatch(patchContact, jsonPatch(t, patch))
+		assert.Error(t, err, patch)
+	}
+}
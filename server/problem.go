@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem types, relative to the API root
+const (
+	ProblemTypeBadRequest    = "/problems/bad-request"
+	ProblemTypeUnauthorized  = "/problems/unauthorized"
+	ProblemTypeForbidden     = "/problems/forbidden"
+	ProblemTypeNotFound      = "/problems/not-found"
+	ProblemTypeConflict      = "/problems/conflict"
+	ProblemTypeValidation    = "/problems/validation"
+	ProblemTypeNotAcceptable = "/problems/not-acceptable"
+	ProblemTypeMediaType     = "/problems/unsupported-media-type"
+	ProblemTypeInternal      = "/problems/internal"
+)
+
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// RFC 7807 problem details. Implements error, so handlers can return it directly
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+func newProblem(problemType string, status int, format string, args ...interface{}) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+func BadRequest(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeBadRequest, http.StatusBadRequest, format, args...)
+}
+
+func Unauthorized(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeUnauthorized, http.StatusUnauthorized, format, args...)
+}
+
+func Forbidden(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeForbidden, http.StatusForbidden, format, args...)
+}
+
+func NotFound(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeNotFound, http.StatusNotFound, format, args...)
+}
+
+func Conflict(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeConflict, http.StatusConflict, format, args...)
+}
+
+func NotAcceptable(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeNotAcceptable, http.StatusNotAcceptable, format, args...)
+}
+
+func UnsupportedMediaType(format string, args ...interface{}) *Problem {
+	return newProblem(ProblemTypeMediaType, http.StatusUnsupportedMediaType, format, args...)
+}
+
+// Wraps a validation failure, listing the offending field when known
+func Invalid(err error) *Problem {
+	problem := newProblem(ProblemTypeValidation, http.StatusUnprocessableEntity, "%s", err.Error())
+	problem.Title = "Validation Failed"
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		problem.InvalidParams = []InvalidParam{{Name: validationErr.Field, Reason: validationErr.Message}}
+	}
+	return problem
+}
+
+func Internal() *Problem {
+	return newProblem(ProblemTypeInternal, http.StatusInternalServerError, "unexpected server error")
+}
+
+// Converts any handler error to a problem - errors which are not problems are internal and their
+// details are only logged, never sent to the client
+func toProblem(err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return Invalid(err)
+	}
+
+	log.Print("internal error: ", err)
+	return Internal()
+}
+
+func writeProblem(w http.ResponseWriter, req *http.Request, err error) {
+	problem := *toProblem(err)
+	problem.Instance = req.URL.Path
+
+	body, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(body)
+}
@@ -0,0 +1,50 @@
+package server_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProblemResponses(t *testing.T) {
+	testServer := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		body          string
+		status        int
+		problemType   string
+		invalidParams []server.InvalidParam
+	}{
+		{"invalid id", "GET", "/contacts/abc", "", 400, server.ProblemTypeBadRequest, nil},
+		{"missing contact", "GET", "/contacts/99", "", 404, server.ProblemTypeNotFound, nil},
+		{"malformed body", "POST", "/contacts", "{", 400, server.ProblemTypeBadRequest, nil},
+		{"validation", "POST", "/contacts", `{"name":"Ringo","lastName":"Starr"}`, 422, server.ProblemTypeValidation,
+			[]server.InvalidParam{{Name: "email", Reason: "empty contact email"}}},
+		{"invalid threshold", "GET", "/contacts/duplicates?threshold=x", "", 400, server.ProblemTypeBadRequest, nil},
+		{"invalid merge", "POST", "/contacts/merge", `{"ids":[1]}`, 422, server.ProblemTypeValidation, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := doRequest(t, test.method, testServer.URL+test.path, test.body)
+
+			assert.Equal(t, test.status, resp.StatusCode)
+			assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+
+			var problem server.Problem
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+			assert.Equal(t, test.status, problem.Status)
+			assert.Equal(t, test.problemType, problem.Type)
+			assert.Equal(t, strings.SplitN(test.path, "?", 2)[0], problem.Instance)
+			assert.NotEmpty(t, problem.Detail)
+			assert.Equal(t, test.invalidParams, problem.InvalidParams)
+		})
+	}
+}
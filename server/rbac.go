@@ -0,0 +1,231 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
+)
+
+// Operation a role may be granted, routes of the REST API map onto them
+type Operation string
+
+const (
+	OpFindAll        Operation = "findAll"
+	OpFindById       Operation = "findById"
+	OpSearch         Operation = "search"
+	OpFindDuplicates Operation = "findDuplicates"
+	OpCreate         Operation = "create"
+	OpUpdate         Operation = "updateById"
+	OpPatch          Operation = "patchById"
+	OpDelete         Operation = "deleteById"
+	OpMerge          Operation = "merge"
+	OpImport         Operation = "import"
+	OpExport         Operation = "export"
+	OpListBooks      Operation = "listBooks"
+	// Backups, restores, API keys and books
+	OpAdmin Operation = "admin"
+
+	// Grants every operation
+	OpAll Operation = "*"
+)
+
+var Operations = []Operation{
+	OpFindAll, OpFindById, OpSearch, OpFindDuplicates,
+	OpCreate, OpUpdate, OpPatch, OpDelete, OpMerge, OpImport, OpExport, OpListBooks, OpAdmin,
+}
+
+// Built-in roles of DefaultPolicy
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Maps callers to roles and roles to the operations they may perform
+type Policy struct {
+	Roles map[string][]Operation `yaml:"roles"`
+	// Roles of callers by "<method>:<subject>", e.g. "apikey:3f2a..." or "jwt:jane"
+	Subjects map[string][]string `yaml:"subjects"`
+	// Roles of callers not listed in subjects, and of all requests when authentication is disabled
+	DefaultRoles []string `yaml:"defaultRoles"`
+	// Roles in single books, granted in addition to the roles above
+	Books map[string]BookPolicy `yaml:"books"`
+	// Grants roles the credentials carry, e.g. the JWT roles claim. Off unless the issuers of
+	// accepted tokens are trusted to hand out any role, admin included
+	TokenRoles bool `yaml:"tokenRoles"`
+}
+
+type BookPolicy struct {
+	Subjects map[string][]string `yaml:"subjects"`
+	// Roles in the book of callers not listed in its subjects
+	DefaultRoles []string `yaml:"defaultRoles"`
+}
+
+// Viewers read and export, editors also change contacts, admins do anything. Callers are viewers
+// unless listed in subjects. Enforced when callers are authenticated and no policy is given
+func DefaultPolicy() *Policy {
+	viewer := []Operation{OpFindAll, OpFindById, OpSearch, OpFindDuplicates, OpExport, OpListBooks}
+	editor := append(append([]Operation{}, viewer...), OpCreate, OpUpdate, OpPatch, OpDelete, OpMerge, OpImport)
+	return &Policy{
+		Roles: map[string][]Operation{
+			RoleViewer: viewer,
+			RoleEditor: editor,
+			RoleAdmin:  {OpAll},
+		},
+		Subjects:     map[string][]string{},
+		DefaultRoles: []string{RoleViewer},
+	}
+}
+
+// Reads YAML or JSON policy, rejecting unknown operations and roles
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *Policy) Validate() error {
+	known := map[Operation]bool{OpAll: true}
+	for _, op := range Operations {
+		known[op] = true
+	}
+	for role, ops := range p.Roles {
+		for _, op := range ops {
+			if !known[op] {
+				return fmt.Errorf("role %s: unknown operation %q", role, op)
+			}
+		}
+	}
+
+	checkRoles := func(owner string, roles []string) error {
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				return fmt.Errorf("%s: unknown role %q", owner, role)
+			}
+		}
+		return nil
+	}
+	for subject, roles := range p.Subjects {
+		if err := checkRoles("subject "+subject, roles); err != nil {
+			return err
+		}
+	}
+	for book, bookPolicy := range p.Books {
+		for subject, roles := range bookPolicy.Subjects {
+			if err := checkRoles("book "+book+" subject "+subject, roles); err != nil {
+				return err
+			}
+		}
+		if err := checkRoles("book "+book+" defaultRoles", bookPolicy.DefaultRoles); err != nil {
+			return err
+		}
+	}
+	return checkRoles("defaultRoles", p.DefaultRoles)
+}
+
+func subjectRoles(subjects map[string][]string, defaultRoles []string, identity *Identity) []string {
+	if identity == nil {
+		return defaultRoles
+	}
+	if roles, ok := subjects[identity.Key()]; ok {
+		return roles
+	}
+	return defaultRoles
+}
+
+// Roles of the caller in the book - those of its subject or the default ones, both server-wide and
+// in the book, plus roles of its API key or, when the policy trusts token roles, its token. Roles
+// unknown to the policy grant nothing
+func (p *Policy) RolesOf(identity *Identity, book string) []string {
+	roles := append([]string{}, subjectRoles(p.Subjects, p.DefaultRoles, identity)...)
+	if bookPolicy, ok := p.Books[book]; ok {
+		roles = append(roles, subjectRoles(bookPolicy.Subjects, bookPolicy.DefaultRoles, identity)...)
+	}
+	if identity != nil && (p.TokenRoles || identity.Method == ApiKeyMethod) {
+		roles = append(roles, identity.Roles...)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func (p *Policy) Allows(identity *Identity, book string, op Operation) bool {
+	for _, role := range p.RolesOf(identity, book) {
+		for _, granted := range p.Roles[role] {
+			if granted == op || granted == OpAll {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether the caller may perform the operation in the book, on the contact of given id when
+// non-zero. Personal books are governed by their owner and shares alone, team books by the policy
+// and shares
+func (r *RestServer) allowed(req *http.Request, book string, op Operation, contactId int) bool {
+	identity := IdentityOf(req)
+	owner, bookAccess, contactAccess := r.books.access(book, identity, contactId)
+	if bookAccess.permits(op) || contactAccess.permitsContact(op) {
+		return true
+	}
+	if owner != "" {
+		return false
+	}
+	policy := r.policy
+	if policy == nil && len(r.authenticators) > 0 {
+		policy = defaultPolicy
+	}
+	return policy == nil || policy.Allows(identity, book, op)
+}
+
+// Enforced when callers are authenticated and no policy is given
+var defaultPolicy = DefaultPolicy()
+
+// Rejects requests of callers not allowed the operation with 403. Requests outside of books count as
+// requests of the default book
+func (r *RestServer) authorize(op Operation, next http.Handler) http.Handler {
+	return r.authorizeIn(op, false, next)
+}
+
+// Rejects requests like authorize on routes addressing a single contact by the id in the path, which
+// shares of the contact may permit too. The contact is recorded in the audit event
+func (r *RestServer) authorizeContact(op Operation, next http.Handler) http.Handler {
+	return r.authorizeIn(op, true, next)
+}
+
+func (r *RestServer) authorizeIn(op Operation, contactRoute bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		book := vars["book"]
+		if book == "" {
+			book = DefaultBook
+		}
+		contactId := 0
+		if contactRoute {
+			contactId, _ = strconv.Atoi(vars["id"])
+			auditContact(req, contactId)
+		}
+
+		if !r.allowed(req, book, op, contactId) {
+			r.auditLog(req, string(op), nil)
+			writeProblem(w, req, Forbidden("operation %s is not permitted", op))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,179 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Identifies callers by the X-Test-Subject header, for testing authorization alone
+type subjectAuthenticator struct{}
+
+func (subjectAuthenticator) Authenticate(req *http.Request) (*server.Identity, error) {
+	subject := req.Header.Get("X-Test-Subject")
+	if subject == "" {
+		return nil, nil
+	}
+	return &server.Identity{Subject: subject, Method: "test"}, nil
+}
+
+func (subjectAuthenticator) Challenge() string {
+	return "Test"
+}
+
+func TestRbacMatrix(t *testing.T) {
+	policy := server.DefaultPolicy()
+	policy.Subjects["test:ed"] = []string{server.RoleEditor}
+	policy.Subjects["test:ada"] = []string{server.RoleAdmin}
+	policy.Subjects["test:nobody"] = []string{}
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+		// Least privileged role allowed, callers below get 403
+		role string
+	}{
+		{"GET", "/v1/contacts", "", server.RoleViewer},
+		{"GET", "/v1/contacts/1", "", server.RoleViewer},
+		{"GET", "/v1/contacts/search/email/john.lennon@thebeatles.com", "", server.RoleViewer},
+		{"GET", "/v1/contacts/duplicates", "", server.RoleViewer},
+		{"GET", "/v1/contacts/export.csv", "", server.RoleViewer},
+		{"GET", "/contacts/1.vcf", "", server.RoleViewer},
+		{"POST", "/v1/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`, server.RoleEditor},
+		{"PUT", "/v1/contacts/1", `{"name":"John","lastName":"Lennon","email":"john@beatles.com"}`, server.RoleEditor},
+		{"PATCH", "/v1/contacts/1", `{"notes":"Imagine"}`, server.RoleEditor},
+		{"DELETE", "/v2/contacts/1", "", server.RoleEditor},
+		{"POST", "/v1/contacts/import.csv", "name,lastName,email\nPete,Best,pete@beatles.com\n", server.RoleEditor},
+		{"GET", "/admin/backup", "", server.RoleAdmin},
+		{"GET", "/admin/keys", "", server.RoleAdmin},
+	}
+	callers := []struct {
+		subject string
+		roles   []string
+	}{
+		{"intern", []string{server.RoleViewer}},
+		{"ed", []string{server.RoleViewer, server.RoleEditor}},
+		{"ada", []string{server.RoleViewer, server.RoleEditor, server.RoleAdmin}},
+		{"nobody", nil},
+	}
+
+	for _, caller := range callers {
+		for _, request := range requests {
+			allowed := false
+			for _, role := range caller.roles {
+				allowed = allowed || role == request.role
+			}
+
+			t.Run(caller.subject+" "+request.method+" "+request.path, func(t *testing.T) {
+				db := server.NewMemoryDatabase()
+				require.NoError(t, db.LoadFixtures())
+				restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+				require.NoError(t, err)
+				testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+				defer testServer.Close()
+
+				req, err := http.NewRequest(request.method, testServer.URL+request.path, strings.NewReader(request.body))
+				require.NoError(t, err)
+				req.Header.Set("X-Test-Subject", caller.subject)
+				if request.method == "PATCH" {
+					req.Header.Set("Content-Type", server.MergePatchContentType)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				if allowed {
+					// API keys are not enabled, so listing them is not found
+					assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode)
+				} else {
+					assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+					assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+				}
+			})
+		}
+	}
+}
+
+func TestRestDefaultPolicy(t *testing.T) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	// Authenticated callers without a policy are viewers, whatever roles their credentials claim
+	testServer := httptest.NewServer(restServer.WithAuthenticator(roleAuthenticator{}).Handler())
+	defer testServer.Close()
+
+	do := func(method string, path string) int {
+		req, err := http.NewRequest(method, testServer.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, do("GET", "/v1/contacts/1"))
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/v1/contacts/1"))
+	assert.Equal(t, http.StatusForbidden, do("GET", "/admin/backup"))
+}
+
+// Authenticates every request as an admin by the roles of its credentials
+type roleAuthenticator struct{}
+
+func (roleAuthenticator) Authenticate(req *http.Request) (*server.Identity, error) {
+	return &server.Identity{Subject: "root", Method: "test", Roles: []string{server.RoleAdmin}}, nil
+}
+
+func (roleAuthenticator) Challenge() string {
+	return "Test"
+}
+
+func TestPolicyRoles(t *testing.T) {
+	policy := server.DefaultPolicy()
+	policy.Subjects["jwt:jane"] = []string{server.RoleEditor}
+
+	assert.Equal(t, []string{server.RoleViewer}, policy.RolesOf(nil, server.DefaultBook))
+	assert.Equal(t, []string{server.RoleEditor}, policy.RolesOf(&server.Identity{Subject: "jane", Method: "jwt"}, server.DefaultBook))
+	// Subjects are scoped by authentication method
+	assert.Equal(t, []string{server.RoleViewer}, policy.RolesOf(&server.Identity{Subject: "jane", Method: "apikey"}, server.DefaultBook))
+
+	// Roles of tokens only count when the policy trusts them, those of API keys were granted by the server
+	tokenAdmin := &server.Identity{Subject: "joe", Method: "jwt", Roles: []string{server.RoleAdmin}}
+	assert.False(t, policy.Allows(tokenAdmin, server.DefaultBook, server.OpAdmin))
+	assert.True(t, policy.Allows(&server.Identity{Subject: "ab12", Method: "apikey", Roles: []string{server.RoleAdmin}}, server.DefaultBook, server.OpAdmin))
+	policy.TokenRoles = true
+	assert.True(t, policy.Allows(tokenAdmin, server.DefaultBook, server.OpAdmin))
+	assert.False(t, policy.Allows(&server.Identity{Subject: "joe", Method: "jwt", Roles: []string{"root"}}, server.DefaultBook, server.OpAdmin))
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+roles:
+  intern: [findAll, search]
+  admin: ["*"]
+subjects:
+  apikey:ab12: [admin]
+defaultRoles: [intern]
+`), 0600))
+	policy, err := server.LoadPolicy(path)
+	require.NoError(t, err)
+	assert.True(t, policy.Allows(nil, server.DefaultBook, server.OpSearch))
+	assert.False(t, policy.Allows(nil, server.DefaultBook, server.OpDelete))
+	assert.True(t, policy.Allows(&server.Identity{Subject: "ab12", Method: "apikey"}, server.DefaultBook, server.OpDelete))
+
+	require.NoError(t, os.WriteFile(path, []byte("roles:\n  intern: [findAll, drop]\n"), 0600))
+	_, err = server.LoadPolicy(path)
+	assert.EqualError(t, err, `role intern: unknown operation "drop"`)
+
+	require.NoError(t, os.WriteFile(path, []byte("roles:\n  intern: [findAll]\ndefaultRoles: [viewer]\n"), 0600))
+	_, err = server.LoadPolicy(path)
+	assert.EqualError(t, err, `defaultRoles: unknown role "viewer"`)
+}
@@ -1,34 +1,109 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type RestServer struct {
+	// Database of the default book
 	db    ContactDatabase
-	audit *log.Logger
-}
-
-type auditLog struct {
-	Op   string      `json:"op"`
-	Data interface{} `json:"data"`
+	books *Books
+	audit *AuditLog
+	// Further destinations of audit events, fed asynchronously. The audit log itself is written
+	// synchronously, so no event is lost or dropped
+	auditSinks *AuditFanOut
+
+	apiKeys *ApiKeyStore
+	// Requests are rejected unless one of them identifies the caller, none disables authentication
+	authenticators []Authenticator
+	// Operations callers may perform. When nil, authenticated callers are held to DefaultPolicy and
+	// everything is permitted without authentication
+	policy *Policy
 }
 
 func NewRestServer(db ContactDatabase, auditFile string) (*RestServer, error) {
-	file, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE, 0700)
+	audit, err := OpenAuditLog(auditFile)
 	if err != nil {
 		return nil, err
 	}
-	auditLogger := log.New(file, "", log.LstdFlags|log.Lshortfile)
-	return &RestServer{db: db, audit: auditLogger}, nil
+	books := NewBooks(db, func(name string) (ContactDatabase, error) {
+		return NewMemoryDatabase(), nil
+	})
+	return &RestServer{db: db, books: books, audit: audit}, nil
+}
+
+// Rotates the audit log according to rotation
+func (r *RestServer) WithAuditRotation(rotation AuditRotation) *RestServer {
+	r.audit.WithRotation(rotation)
+	return r
+}
+
+// Also sends audit events to sinks, each buffering up to bufferSize events
+func (r *RestServer) WithAuditSinks(bufferSize int, sinks ...AuditSink) *RestServer {
+	r.auditSinks = NewAuditFanOut(bufferSize, sinks...)
+	return r
+}
+
+// Delivers buffered audit events and closes the audit log and sinks
+func (r *RestServer) Close() error {
+	if r.auditSinks != nil {
+		if err := r.auditSinks.Close(); err != nil {
+			return err
+		}
+	}
+	return r.audit.Close()
+}
+
+// Signs a checkpoint of the audit log with key after every given number of records
+func (r *RestServer) WithAuditCheckpoints(key []byte, every int) *RestServer {
+	r.audit.WithCheckpoints(key, every)
+	return r
+}
+
+// Creates databases of new books with newDatabase, books are kept in memory by default
+func (r *RestServer) WithBookDatabases(newDatabase func(name string) (ContactDatabase, error)) *RestServer {
+	r.books.newDatabase = newDatabase
+	return r
+}
+
+// Address books served, including the default one
+func (r *RestServer) Books() *Books {
+	return r.books
+}
+
+// Requires an API key on every request except public ones, keys are managed by /admin/keys
+func (r *RestServer) WithApiKeys(store *ApiKeyStore) *RestServer {
+	r.apiKeys = store
+	return r.WithAuthenticator(store)
+}
+
+// Adds a way of authenticating requests, enabling authentication
+func (r *RestServer) WithAuthenticator(authenticator Authenticator) *RestServer {
+	r.authenticators = append(r.authenticators, authenticator)
+	return r
+}
+
+// Enforces role-based access control of the policy
+func (r *RestServer) WithPolicy(policy *Policy) *RestServer {
+	r.policy = policy
+	return r
 }
 
 func writeJson(data interface{}, w http.ResponseWriter) error {
@@ -38,26 +113,41 @@ func writeJson(data interface{}, w http.ResponseWriter) error {
 		return err
 	}
 
-	fmt.Fprintf(w, "%+v", string(resp))
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	return err
 }
 
 type appHandler func(http.ResponseWriter, *http.Request) error
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := fn(w, r); err != nil {
-		http.Error(w, err.Error(), 500)
+		writeProblem(w, r, err)
 	}
 }
 
-func (r *RestServer) auditLog(op string, data interface{}) {
-	log, _ := json.Marshal(auditLog{Op: op, Data: data})
-	r.audit.Println(string(log))
+func (r *RestServer) findAll(w http.ResponseWriter, req *http.Request) error {
+	r.auditLog(req, "findAll", nil)
+	contacts := r.dbOf(req).FindAll()
+	return writeResponse(w, req, http.StatusOK, apiVersionOf(req).encodeContacts(contacts), contacts)
 }
 
-func (r *RestServer) findAll(w http.ResponseWriter, req *http.Request) error {
-	r.auditLog("findAll", nil)
-	return writeJson(r.db.FindAll(), w)
+// Reads contact in the format of the request's content type and representation of its API version
+func readContactBody(req *http.Request) (Contact, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return Contact{}, BadRequest("invalid request body: %s", err)
+	}
+	return decodeContact(apiVersionOf(req), req.Header.Get("Content-Type"), body)
+}
+
+// Writes contact in the negotiated format and representation of the request's API version
+func writeContact(w http.ResponseWriter, req *http.Request, status int, contact Contact) error {
+	return writeResponse(w, req, status, apiVersionOf(req).EncodeContact(contact), []Contact{contact})
+}
+
+func writeContacts(w http.ResponseWriter, req *http.Request, contacts []Contact) error {
+	return writeResponse(w, req, http.StatusOK, apiVersionOf(req).encodeContacts(contacts), contacts)
 }
 
 func (r *RestServer) findById(w http.ResponseWriter, req *http.Request) error {
@@ -65,18 +155,17 @@ func (r *RestServer) findById(w http.ResponseWriter, req *http.Request) error {
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return errors.New("invalid id")
+		return BadRequest("invalid id %q", idStr)
 	}
 
-	r.auditLog("findById", id)
+	r.auditLog(req, "findById", nil)
 
-	contact := r.db.FindById(id)
+	contact := r.dbOf(req).FindById(id)
 	if contact == nil {
-		w.WriteHeader(404)
-		return nil
+		return NotFound("contact %d not found", id)
 	}
 
-	return writeJson(contact, w)
+	return writeContact(w, req, http.StatusOK, *contact)
 }
 
 func (r *RestServer) deleteById(w http.ResponseWriter, req *http.Request) error {
@@ -84,81 +173,608 @@ func (r *RestServer) deleteById(w http.ResponseWriter, req *http.Request) error
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return errors.New("invalid id")
+		return BadRequest("invalid id %q", idStr)
 	}
 
-	r.auditLog("deleteById", id)
+	r.auditLog(req, "deleteById", nil)
 
-	if !r.db.Delete(Contact{Id: id}) {
-		w.WriteHeader(404)
-		return nil
+	if !r.dbOf(req).Delete(Contact{Id: id}) {
+		return NotFound("contact %d not found", id)
 	}
+	r.books.forgetContacts(bookOf(req), id)
 
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
 func (r *RestServer) create(w http.ResponseWriter, req *http.Request) error {
-	var contact Contact
-	if err := json.NewDecoder(req.Body).Decode(&contact); err != nil {
+	contact, err := readContactBody(req)
+	if err != nil {
 		return err
 	}
 
 	if err := contact.Validate(); err != nil {
-		return err
+		return Invalid(err)
 	}
 
-	r.auditLog("create", contact.Anonymize())
+	r.auditLog(req, "create", contact.Anonymize())
 
-	contact = r.db.InsertWithNewId(contact)
-	return writeJson(contact, w)
+	contact = r.dbOf(req).InsertWithNewId(contact)
+	auditContact(req, contact.Id)
+	w.Header().Set("Location", strings.TrimSuffix(req.URL.Path, "/")+"/"+strconv.Itoa(contact.Id))
+	return writeContact(w, req, http.StatusCreated, contact)
 }
 
 func (r *RestServer) updateById(w http.ResponseWriter, req *http.Request) error {
-	var contact Contact
-	if err := json.NewDecoder(req.Body).Decode(&contact); err != nil {
+	idStr := mux.Vars(req)["id"]
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return BadRequest("invalid id %q", idStr)
+	}
+
+	contact, err := readContactBody(req)
+	if err != nil {
 		return err
 	}
 
+	// The path identifies the resource, an id in the body may only repeat it
+	if contact.Id == 0 {
+		contact.Id = id
+	} else if contact.Id != id {
+		return BadRequest("contact id %d does not match path id %d", contact.Id, id)
+	}
+
 	if err := contact.Validate(); err != nil {
-		return err
+		return Invalid(err)
 	}
 
-	r.auditLog("updateById", contact.Anonymize())
+	r.auditLog(req, "updateById", nil)
 
-	if !r.db.Update(contact) {
-		w.WriteHeader(404)
-		return nil
+	before := r.dbOf(req).FindById(contact.Id)
+	if before == nil || !r.dbOf(req).Update(contact) {
+		return NotFound("contact %d not found", contact.Id)
 	}
+	auditChanges(req, *before, contact)
 
-	return nil
+	return writeContact(w, req, http.StatusOK, contact)
+}
+
+func (r *RestServer) patchById(w http.ResponseWriter, req *http.Request) error {
+	idStr := mux.Vars(req)["id"]
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return BadRequest("invalid id %q", idStr)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != MergePatchContentType && mediaType != JsonPatchContentType && mediaType != "application/json" {
+		return UnsupportedMediaType("patch must be %s or %s", MergePatchContentType, JsonPatchContentType)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return BadRequest("invalid request body: %s", err)
+	}
+
+	contact := r.dbOf(req).FindById(id)
+	if contact == nil {
+		return NotFound("contact %d not found", id)
+	}
+
+	var patched Contact
+	if mediaType == JsonPatchContentType {
+		var operations []JsonPatchOperation
+		if err := json.Unmarshal(body, &operations); err != nil {
+			return BadRequest("invalid request body: %s", err)
+		}
+		patched, err = apiVersionOf(req).ApplyJsonPatch(*contact, operations)
+	} else {
+		// Plain JSON is accepted as merge patch
+		if !json.Valid(body) {
+			return BadRequest("invalid request body: malformed JSON")
+		}
+		patched, err = apiVersionOf(req).ApplyMergePatch(*contact, body)
+	}
+	if errors.Is(err, ErrPatchTestFailed) {
+		return Conflict("%s", err)
+	}
+	if err != nil {
+		return Invalid(err)
+	}
+	if err := patched.Validate(); err != nil {
+		return Invalid(err)
+	}
+
+	r.auditLog(req, "patchById", nil)
+
+	// The patch applies to the contact as read above, so concurrent changes are not overwritten
+	if !r.dbOf(req).UpdateIf(*contact, patched) {
+		if r.dbOf(req).FindById(id) == nil {
+			return NotFound("contact %d not found", id)
+		}
+		return Conflict("contact %d changed concurrently, retry the patch", id)
+	}
+	auditChanges(req, *contact, patched)
+
+	return writeContact(w, req, http.StatusOK, patched)
 }
 
 func (r *RestServer) searchByEmail(w http.ResponseWriter, req *http.Request) error {
 	email := mux.Vars(req)["email"]
-	r.auditLog("searchByEmail", "*** ANONYMIZED ***")
+	r.auditLog(req, "searchByEmail", "*** ANONYMIZED ***")
 
-	contacts := r.db.FindByEmail(email)
-	return writeJson(contacts, w)
+	contacts := r.dbOf(req).FindByEmail(email)
+	return writeContacts(w, req, contacts)
 }
 
 func (r *RestServer) searchByLastNamePart(w http.ResponseWriter, req *http.Request) error {
 	lastNamePart := mux.Vars(req)["lastNamePart"]
-	r.auditLog("searchByLastNamePart", "*** ANONYMIZED ***")
+	r.auditLog(req, "searchByLastNamePart", "*** ANONYMIZED ***")
 
-	contacts := r.db.FindByLastNameContains(lastNamePart)
-	return writeJson(contacts, w)
+	contacts := r.dbOf(req).FindByLastNameContains(lastNamePart)
+	return writeContacts(w, req, contacts)
 }
 
-func (r *RestServer) Start(port int) {
+func (r *RestServer) findDuplicates(w http.ResponseWriter, req *http.Request) error {
+	threshold := DefaultDuplicateThreshold
+	if thresholdStr := req.URL.Query().Get("threshold"); thresholdStr != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(thresholdStr, 64)
+		if err != nil {
+			return BadRequest("invalid threshold %q", thresholdStr)
+		}
+	}
+
+	r.auditLog(req, "findDuplicates", threshold)
+
+	clusters := NewDuplicateDetector(threshold).FindDuplicates(r.dbOf(req).FindAll())
+	return writeResponse(w, req, http.StatusOK, apiVersionOf(req).encodeClusters(clusters), nil)
+}
+
+type mergeAudit struct {
+	SurvivorId int   `json:"survivorId"`
+	RemovedIds []int `json:"removedIds"`
+}
+
+func (r *RestServer) merge(w http.ResponseWriter, req *http.Request) error {
+	var mergeReq MergeRequest
+	if err := json.NewDecoder(req.Body).Decode(&mergeReq); err != nil {
+		return BadRequest("invalid request body: %s", err)
+	}
+	if err := mergeReq.Validate(); err != nil {
+		return Invalid(err)
+	}
+
+	var contacts []Contact
+	for _, id := range mergeReq.Ids {
+		contact := r.dbOf(req).FindById(id)
+		if contact == nil {
+			return NotFound("contact %d not found", id)
+		}
+		contacts = append(contacts, *contact)
+	}
+
+	survivor, err := MergeContacts(contacts, mergeReq)
+	if err != nil {
+		return Invalid(err)
+	}
+	if err := survivor.Validate(); err != nil {
+		return Invalid(err)
+	}
+
+	var removedIds []int
+	for _, id := range mergeReq.Ids {
+		if id != survivor.Id {
+			removedIds = append(removedIds, id)
+		}
+	}
+
+	// Contacts were found above, so they must have been changed concurrently
+	if !r.dbOf(req).Merge(contacts, survivor) {
+		return Conflict("merged contacts changed during merge")
+	}
+	r.books.forgetContacts(bookOf(req), removedIds...)
+
+	r.auditLog(req, "merge", mergeAudit{SurvivorId: survivor.Id, RemovedIds: removedIds})
+
+	return writeContact(w, req, http.StatusOK, survivor)
+}
+
+func vCardVersion(req *http.Request) (string, error) {
+	version := req.URL.Query().Get("version")
+	if version == "" {
+		return VCardVersion3, nil
+	}
+	if !supportedVCardVersion(version) {
+		return "", BadRequest("unsupported vCard version %q, use %s or %s", version, VCardVersion3, VCardVersion4)
+	}
+	return version, nil
+}
+
+func (r *RestServer) exportVCardById(w http.ResponseWriter, req *http.Request) error {
+	idStr := mux.Vars(req)["id"]
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return BadRequest("invalid id %q", idStr)
+	}
+	version, err := vCardVersion(req)
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "exportVCardById", nil)
+
+	contact := r.dbOf(req).FindById(id)
+	if contact == nil {
+		return NotFound("contact %d not found", id)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteVCard(&buf, *contact, version); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", VCardContentType)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (r *RestServer) exportVCards(w http.ResponseWriter, req *http.Request) error {
+	version, err := vCardVersion(req)
+	if err != nil {
+		return err
+	}
+	r.auditLog(req, "exportVCards", nil)
+
+	contacts := r.dbOf(req).FindAll()
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Id < contacts[j].Id
+	})
+
+	var buf bytes.Buffer
+	if err := WriteVCards(&buf, contacts, version); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", VCardContentType)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (r *RestServer) importVCards(w http.ResponseWriter, req *http.Request) error {
+	contacts, err := ReadVCards(req.Body)
+	if err != nil {
+		return BadRequest("invalid vCard: %s", err)
+	}
+
+	// Reject the whole file rather than importing part of it
+	for i := range contacts {
+		if err := contacts[i].Validate(); err != nil {
+			return Invalid(fmt.Errorf("contact %d: %w", i+1, err))
+		}
+	}
+
+	imported := []Contact{}
+	for _, contact := range contacts {
+		r.auditLog(req, "importVCards", contact.Anonymize())
+		imported = append(imported, r.dbOf(req).InsertWithNewId(contact))
+	}
+
+	return writeContacts(w, req, imported)
+}
+
+// Reads mapping from "mapping" (JSON) or "layout" query parameter - nil means no mapping was given
+func csvMapping(req *http.Request) (*CsvMapping, error) {
+	if mappingStr := req.URL.Query().Get("mapping"); mappingStr != "" {
+		var mapping CsvMapping
+		if err := json.Unmarshal([]byte(mappingStr), &mapping); err != nil {
+			return nil, BadRequest("invalid mapping: %s", err)
+		}
+		return &mapping, nil
+	}
+
+	if layout := req.URL.Query().Get("layout"); layout != "" {
+		mapping, ok := CsvLayouts[layout]
+		if !ok {
+			return nil, BadRequest("unknown layout %q", layout)
+		}
+		return &mapping, nil
+	}
+
+	return nil, nil
+}
+
+func (r *RestServer) exportCsv(w http.ResponseWriter, req *http.Request) error {
+	mapping, err := csvMapping(req)
+	if err != nil {
+		return err
+	}
+	if mapping == nil {
+		defaultMapping := CsvLayouts[CsvLayoutDefault]
+		mapping = &defaultMapping
+	}
+
+	r.auditLog(req, "exportCsv", nil)
+
+	contacts := r.dbOf(req).FindAll()
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Id < contacts[j].Id
+	})
+
+	w.Header().Set("Content-Type", CsvContentType)
+	writer, err := NewCsvWriter(w, *mapping)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		if err := writer.Write(contact); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (r *RestServer) importCsv(w http.ResponseWriter, req *http.Request) error {
+	mapping, err := csvMapping(req)
+	if err != nil {
+		return err
+	}
+
+	report, err := ImportCsv(req.Body, mapping, func(contact Contact) error {
+		r.auditLog(req, "importCsv", contact.Anonymize())
+		r.dbOf(req).InsertWithNewId(contact)
+		return nil
+	})
+	if err != nil {
+		return BadRequest("invalid CSV: %s", err)
+	}
+
+	return writeResponse(w, req, http.StatusOK, report, nil)
+}
+
+func (r *RestServer) exportLdif(w http.ResponseWriter, req *http.Request) error {
+	baseDn := req.URL.Query().Get("baseDn")
+	if baseDn == "" {
+		baseDn = DefaultLdifBaseDn
+	}
+
+	r.auditLog(req, "exportLdif", nil)
+
+	contacts := r.dbOf(req).FindAll()
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Id < contacts[j].Id
+	})
+
+	w.Header().Set("Content-Type", LdifContentType)
+	return WriteLdif(w, contacts, baseDn)
+}
+
+type ldifImportAudit struct {
+	Added    int `json:"added"`
+	Modified int `json:"modified"`
+	Deleted  int `json:"deleted"`
+}
+
+func (r *RestServer) importLdif(w http.ResponseWriter, req *http.Request) error {
+	records, err := ReadLdif(req.Body)
+	if err != nil {
+		return BadRequest("invalid LDIF: %s", err)
+	}
+
+	report := ApplyLdif(r.dbOf(req), records)
+	r.books.forgetContacts(bookOf(req), report.DeletedIds...)
+	r.auditLog(req, "importLdif", ldifImportAudit{Added: report.Added, Modified: report.Modified, Deleted: report.Deleted})
+
+	return writeResponse(w, req, http.StatusOK, report, nil)
+}
+
+func afterIdParam(req *http.Request) (int, error) {
+	afterStr := req.URL.Query().Get("after")
+	if afterStr == "" {
+		return 0, nil
+	}
+	afterId, err := strconv.Atoi(afterStr)
+	if err != nil || afterId < 0 {
+		return 0, BadRequest("invalid after id %q", afterStr)
+	}
+	return afterId, nil
+}
+
+func (r *RestServer) exportNdjson(w http.ResponseWriter, req *http.Request) error {
+	afterId, err := afterIdParam(req)
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "exportNdjson", afterId)
+
+	w.Header().Set("Content-Type", NdjsonContentType)
+	w.Header().Set("X-Total-Count", strconv.Itoa(r.dbOf(req).Count()))
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+
+	_, err = ExportNdjson(w, r.dbOf(req), afterId, flush)
+	return err
+}
+
+func (r *RestServer) importNdjson(w http.ResponseWriter, req *http.Request) error {
+	afterId, err := afterIdParam(req)
+	if err != nil {
+		return err
+	}
+
+	// HTTP/1.x does not allow writing the response before the request body is consumed,
+	// so failures are collected and sent together with the final summary
+	var failures []NdjsonImportProgress
+	final, err := ImportNdjson(req.Body, r.dbOf(req), afterId, func(event NdjsonImportProgress) error {
+		if event.Error != "" && len(failures) < maxNdjsonReportedFailures {
+			failures = append(failures, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "importNdjson", final)
+
+	w.Header().Set("Content-Type", NdjsonContentType)
+	encoder := json.NewEncoder(w)
+	for _, event := range append(failures, final) {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RestServer) backup(w http.ResponseWriter, req *http.Request) error {
+	db, err := r.adminDb(req)
+	if err != nil {
+		return err
+	}
+	r.auditLog(req, "backup", req.URL.Query().Get("book"))
+
+	backup, err := NewBackup(db, map[string]string{"source": req.Host})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="contacts-backup.json"`)
+	return WriteBackup(w, backup)
+}
+
+type restoreResult struct {
+	Restored  int `json:"restored"`
+	HighestId int `json:"highestId"`
+}
+
+func (r *RestServer) restore(w http.ResponseWriter, req *http.Request) error {
+	db, err := r.adminDb(req)
+	if err != nil {
+		return err
+	}
+	backup, err := ReadBackup(req.Body)
+	if err != nil {
+		return BadRequest("invalid backup: %s", err)
+	}
+
+	result := restoreResult{Restored: backup.Count, HighestId: backup.HighestId}
+	r.auditLog(req, "restore", result)
+
+	// Restored contacts reuse ids of different people, so shares of single contacts must not carry over
+	name := req.URL.Query().Get("book")
+	if name == "" {
+		name = DefaultBook
+	}
+	if err := RestoreBackup(db, backup); err != nil {
+		return err
+	}
+	r.books.forgetAllContacts(name)
+	return writeJson(result, w)
+}
+
+// Routes of contacts, which differ between API versions
+func (r *RestServer) contactRoutes(router *mux.Router) {
+	router.Handle("/contacts", r.authorize(OpFindAll, negotiated(true, appHandler(r.findAll)))).Methods("GET")
+	router.Handle("/contacts", r.authorize(OpCreate, negotiated(true, appHandler(r.create)))).Methods("POST")
+	router.Handle("/contacts/duplicates", r.authorize(OpFindDuplicates, negotiated(false, appHandler(r.findDuplicates)))).Methods("GET")
+	router.Handle("/contacts/merge", r.authorize(OpMerge, negotiated(true, appHandler(r.merge)))).Methods("POST")
+	router.Handle("/contacts/export.vcf", r.authorize(OpExport, appHandler(r.exportVCards))).Methods("GET")
+	router.Handle("/contacts/import", r.authorize(OpImport, negotiated(true, appHandler(r.importVCards)))).Methods("POST")
+	router.Handle("/contacts/export.csv", r.authorize(OpExport, appHandler(r.exportCsv))).Methods("GET")
+	router.Handle("/contacts/import.csv", r.authorize(OpImport, negotiated(false, appHandler(r.importCsv)))).Methods("POST")
+	router.Handle("/contacts/export.ldif", r.authorize(OpExport, appHandler(r.exportLdif))).Methods("GET")
+	router.Handle("/contacts/import.ldif", r.authorize(OpImport, negotiated(false, appHandler(r.importLdif)))).Methods("POST")
+	router.Handle("/contacts/export.ndjson", r.authorize(OpExport, appHandler(r.exportNdjson))).Methods("GET")
+	router.Handle("/contacts/import.ndjson", r.authorize(OpImport, appHandler(r.importNdjson))).Methods("POST")
+	router.Handle("/contacts/{id:[0-9]+}.vcf", r.authorizeContact(OpExport, appHandler(r.exportVCardById))).Methods("GET")
+	router.Handle("/contacts/{id}", r.authorizeContact(OpFindById, negotiated(true, appHandler(r.findById)))).Methods("GET")
+	router.Handle("/contacts/{id}", r.authorizeContact(OpDelete, appHandler(r.deleteById))).Methods("DELETE")
+	router.Handle("/contacts/{id}", r.authorizeContact(OpUpdate, negotiated(true, appHandler(r.updateById)))).Methods("PUT")
+	router.Handle("/contacts/{id}", r.authorizeContact(OpPatch, negotiated(true, appHandler(r.patchById)))).Methods("PATCH")
+
+	router.Handle("/contacts/search/email/{email}", r.authorize(OpSearch, negotiated(true, appHandler(r.searchByEmail)))).Methods("GET")
+	router.Handle("/contacts/search/lastNamePart/{lastNamePart}", r.authorize(OpSearch, negotiated(true, appHandler(r.searchByLastNamePart)))).Methods("GET")
+}
+
+// Routes of contacts of books other than the default one
+func (r *RestServer) bookRoutes(router *mux.Router) {
+	bookRouter := router.PathPrefix("/books/{book}").Subrouter()
+	bookRouter.Use(r.withBook)
+	r.contactRoutes(bookRouter)
+}
+
+// Routes of the REST API
+func (r *RestServer) Handler() http.Handler {
 	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/contacts", appHandler(r.findAll).ServeHTTP).Methods("GET")
-	router.HandleFunc("/contacts", appHandler(r.create).ServeHTTP).Methods("POST")
-	router.HandleFunc("/contacts/{id}", appHandler(r.findById).ServeHTTP).Methods("GET")
-	router.HandleFunc("/contacts/{id}", appHandler(r.deleteById).ServeHTTP).Methods("DELETE")
-	router.HandleFunc("/contacts/{id}", appHandler(r.updateById).ServeHTTP).Methods("PUT")
+	router.Use(r.audited)
+	if len(r.authenticators) > 0 {
+		router.Use(func(next http.Handler) http.Handler { return authenticated(r.authenticators, next) })
+	}
+
+	for _, version := range ApiVersions {
+		versionRouter := router.PathPrefix(version.Prefix).Subrouter()
+		versionRouter.Use(withApiVersion(version))
+		r.contactRoutes(versionRouter)
+		r.bookRoutes(versionRouter)
+	}
 
-	router.HandleFunc("/contacts/search/email/{email}", appHandler(r.searchByEmail).ServeHTTP).Methods("GET")
-	router.HandleFunc("/contacts/search/lastNamePart/{lastNamePart}", appHandler(r.searchByLastNamePart).ServeHTTP).Methods("GET")
+	router.Handle("/books", r.authorize(OpListBooks, appHandler(r.listBooks))).Methods("GET")
+	// Anyone may create personal books, team books are checked by the handler
+	router.Handle("/books", appHandler(r.createBook)).Methods("POST")
+	router.Handle("/books/{book}", r.authorize(OpAdmin, appHandler(r.deleteBook))).Methods("DELETE")
+	router.Handle("/books/{book}/shares", r.authorize(OpAdmin, appHandler(r.listShares))).Methods("GET")
+	router.Handle("/books/{book}/shares/{subject}", r.authorize(OpAdmin, appHandler(r.shareBook))).Methods("PUT")
+	router.Handle("/books/{book}/shares/{subject}", r.authorize(OpAdmin, appHandler(r.unshareBook))).Methods("DELETE")
+	router.Handle("/books/{book}/contacts/{id}/shares/{subject}", r.authorizeContact(OpAdmin, appHandler(r.shareContact))).Methods("PUT")
+	router.Handle("/books/{book}/contacts/{id}/shares/{subject}", r.authorizeContact(OpAdmin, appHandler(r.unshareContact))).Methods("DELETE")
+	router.Handle("/shared", appHandler(r.sharedWithMe)).Methods("GET")
+
+	router.Handle("/admin/backup", r.authorize(OpAdmin, appHandler(r.backup))).Methods("GET")
+	router.Handle("/admin/restore", r.authorize(OpAdmin, appHandler(r.restore))).Methods("POST")
+	router.Handle("/admin/keys", r.authorize(OpAdmin, appHandler(r.listApiKeys))).Methods("GET")
+	router.Handle("/admin/keys", r.authorize(OpAdmin, appHandler(r.createApiKey))).Methods("POST")
+	router.Handle("/admin/keys/{id}", r.authorize(OpAdmin, appHandler(r.revokeApiKey))).Methods("DELETE")
+	router.Handle("/admin/audit", r.authorize(OpAdmin, appHandler(r.queryAudit))).Methods("GET")
+
+	router.HandleFunc("/openapi.json", appHandler(r.openApi).ServeHTTP).Methods("GET")
+	router.HandleFunc("/docs", appHandler(r.docs).ServeHTTP).Methods("GET")
+
+	// Unversioned routes predate versioning and keep serving v1
+	legacyRouter := router.NewRoute().Subrouter()
+	legacyRouter.Use(deprecated)
+	r.contactRoutes(legacyRouter)
+	r.bookRoutes(legacyRouter)
+
+	return router
+}
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), router))
+// Serves until SIGINT or SIGTERM, then completes requests in flight and flushes the audit log and
+// sinks before returning
+func (r *RestServer) Start(port int) {
+	httpServer := &http.Server{Addr: ":" + strconv.Itoa(port), Handler: r.Handler()}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Shutting down: %s", err)
+		}
+	}()
+
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-stopped
+	if err := r.Close(); err != nil {
+		log.Fatal(err)
+	}
 }
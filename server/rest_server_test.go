@@ -0,0 +1,157 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(db, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(restServer.Handler())
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func doRequest(t *testing.T, method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRestStatusCodes(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp := doRequest(t, "POST", testServer.URL+"/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var created server.Contact
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, "/contacts/5", resp.Header.Get("Location"))
+	assert.Equal(t, 5, created.Id)
+
+	resp = doRequest(t, "GET", testServer.URL+resp.Header.Get("Location"), "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	resp = doRequest(t, "PUT", testServer.URL+"/contacts/5", `{"name":"Peter","lastName":"Best","email":"pete@beatles.com"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var updated server.Contact
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+	assert.Equal(t, server.Contact{Id: 5, Name: "Peter", LastName: "Best", Email: "pete@beatles.com"}, updated)
+
+	resp = doRequest(t, "PUT", testServer.URL+"/contacts/5", `{"id":4,"name":"Peter","lastName":"Best","email":"pete@beatles.com"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doRequest(t, "DELETE", testServer.URL+"/contacts/5", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doRequest(t, "DELETE", testServer.URL+"/contacts/5", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRestPatch(t *testing.T) {
+	testServer := newTestServer(t)
+
+	patch := func(contentType string, body string) *http.Response {
+		req, err := http.NewRequest("PATCH", testServer.URL+"/contacts/1", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := patch(server.MergePatchContentType, `{"email":"lennon@beatles.com"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var patched server.Contact
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&patched))
+	assert.Equal(t, "lennon@beatles.com", patched.Email)
+	assert.Equal(t, "Lennon", patched.LastName)
+
+	resp = patch(server.JsonPatchContentType, `[{"op":"test","path":"/email","value":"john@beatles.com"}]`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = patch(server.JsonPatchContentType, `[{"op":"remove","path":"/email"}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	resp = patch("text/plain", `email=x`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp = doRequest(t, "GET", testServer.URL+"/contacts/1", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&patched))
+	assert.Equal(t, "lennon@beatles.com", patched.Email)
+}
+
+// Runs race once, right after the first contact was read, as a concurrent request would
+type racingDatabase struct {
+	*server.MemoryDatabase
+	once sync.Once
+	race func(db *server.MemoryDatabase)
+}
+
+func (db *racingDatabase) FindById(id int) *server.Contact {
+	contact := db.MemoryDatabase.FindById(id)
+	db.once.Do(func() { db.race(db.MemoryDatabase) })
+	return contact
+}
+
+func newRacingTestServer(t *testing.T, race func(db *server.MemoryDatabase)) (*httptest.Server, *server.MemoryDatabase) {
+	db := server.NewMemoryDatabase()
+	require.NoError(t, db.LoadFixtures())
+	restServer, err := server.NewRestServer(&racingDatabase{MemoryDatabase: db, race: race}, filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(restServer.Handler())
+	t.Cleanup(testServer.Close)
+	return testServer, db
+}
+
+func TestRestPatchConcurrentChange(t *testing.T) {
+	testServer, db := newRacingTestServer(t, func(db *server.MemoryDatabase) {
+		contact := db.FindById(1)
+		contact.Notes = "Imagine"
+		db.Update(*contact)
+	})
+
+	req, err := http.NewRequest("PATCH", testServer.URL+"/contacts/1", strings.NewReader(`{"email":"lennon@beatles.com"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", server.MergePatchContentType)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "Imagine", db.FindById(1).Notes)
+	assert.Equal(t, "john.lennon@thebeatles.com", db.FindById(1).Email)
+}
+
+func TestRestMergeConcurrentChange(t *testing.T) {
+	// Contact 1 changes after it was read for the merge
+	testServer, db := newRacingTestServer(t, func(db *server.MemoryDatabase) {
+		contact := db.FindById(1)
+		contact.Notes = "Imagine"
+		db.Update(*contact)
+	})
+
+	resp := doRequest(t, "POST", testServer.URL+"/v1/contacts/merge", `{"ids":[1,2],"survivorId":2}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "Imagine", db.FindById(1).Notes)
+	assert.Equal(t, 4, db.Count())
+}
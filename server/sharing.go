@@ -0,0 +1,352 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Access granted to a whole book or a single contact of it
+type Access string
+
+const (
+	AccessRead  Access = "read"
+	AccessWrite Access = "write"
+
+	// Owners of personal books may do anything in them, it cannot be granted
+	accessOwner Access = "owner"
+)
+
+var (
+	readOperations  = []Operation{OpFindAll, OpFindById, OpSearch, OpFindDuplicates, OpExport, OpListBooks}
+	writeOperations = []Operation{OpCreate, OpUpdate, OpPatch, OpDelete, OpMerge, OpImport}
+)
+
+func containsOperation(ops []Operation, op Operation) bool {
+	for _, candidate := range ops {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateAccess(access Access) error {
+	if access != AccessRead && access != AccessWrite {
+		return &ValidationError{Field: "access", Message: "access must be read or write"}
+	}
+	return nil
+}
+
+// Whether access to the whole book permits the operation
+func (a Access) permits(op Operation) bool {
+	switch a {
+	case accessOwner:
+		return true
+	case AccessWrite:
+		return containsOperation(readOperations, op) || containsOperation(writeOperations, op)
+	case AccessRead:
+		return containsOperation(readOperations, op)
+	}
+	return false
+}
+
+// Whether access to a single contact permits the operation on it. Deleting and merging contacts
+// stays with those having access to the book
+func (a Access) permitsContact(op Operation) bool {
+	switch a {
+	case AccessWrite:
+		return op == OpFindById || op == OpExport || op == OpUpdate || op == OpPatch
+	case AccessRead:
+		return op == OpFindById || op == OpExport
+	}
+	return false
+}
+
+// Access of a subject, to the whole book unless ContactId is set
+type Share struct {
+	Subject   string `json:"subject"`
+	ContactId int    `json:"contactId,omitempty"`
+	Access    Access `json:"access"`
+}
+
+// Book or contact shared with the caller
+type SharedItem struct {
+	Book      string `json:"book"`
+	Owner     string `json:"owner,omitempty"`
+	ContactId int    `json:"contactId,omitempty"`
+	Access    Access `json:"access"`
+}
+
+// Owner of the book and the access of the caller to it and to the contact of given id
+func (b *Books) access(name string, identity *Identity, contactId int) (owner string, book Access, contact Access) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return "", "", ""
+	}
+	if identity == nil {
+		return entry.owner, "", ""
+	}
+	key := identity.Key()
+	if entry.owner != "" && entry.owner == key {
+		return entry.owner, accessOwner, ""
+	}
+	return entry.owner, entry.grants[key], entry.contactGrants[contactId][key]
+}
+
+// Grants subject access to the whole book, returning false when there is no book of the name
+func (b *Books) Share(name string, subject string, access Access) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return false
+	}
+	entry.grants[subject] = access
+	return true
+}
+
+// Revokes access of subject to the whole book, returning false when it had none
+func (b *Books) Unshare(name string, subject string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return false
+	}
+	if _, ok := entry.grants[subject]; !ok {
+		return false
+	}
+	delete(entry.grants, subject)
+	return true
+}
+
+// Grants subject access to a single contact, returning false when there is no book of the name
+func (b *Books) ShareContact(name string, contactId int, subject string, access Access) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return false
+	}
+	if entry.contactGrants[contactId] == nil {
+		entry.contactGrants[contactId] = make(map[string]Access)
+	}
+	entry.contactGrants[contactId][subject] = access
+	return true
+}
+
+// Revokes access of subject to a single contact, returning false when it had none
+func (b *Books) UnshareContact(name string, contactId int, subject string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return false
+	}
+	if _, ok := entry.contactGrants[contactId][subject]; !ok {
+		return false
+	}
+	delete(entry.contactGrants[contactId], subject)
+	if len(entry.contactGrants[contactId]) == 0 {
+		delete(entry.contactGrants, contactId)
+	}
+	return true
+}
+
+// Drops all shares of removed contacts, so contacts reusing their ids are not shared
+func (b *Books) forgetContacts(name string, contactIds ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry, ok := b.books[name]; ok {
+		for _, id := range contactIds {
+			delete(entry.contactGrants, id)
+		}
+	}
+}
+
+// Drops all contact shares of a book whose contacts are replaced as a whole, shares of the book
+// itself are kept
+func (b *Books) forgetAllContacts(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry, ok := b.books[name]; ok {
+		entry.contactGrants = make(map[int]map[string]Access)
+	}
+}
+
+// Shares of the book and its contacts ordered by contact and subject, false when there is no book
+// of the name
+func (b *Books) Shares(name string) ([]Share, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entry, ok := b.books[name]
+	if !ok {
+		return nil, false
+	}
+
+	shares := []Share{}
+	for subject, access := range entry.grants {
+		shares = append(shares, Share{Subject: subject, Access: access})
+	}
+	for id, grants := range entry.contactGrants {
+		for subject, access := range grants {
+			shares = append(shares, Share{Subject: subject, ContactId: id, Access: access})
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].ContactId != shares[j].ContactId {
+			return shares[i].ContactId < shares[j].ContactId
+		}
+		return shares[i].Subject < shares[j].Subject
+	})
+	return shares, true
+}
+
+// Books and contacts shared with the subject, ordered by book and contact
+func (b *Books) SharedWith(subject string) []SharedItem {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	items := []SharedItem{}
+	for name, entry := range b.books {
+		if access, ok := entry.grants[subject]; ok {
+			items = append(items, SharedItem{Book: name, Owner: entry.owner, Access: access})
+		}
+		for id, grants := range entry.contactGrants {
+			if access, ok := grants[subject]; ok {
+				items = append(items, SharedItem{Book: name, Owner: entry.owner, ContactId: id, Access: access})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Book != items[j].Book {
+			return items[i].Book < items[j].Book
+		}
+		return items[i].ContactId < items[j].ContactId
+	})
+	return items
+}
+
+type shareRequest struct {
+	Access Access `json:"access"`
+}
+
+// Reads the access of share requests and the subject, an identity key like "jwt:jane"
+func readShareRequest(req *http.Request) (string, Access, error) {
+	subject := mux.Vars(req)["subject"]
+	if i := strings.Index(subject, ":"); i <= 0 || i == len(subject)-1 {
+		return "", "", Invalid(&ValidationError{Field: "subject", Message: "subject must be <method>:<subject>, e.g. jwt:jane"})
+	}
+
+	var shareReq shareRequest
+	if err := json.NewDecoder(req.Body).Decode(&shareReq); err != nil {
+		return "", "", BadRequest("invalid request body: %s", err)
+	}
+	if err := ValidateAccess(shareReq.Access); err != nil {
+		return "", "", Invalid(err)
+	}
+	return subject, shareReq.Access, nil
+}
+
+func (r *RestServer) listShares(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	r.auditLog(req, "listShares", name)
+
+	shares, ok := r.books.Shares(name)
+	if !ok {
+		return NotFound("book %s not found", name)
+	}
+	return writeJson(shares, w)
+}
+
+func (r *RestServer) shareBook(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	subject, access, err := readShareRequest(req)
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "shareBook", Share{Subject: subject, Access: access})
+
+	if !r.books.Share(name, subject, access) {
+		return NotFound("book %s not found", name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r *RestServer) unshareBook(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	subject := mux.Vars(req)["subject"]
+	r.auditLog(req, "unshareBook", subject)
+
+	if !r.books.Unshare(name, subject) {
+		return NotFound("book %s is not shared with %s", name, subject)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r *RestServer) shareContact(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	idStr := mux.Vars(req)["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return BadRequest("invalid id %q", idStr)
+	}
+	subject, access, err := readShareRequest(req)
+	if err != nil {
+		return err
+	}
+
+	r.auditLog(req, "shareContact", Share{Subject: subject, ContactId: id, Access: access})
+
+	db := r.books.Database(name)
+	if db == nil {
+		return NotFound("book %s not found", name)
+	}
+	if db.FindById(id) == nil {
+		return NotFound("contact %d not found", id)
+	}
+	if !r.books.ShareContact(name, id, subject, access) {
+		return NotFound("book %s not found", name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r *RestServer) unshareContact(w http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["book"]
+	idStr := mux.Vars(req)["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return BadRequest("invalid id %q", idStr)
+	}
+	subject := mux.Vars(req)["subject"]
+
+	r.auditLog(req, "unshareContact", Share{Subject: subject, ContactId: id})
+
+	if !r.books.UnshareContact(name, id, subject) {
+		return NotFound("contact %d is not shared with %s", id, subject)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Books and contacts others shared with the caller, empty without authentication
+func (r *RestServer) sharedWithMe(w http.ResponseWriter, req *http.Request) error {
+	r.auditLog(req, "sharedWithMe", nil)
+
+	identity := IdentityOf(req)
+	if identity == nil {
+		return writeJson([]SharedItem{}, w)
+	}
+	return writeJson(r.books.SharedWith(identity.Key()), w)
+}
@@ -0,0 +1,174 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestSharing(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	// Everybody may edit team books, personal books are private nonetheless
+	policy := server.DefaultPolicy()
+	policy.DefaultRoles = []string{server.RoleEditor}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(subject string, method string, path string, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", subject)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/books", `{"name":"ann","personal":true}`).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "POST", "/books", `{"name":"team"}`).StatusCode)
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/v1/books/ann/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`).StatusCode)
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/v1/books/ann/contacts", `{"name":"Stuart","lastName":"Sutcliffe","email":"stu@beatles.com"}`).StatusCode)
+
+	assert.Equal(t, http.StatusForbidden, do("bob", "GET", "/v1/books/ann/contacts", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "PUT", "/books/ann/shares/test:bob", `{"access":"read"}`).StatusCode)
+
+	// Single contact shared read-only
+	assert.Equal(t, http.StatusUnprocessableEntity, do("ann", "PUT", "/books/ann/contacts/1/shares/test:bob", `{"access":"all"}`).StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, do("ann", "PUT", "/books/ann/contacts/1/shares/bob", `{"access":"read"}`).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do("ann", "PUT", "/books/ann/contacts/9/shares/test:bob", `{"access":"read"}`).StatusCode)
+	require.Equal(t, http.StatusNoContent, do("ann", "PUT", "/books/ann/contacts/1/shares/test:bob", `{"access":"read"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "GET", "/v1/books/ann/contacts/2", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "GET", "/v1/books/ann/contacts", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("bob", "PATCH", "/v1/books/ann/contacts/1", `{"notes":"Drums"}`).StatusCode)
+
+	// Whole book shared read-write
+	require.Equal(t, http.StatusNoContent, do("ann", "PUT", "/books/ann/shares/test:cid", `{"access":"write"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, do("cid", "GET", "/v1/books/ann/contacts", "").StatusCode)
+	assert.Equal(t, http.StatusCreated, do("cid", "POST", "/v1/books/ann/contacts", `{"name":"Ringo","lastName":"Starr","email":"ringo@beatles.com"}`).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("cid", "PUT", "/books/ann/shares/test:bob", `{"access":"write"}`).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("cid", "DELETE", "/books/ann", "").StatusCode)
+
+	resp := do("bob", "GET", "/shared", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var shared []server.SharedItem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shared))
+	assert.Equal(t, []server.SharedItem{{Book: "ann", Owner: "test:ann", ContactId: 1, Access: server.AccessRead}}, shared)
+
+	resp = do("cid", "GET", "/books", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var books []server.Book
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&books))
+	require.Len(t, books, 2)
+	assert.Equal(t, "test:ann", books[0].Owner)
+	resp = do("bob", "GET", "/books", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&books))
+	assert.Len(t, books, 1)
+
+	resp = do("ann", "GET", "/books/ann/shares", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var shares []server.Share
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shares))
+	assert.Equal(t, []server.Share{
+		{Subject: "test:cid", Access: server.AccessWrite},
+		{Subject: "test:bob", ContactId: 1, Access: server.AccessRead},
+	}, shares)
+
+	// Shares of deleted contacts are dropped, so new contacts reusing the id stay private
+	require.Equal(t, http.StatusNoContent, do("ann", "DELETE", "/v1/books/ann/contacts/1", "").StatusCode)
+	resp = do("bob", "GET", "/shared", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shared))
+	assert.Empty(t, shared)
+
+	require.Equal(t, http.StatusNoContent, do("ann", "DELETE", "/books/ann/shares/test:cid", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("cid", "GET", "/v1/books/ann/contacts", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do("ann", "DELETE", "/books/ann/shares/test:cid", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, do("ann", "DELETE", "/books/ann", "").StatusCode)
+}
+
+func TestRestRestoreDropsContactShares(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	policy := server.DefaultPolicy()
+	policy.Subjects["test:ann"] = []string{server.RoleAdmin}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(subject string, method string, path string, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", subject)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	for _, book := range []string{"ann", "ann-old"} {
+		require.Equal(t, http.StatusCreated, do("ann", "POST", "/books", `{"name":"`+book+`","personal":true}`).StatusCode)
+	}
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/v1/books/ann/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`).StatusCode)
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/v1/books/ann-old/contacts", `{"name":"Stuart","lastName":"Sutcliffe","email":"stu@beatles.com"}`).StatusCode)
+	require.Equal(t, http.StatusNoContent, do("ann", "PUT", "/books/ann/contacts/1/shares/test:bob", `{"access":"read"}`).StatusCode)
+	require.Equal(t, http.StatusNoContent, do("ann", "PUT", "/books/ann/shares/test:cid", `{"access":"read"}`).StatusCode)
+	require.Equal(t, http.StatusOK, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+
+	resp := do("ann", "GET", "/admin/backup?book=ann-old", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	backup, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Invalid backups restore nothing, so the contacts keep their shares
+	tampered := strings.Replace(string(backup), "Stuart", "Stu", 1)
+	require.Equal(t, http.StatusBadRequest, do("ann", "POST", "/admin/restore?book=ann", tampered).StatusCode)
+	require.Equal(t, http.StatusOK, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+
+	require.Equal(t, http.StatusOK, do("ann", "POST", "/admin/restore?book=ann", string(backup)).StatusCode)
+
+	// Contact 1 is somebody else now, while the book stays shared
+	assert.Equal(t, http.StatusForbidden, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+	assert.Equal(t, http.StatusOK, do("cid", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+}
+
+func TestRestLdifDeleteDropsContactShares(t *testing.T) {
+	restServer, err := server.NewRestServer(server.NewMemoryDatabase(), filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	policy := server.DefaultPolicy()
+	policy.Subjects["test:ann"] = []string{server.RoleAdmin}
+	testServer := httptest.NewServer(restServer.WithAuthenticator(subjectAuthenticator{}).WithPolicy(policy).Handler())
+	defer testServer.Close()
+
+	do := func(subject string, method string, path string, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Test-Subject", subject)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/books", `{"name":"ann","personal":true}`).StatusCode)
+	require.Equal(t, http.StatusCreated, do("ann", "POST", "/v1/books/ann/contacts", `{"name":"Pete","lastName":"Best","email":"pete@beatles.com"}`).StatusCode)
+	require.Equal(t, http.StatusNoContent, do("ann", "PUT", "/books/ann/contacts/1/shares/test:bob", `{"access":"read"}`).StatusCode)
+	require.Equal(t, http.StatusOK, do("bob", "GET", "/v1/books/ann/contacts/1", "").StatusCode)
+
+	ldif := "version: 1\n\ndn: uid=1,ou=contacts,dc=example,dc=com\nchangetype: delete\n"
+	require.Equal(t, http.StatusOK, do("ann", "POST", "/v1/books/ann/contacts/import.ldif", ldif).StatusCode)
+
+	resp := do("bob", "GET", "/shared", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var shared []server.SharedItem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shared))
+	assert.Empty(t, shared)
+}
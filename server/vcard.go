@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+
+	VCardContentType = "text/vcard"
+
+	// Line length limit in octets, excluding line break
+	vCardLineLimit = 75
+)
+
+type vCardProperty struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+func (p *vCardProperty) param(name string) string {
+	values := p.Params[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Reads unfolded logical lines - a line starting with space or tab continues the previous one
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		// vCard 2.1 quoted-printable soft line breaks
+		if len(lines) > 0 && strings.HasSuffix(lines[len(lines)-1], "=") &&
+			strings.Contains(strings.ToUpper(lines[len(lines)-1]), "QUOTED-PRINTABLE") {
+			lines[len(lines)-1] += "\r\n" + line
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// Splits on sep, ignoring separators inside double quotes
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseVCardProperty(line string) (*vCardProperty, error) {
+	colon := -1
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		if line[i] == '"' {
+			inQuotes = !inQuotes
+		} else if line[i] == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid vCard line %q", line)
+	}
+
+	parts := splitQuoted(line[:colon], ';')
+	name := strings.ToUpper(parts[0])
+	// Strip group, ie. "item1.EMAIL"
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	prop := &vCardProperty{Name: name, Params: make(map[string][]string), Value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		key := strings.ToUpper(kv[0])
+		if len(kv) == 1 {
+			// vCard 2.1 bare parameters, ie. "TEL;CELL:..."
+			prop.Params["TYPE"] = append(prop.Params["TYPE"], key)
+			continue
+		}
+		for _, value := range splitQuoted(kv[1], ',') {
+			prop.Params[key] = append(prop.Params[key], strings.Trim(value, `"`))
+		}
+	}
+
+	if err := prop.decodeValue(); err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+func (p *vCardProperty) decodeValue() error {
+	if strings.EqualFold(p.param("ENCODING"), "QUOTED-PRINTABLE") {
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(p.Value)))
+		if err != nil {
+			return err
+		}
+		p.Value = string(decoded)
+	}
+
+	switch strings.ToUpper(p.param("CHARSET")) {
+	case "", "UTF-8", "US-ASCII":
+	case "ISO-8859-1", "LATIN1", "WINDOWS-1252":
+		// Latin1 bytes map directly to first 256 unicode code points
+		runes := make([]rune, len(p.Value))
+		for i := 0; i < len(p.Value); i++ {
+			runes[i] = rune(p.Value[i])
+		}
+		p.Value = string(runes)
+	default:
+		return fmt.Errorf("unsupported vCard charset %q", p.param("CHARSET"))
+	}
+
+	if !utf8.ValidString(p.Value) {
+		return errors.New("invalid UTF-8 in vCard value")
+	}
+	return nil
+}
+
+func unescapeVCardText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Splits structured value on unescaped sep and unescapes components
+func splitVCardComponents(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, unescapeVCardText(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescapeVCardText(s[start:]))
+}
+
+func escapeVCardText(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func contactFromVCard(props []*vCardProperty) Contact {
+	var contact Contact
+	var fullName string
+
+	for _, prop := range props {
+		switch prop.Name {
+		case "N":
+			components := splitVCardComponents(prop.Value, ';')
+			contact.LastName = strings.TrimSpace(components[0])
+			if len(components) > 1 {
+				contact.Name = strings.TrimSpace(components[1])
+			}
+		case "FN":
+			fullName = strings.TrimSpace(unescapeVCardText(prop.Value))
+		case "EMAIL":
+			if contact.Email == "" {
+				contact.Email = strings.TrimSpace(unescapeVCardText(prop.Value))
+			}
+		case "TEL":
+			phone := strings.TrimPrefix(strings.TrimSpace(unescapeVCardText(prop.Value)), "tel:")
+			if phone != "" {
+				contact.Phones = append(contact.Phones, phone)
+			}
+		case "ADR":
+			if contact.Address != "" {
+				continue
+			}
+			var components []string
+			for _, component := range splitVCardComponents(prop.Value, ';') {
+				if component = strings.TrimSpace(component); component != "" {
+					components = append(components, component)
+				}
+			}
+			contact.Address = strings.Join(components, ", ")
+		case "NOTE":
+			contact.Notes = unescapeVCardText(prop.Value)
+		}
+	}
+
+	// Fallback for cards without structured name
+	if contact.Name == "" && contact.LastName == "" && fullName != "" {
+		if space := strings.LastIndex(fullName, " "); space >= 0 {
+			contact.Name, contact.LastName = fullName[:space], fullName[space+1:]
+		} else {
+			contact.Name = fullName
+		}
+	}
+
+	return contact
+}
+
+// Reads all contacts from a (possibly multi-contact) vCard 2.1, 3.0 or 4.0 stream. Ids are not imported
+func ReadVCards(r io.Reader) ([]Contact, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []Contact
+	var props []*vCardProperty
+	inCard := false
+
+	for n, line := range lines {
+		prop, err := parseVCardProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch {
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VCARD"):
+			if inCard {
+				return nil, fmt.Errorf("line %d: nested vCard", n+1)
+			}
+			inCard = true
+			props = nil
+		case prop.Name == "END" && strings.EqualFold(prop.Value, "VCARD"):
+			if !inCard {
+				return nil, fmt.Errorf("line %d: END without BEGIN", n+1)
+			}
+			inCard = false
+			contacts = append(contacts, contactFromVCard(props))
+		case inCard:
+			props = append(props, prop)
+		default:
+			return nil, fmt.Errorf("line %d: property outside of vCard", n+1)
+		}
+	}
+
+	if inCard {
+		return nil, errors.New("unterminated vCard")
+	}
+	return contacts, nil
+}
+
+// Writes a line folded to the line limit, never splitting a multi-byte character
+func writeVCardLine(w *bufio.Writer, line string) {
+	limit := vCardLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// Leading space of continuation line counts into the limit
+		limit = vCardLineLimit - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func supportedVCardVersion(version string) bool {
+	return version == VCardVersion3 || version == VCardVersion4
+}
+
+// Writes the contact as a single vCard of given version
+func WriteVCard(w io.Writer, contact Contact, version string) error {
+	if !supportedVCardVersion(version) {
+		return fmt.Errorf("unsupported vCard version %q", version)
+	}
+
+	buf := bufio.NewWriter(w)
+	writeVCardLine(buf, "BEGIN:VCARD")
+	writeVCardLine(buf, "VERSION:"+version)
+	writeVCardLine(buf, "UID:"+strconv.Itoa(contact.Id))
+	writeVCardLine(buf, "FN:"+escapeVCardText(strings.TrimSpace(contact.Name+" "+contact.LastName)))
+	writeVCardLine(buf, "N:"+escapeVCardText(contact.LastName)+";"+escapeVCardText(contact.Name)+";;;")
+
+	if contact.Email != "" {
+		if version == VCardVersion3 {
+			writeVCardLine(buf, "EMAIL;TYPE=INTERNET:"+escapeVCardText(contact.Email))
+		} else {
+			writeVCardLine(buf, "EMAIL:"+escapeVCardText(contact.Email))
+		}
+	}
+	for _, phone := range contact.Phones {
+		if version == VCardVersion3 {
+			writeVCardLine(buf, "TEL;TYPE=VOICE:"+escapeVCardText(phone))
+		} else {
+			writeVCardLine(buf, "TEL;VALUE=text;TYPE=voice:"+escapeVCardText(phone))
+		}
+	}
+	if contact.Address != "" {
+		writeVCardLine(buf, "ADR:;;"+escapeVCardText(contact.Address)+";;;;")
+	}
+	if contact.Notes != "" {
+		writeVCardLine(buf, "NOTE:"+escapeVCardText(contact.Notes))
+	}
+
+	writeVCardLine(buf, "END:VCARD")
+	return buf.Flush()
+}
+
+func WriteVCards(w io.Writer, contacts []Contact, version string) error {
+	for _, contact := range contacts {
+		if err := WriteVCard(w, contact, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/contacts/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVCardRoundTrip(t *testing.T) {
+	contact := server.Contact{
+		Id:       7,
+		Name:     "Paul",
+		LastName: "McCartney",
+		Email:    "paul.mccartney@thebeatles.com",
+		Phones:   []string{"+44 20 7946 0000", "+44 20 7946 0001"},
+		Address:  "20 Forthlin Road, Liverpool",
+		Notes:    "Bass; vocals\nLeft-handed, " + strings.Repeat("very long note ", 10),
+	}
+
+	for _, version := range []string{server.VCardVersion3, server.VCardVersion4} {
+		var buf bytes.Buffer
+		require.NoError(t, server.WriteVCard(&buf, contact, version))
+
+		for _, line := range strings.Split(buf.String(), "\r\n") {
+			assert.LessOrEqual(t, len(line), 75, "line not folded")
+		}
+
+		contacts, err := server.ReadVCards(&buf)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(contacts))
+
+		expected := contact
+		expected.Id = 0
+		assert.Equal(t, expected, contacts[0], "version %s", version)
+	}
+}
+
+func TestReadVCardsMultipleAndLegacy(t *testing.T) {
+	data := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Lennon;John;;;\r\n" +
+		"FN:John Lennon\r\n" +
+		"item1.EMAIL;TYPE=INTERNET,HOME:john.lennon@\r\n" +
+		" thebeatles.com\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"FN:George Harrison\r\n" +
+		"TEL;CELL:123\r\n" +
+		"NOTE;CHARSET=ISO-8859-1;ENCODING=QUOTED-PRINTABLE:Caf=E9=\r\n" +
+		" au lait\r\n" +
+		"EMAIL:george.harrison@thebeatles.com\r\n" +
+		"END:VCARD\r\n"
+
+	contacts, err := server.ReadVCards(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(contacts))
+
+	assert.Equal(t, server.Contact{Name: "John", LastName: "Lennon", Email: "john.lennon@thebeatles.com"}, contacts[0])
+	assert.Equal(t, server.Contact{
+		Name:     "George",
+		LastName: "Harrison",
+		Email:    "george.harrison@thebeatles.com",
+		Phones:   []string{"123"},
+		Notes:    "Café au lait",
+	}, contacts[1])
+}
+
+func TestReadVCardsInvalid(t *testing.T) {
+	inputs := []string{
+		"BEGIN:VCARD\r\nFN:John\r\n",
+		"FN:John\r\n",
+		"BEGIN:VCARD\r\nno colon\r\nEND:VCARD\r\n",
+		"BEGIN:VCARD\r\nNOTE;CHARSET=KOI8-R:x\r\nEND:VCARD\r\n",
+	}
+
+	for _, input := range inputs {
+		_, err := server.ReadVCards(strings.NewReader(input))
+		assert.Error(t, err, "input %q", input)
+	}
+}
+
+func TestRestVCardExportVersions(t *testing.T) {
+	testServer := newTestServer(t)
+
+	for _, path := range []string{"/v1/contacts/1.vcf", "/v1/contacts/export.vcf"} {
+		resp := doRequest(t, "GET", testServer.URL+path+"?version=4.0", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		resp = doRequest(t, "GET", testServer.URL+path+"?version=2.1", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+		assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"), path)
+	}
+}